@@ -6,6 +6,11 @@ import (
 
 	cliapp "exusiai.dev/backend-next/cmd/app/cli"
 	script_migrate_drop_report_extras_cols "exusiai.dev/backend-next/cmd/app/cli/runscript/scripts/at20230110-migrate_drop_report_extras_cols"
+	script_add_accounts_moderation_cols "exusiai.dev/backend-next/cmd/app/cli/runscript/scripts/at20261019-add_accounts_moderation_cols"
+	script_add_trend_elements_granularity "exusiai.dev/backend-next/cmd/app/cli/runscript/scripts/at20261019-add_trend_elements_granularity"
+	script_backfill_trend_elements "exusiai.dev/backend-next/cmd/app/cli/runscript/scripts/at20261019-backfill_trend_elements"
+	script_create_account_merges "exusiai.dev/backend-next/cmd/app/cli/runscript/scripts/at20261019-create_account_merges"
+	script_create_probe_events "exusiai.dev/backend-next/cmd/app/cli/runscript/scripts/at20261019-create_probe_events"
 )
 
 func depsFn[T any]() func() T {
@@ -22,6 +27,11 @@ func Command() *cli.Command {
 		Description: "run maintenance go scripts",
 		Subcommands: []*cli.Command{
 			script_migrate_drop_report_extras_cols.Command(depsFn[script_migrate_drop_report_extras_cols.CommandDeps]()),
+			script_add_trend_elements_granularity.Command(depsFn[script_add_trend_elements_granularity.CommandDeps]()),
+			script_backfill_trend_elements.Command(depsFn[script_backfill_trend_elements.CommandDeps]()),
+			script_create_probe_events.Command(depsFn[script_create_probe_events.CommandDeps]()),
+			script_create_account_merges.Command(depsFn[script_create_account_merges.CommandDeps]()),
+			script_add_accounts_moderation_cols.Command(depsFn[script_add_accounts_moderation_cols.CommandDeps]()),
 		},
 	}
 }
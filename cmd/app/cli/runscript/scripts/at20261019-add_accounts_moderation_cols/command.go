@@ -0,0 +1,23 @@
+package script_add_accounts_moderation_cols
+
+import (
+	"github.com/uptrace/bun"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/fx"
+)
+
+type CommandDeps struct {
+	fx.In
+
+	DB *bun.DB
+}
+
+func Command(depsFn func() CommandDeps) *cli.Command {
+	return &cli.Command{
+		Name:        "add_accounts_moderation_cols",
+		Description: "add (status, trust_score, moderation_reason, moderated_at) columns to `accounts` table",
+		Action: func(ctx *cli.Context) error {
+			return run(depsFn())
+		},
+	}
+}
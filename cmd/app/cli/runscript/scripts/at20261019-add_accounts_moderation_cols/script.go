@@ -0,0 +1,27 @@
+package script_add_accounts_moderation_cols
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+func run(deps CommandDeps) error {
+	db := deps.DB
+
+	log.Info().Msg("running script")
+
+	_, err := db.Exec(`ALTER TABLE accounts
+		ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active',
+		ADD COLUMN IF NOT EXISTS trust_score INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS moderation_reason TEXT,
+		ADD COLUMN IF NOT EXISTS moderated_at TIMESTAMPTZ`)
+	if err != nil {
+		return errors.Wrap(err, "failed to add moderation columns to accounts table")
+	}
+
+	log.Info().Msg("moderation columns added to accounts table")
+
+	log.Info().Msg("script finished")
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package script_add_trend_elements_granularity
+
+import (
+	"github.com/uptrace/bun"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/fx"
+)
+
+type CommandDeps struct {
+	fx.In
+
+	DB *bun.DB
+}
+
+func Command(depsFn func() CommandDeps) *cli.Command {
+	return &cli.Command{
+		Name:        "add_trend_elements_granularity",
+		Description: "add (granularity, backfilled) columns to `trend_elements` table",
+		Action: func(ctx *cli.Context) error {
+			return run(depsFn())
+		},
+	}
+}
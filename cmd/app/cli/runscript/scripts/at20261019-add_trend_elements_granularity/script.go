@@ -0,0 +1,38 @@
+package script_add_trend_elements_granularity
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+func run(deps CommandDeps) error {
+	db := deps.DB
+
+	log.Info().Msg("running script")
+
+	// existing elements are all daily elements calculated by the trend worker
+	_, err := db.Exec(`ALTER TABLE trend_elements ADD COLUMN granularity TEXT NOT NULL DEFAULT '1d'`)
+	if err != nil {
+		return errors.Wrap(err, "failed to add granularity column to trend_elements table")
+	}
+
+	log.Info().Msg("granularity column added to trend_elements table")
+
+	_, err = db.Exec(`ALTER TABLE trend_elements ADD COLUMN backfilled BOOLEAN NOT NULL DEFAULT FALSE`)
+	if err != nil {
+		return errors.Wrap(err, "failed to add backfilled column to trend_elements table")
+	}
+
+	log.Info().Msg("backfilled column added to trend_elements table")
+
+	_, err = db.Exec(`CREATE INDEX trend_elements_server_granularity_source_category_idx ON trend_elements (server, granularity, source_category)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create index on (server, granularity, source_category) columns of trend_elements table")
+	}
+
+	log.Info().Msg("index created on (server, granularity, source_category) columns of trend_elements table")
+
+	log.Info().Msg("script finished")
+
+	return nil
+}
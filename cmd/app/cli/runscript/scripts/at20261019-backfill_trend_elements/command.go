@@ -0,0 +1,54 @@
+package script_backfill_trend_elements
+
+import (
+	"exusiai.dev/gommon/constant"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/fx"
+
+	"exusiai.dev/backend-next/internal/service"
+)
+
+type CommandDeps struct {
+	fx.In
+
+	TrendService *service.Trend
+}
+
+func Command(depsFn func() CommandDeps) *cli.Command {
+	return &cli.Command{
+		Name:        "backfill_trend_elements",
+		Description: "backfill trend elements of a granularity for a historical time window, e.g. for time ranges that predate the trend worker",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "server",
+				Usage:    "server to backfill",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "granularity",
+				Usage: "granularity of the trend elements: 1h, 1d or 1w",
+				Value: "1d",
+			},
+			&cli.TimestampFlag{
+				Name:     "start",
+				Usage:    "start of the time window, e.g. 2019-05-01T00:00:00Z",
+				Layout:   "2006-01-02T15:04:05Z07:00",
+				Required: true,
+			},
+			&cli.TimestampFlag{
+				Name:     "end",
+				Usage:    "end of the time window, e.g. 2021-01-01T00:00:00Z",
+				Layout:   "2006-01-02T15:04:05Z07:00",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:  "source-category",
+				Usage: "source categories to backfill",
+				Value: cli.NewStringSlice(constant.SourceCategoryAll),
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			return run(ctx, depsFn())
+		},
+	}
+}
@@ -0,0 +1,34 @@
+package script_backfill_trend_elements
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"exusiai.dev/backend-next/internal/model"
+)
+
+func run(ctx *cli.Context, deps CommandDeps) error {
+	server := ctx.String("server")
+	granularity := ctx.String("granularity")
+	if granularity != model.TrendGranularityHour && granularity != model.TrendGranularityDay && granularity != model.TrendGranularityWeek {
+		return errors.Errorf("unknown granularity %s: available granularities are: 1h, 1d, 1w", granularity)
+	}
+	startTime := ctx.Timestamp("start")
+	endTime := ctx.Timestamp("end")
+
+	log.Info().
+		Str("server", server).
+		Str("granularity", granularity).
+		Time("start", *startTime).
+		Time("end", *endTime).
+		Msg("running script")
+
+	if err := deps.TrendService.BackfillTrendElements(ctx.Context, server, granularity, *startTime, *endTime, ctx.StringSlice("source-category")); err != nil {
+		return errors.Wrap(err, "failed to backfill trend elements")
+	}
+
+	log.Info().Msg("script finished")
+
+	return nil
+}
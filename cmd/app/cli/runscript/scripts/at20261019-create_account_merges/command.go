@@ -0,0 +1,23 @@
+package script_create_account_merges
+
+import (
+	"github.com/uptrace/bun"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/fx"
+)
+
+type CommandDeps struct {
+	fx.In
+
+	DB *bun.DB
+}
+
+func Command(depsFn func() CommandDeps) *cli.Command {
+	return &cli.Command{
+		Name:        "create_account_merges",
+		Description: "create `account_merges` table for the audit records of account merges",
+		Action: func(ctx *cli.Context) error {
+			return run(depsFn())
+		},
+	}
+}
@@ -0,0 +1,33 @@
+package script_create_account_merges
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+func run(deps CommandDeps) error {
+	db := deps.DB
+
+	log.Info().Msg("running script")
+
+	_, err := db.Exec(`CREATE TABLE account_merges (
+		merge_id BIGSERIAL PRIMARY KEY,
+		source_account_id INTEGER NOT NULL,
+		source_penguin_id TEXT NOT NULL,
+		target_account_id INTEGER NOT NULL,
+		target_penguin_id TEXT NOT NULL,
+		reports INTEGER NOT NULL DEFAULT 0,
+		defects INTEGER NOT NULL DEFAULT 0,
+		initiator TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create account_merges table")
+	}
+
+	log.Info().Msg("account_merges table created")
+
+	log.Info().Msg("script finished")
+
+	return nil
+}
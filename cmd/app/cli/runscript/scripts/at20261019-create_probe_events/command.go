@@ -0,0 +1,23 @@
+package script_create_probe_events
+
+import (
+	"github.com/uptrace/bun"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/fx"
+)
+
+type CommandDeps struct {
+	fx.In
+
+	DB *bun.DB
+}
+
+func Command(depsFn func() CommandDeps) *cli.Command {
+	return &cli.Command{
+		Name:        "create_probe_events",
+		Description: "create `probe_events` table for frontend telemetry",
+		Action: func(ctx *cli.Context) error {
+			return run(depsFn())
+		},
+	}
+}
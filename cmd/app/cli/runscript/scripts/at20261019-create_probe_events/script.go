@@ -0,0 +1,49 @@
+package script_create_probe_events
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+func run(deps CommandDeps) error {
+	db := deps.DB
+
+	log.Info().Msg("running script")
+
+	_, err := db.Exec(`CREATE TABLE probe_events (
+		event_id BIGSERIAL PRIMARY KEY,
+		type TEXT NOT NULL,
+		language TEXT NOT NULL,
+		country TEXT NOT NULL DEFAULT '',
+		server TEXT NOT NULL DEFAULT '',
+		path TEXT NOT NULL DEFAULT '',
+		stage_id TEXT NOT NULL DEFAULT '',
+		item_id TEXT NOT NULL DEFAULT '',
+		query TEXT NOT NULL DEFAULT '',
+		shape TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create probe_events table")
+	}
+
+	log.Info().Msg("probe_events table created")
+
+	_, err = db.Exec(`CREATE INDEX probe_events_type_created_at_idx ON probe_events (type, created_at)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create index on (type, created_at) columns of probe_events table")
+	}
+
+	log.Info().Msg("index created on (type, created_at) columns of probe_events table")
+
+	_, err = db.Exec(`CREATE INDEX probe_events_created_at_idx ON probe_events (created_at)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create index on created_at column of probe_events table")
+	}
+
+	log.Info().Msg("index created on created_at column of probe_events table")
+
+	log.Info().Msg("script finished")
+
+	return nil
+}
@@ -10,8 +10,10 @@ import (
 	"exusiai.dev/backend-next/internal/controller"
 	"exusiai.dev/backend-next/internal/infra"
 	"exusiai.dev/backend-next/internal/model/cache"
+	"exusiai.dev/backend-next/internal/pkg/cdnpurge"
 	"exusiai.dev/backend-next/internal/pkg/crypto"
 	"exusiai.dev/backend-next/internal/pkg/logger"
+	"exusiai.dev/backend-next/internal/pkg/ratelimit"
 	"exusiai.dev/backend-next/internal/repo"
 	"exusiai.dev/backend-next/internal/server"
 	"exusiai.dev/backend-next/internal/service"
@@ -37,6 +39,9 @@ func Options(ctx appcontext.Ctx, additionalOpts ...fx.Option) []fx.Option {
 		// Misc
 		fx.Supply(conf),
 		fx.Provide(crypto.NewCrypto),
+		fx.Provide(cdnpurge.New),
+		fx.Provide(ratelimit.New),
+		fx.Provide(func(session *service.Session) ratelimit.Sessions { return session }),
 
 		// Infrastructures
 		infra.Module(),
@@ -18,6 +18,24 @@ type ConfigSpec struct {
 	// LogJsonStdout is whether to log JSON logs (instead of pretty-print logs) to stdout for the ease of log collection.
 	LogJsonStdout bool `split_words:"true" default:"false"`
 
+	// RateLimitEnabled enables the Redis-backed rate limits of the route groups below.
+	RateLimitEnabled bool `split_words:"true" default:"true"`
+
+	// RateLimitReport, RateLimitQuery, RateLimitQueryV3, RateLimitRecognitionInit and RateLimitAuth are the rate
+	// limit policies of report submission, v2 advanced queries, v3 queries, recognition defect report
+	// initialization and PenguinID authentication respectively. Each is a list of rules in the form of
+	// `<key>:<limit>/<window>`, where key is one of `penguinid`, `ip` (as resolved through TrustedProxies) and
+	// `source` (the report source), e.g. `penguinid:60/1m,ip:600/5m`. A request is rejected once any rule of its
+	// group is exceeded. `penguinid` counts requests presenting a session token by its verified account, but the
+	// PenguinID counted otherwise is whatever the client presents and is not verified, so a client may spread its
+	// requests over made-up PenguinIDs: `penguinid` rules only keep well-behaved clients behind a shared IP apart,
+	// and an `ip` rule is what actually bounds a group.
+	RateLimitReport          []string `split_words:"true" default:"penguinid:120/5m,ip:600/5m"`
+	RateLimitQuery           []string `split_words:"true" default:"ip:30/5m"`
+	RateLimitQueryV3         []string `split_words:"true" default:"ip:60/5m"`
+	RateLimitRecognitionInit []string `split_words:"true" default:"ip:60/5m"`
+	RateLimitAuth            []string `split_words:"true" default:"ip:30/5m"`
+
 	// TrustedProxies is a list of trusted proxies that are trusted to report a real IP via the X-Forwarded-For header.
 	TrustedProxies []string `required:"true" split_words:"true" default:"::1,127.0.0.1,10.0.0.0/8"`
 
@@ -59,6 +77,39 @@ type ConfigSpec struct {
 	// for more information on how to construct a Redis URL.
 	RedisURL string `required:"true" split_words:"true" default:"redis://127.0.0.1:6379/1"`
 
+	// CacheL2Enabled enables the Redis-backed L2 tier behind the in-memory caches, so that instances share
+	// cached results instead of recalculating them. The caches fall back to the in-memory tier during an L2 outage.
+	CacheL2Enabled bool `split_words:"true" default:"false"`
+
+	// CacheL2Timeout is the timeout of every L2 cache operation.
+	CacheL2Timeout time.Duration `required:"true" split_words:"true" default:"200ms"`
+
+	// CacheL2DefaultTTL is the TTL of L2 cache entries of caches without a TTL in CacheL2TTLs.
+	CacheL2DefaultTTL time.Duration `required:"true" split_words:"true" default:"1h"`
+
+	// CacheL2TTLs are the per-cache TTLs of L2 cache entries, keyed by the cache name, e.g.
+	// `shimSiteStats#server:10m,account#accountId:0s`. A zero TTL disables the L2 tier for that cache.
+	CacheL2TTLs map[string]time.Duration `split_words:"true" default:"account#accountId:0s,account#penguinId:0s,lastModifiedTime#key:0s"`
+
+	// CacheStaleWhileRevalidate is the duration expensive result caches (matrix, pattern, trend and site stats)
+	// keep serving their stale values for after expiration, while recalculating them in the background.
+	CacheStaleWhileRevalidate time.Duration `required:"true" split_words:"true" default:"1h"`
+
+	// CacheWarmUpEnabled enables preloading result caches at startup. The instance reports itself
+	// as not ready in /health until the warm-up has finished or timed out.
+	CacheWarmUpEnabled bool `split_words:"true" default:"false"`
+
+	// CacheWarmUpServers is the list of servers to preload result caches for.
+	CacheWarmUpServers []string `required:"true" split_words:"true" default:"CN,US,JP,KR"`
+
+	// CacheWarmUpSourceCategories is the list of source categories to preload result caches for.
+	// Available categories are: all, automated, manual.
+	CacheWarmUpSourceCategories []string `required:"true" split_words:"true" default:"all"`
+
+	// CacheWarmUpTimeout is the maximum duration of the warm-up. The instance reports itself as ready
+	// after it even if the warm-up has not finished.
+	CacheWarmUpTimeout time.Duration `required:"true" split_words:"true" default:"5m"`
+
 	// SentryDSN is the DSN of the Sentry server. See https://pkg.go.dev/github.com/getsentry/sentry-go#ClientOptions
 	SentryDSN string `split_words:"true"`
 
@@ -68,6 +119,69 @@ type ConfigSpec struct {
 	// LiveHouseGRPCAddress is the address of the LiveHouse gRPC server.
 	LiveHouseGRPCAddress string `split_words:"true" default:"localhost:9015"`
 
+	// LiveHouseMode is the implementation of LiveHouse reported to, either "grpc" for the external LiveHouse
+	// service at LiveHouseGRPCAddress, or "embedded" for the built-in one keeping the live matrices in Redis.
+	LiveHouseMode string `split_words:"true" default:"grpc"`
+
+	// LiveHouseGenerations is the number of the latest generations of the live matrices kept by the embedded
+	// LiveHouse. A generation is started by every matrix calculation of the worker.
+	LiveHouseGenerations int `required:"true" split_words:"true" default:"3"`
+
+	// LiveHouseQueueSize is the maximum number of reports waiting to be pushed to LiveHouse.
+	LiveHouseQueueSize int `required:"true" split_words:"true" default:"10000"`
+
+	// LiveHouseQueueOverflow is the policy of the LiveHouse queue when full: "drop-oldest" discards the oldest
+	// report waiting, and "drop-newest" discards the report being queued.
+	LiveHouseQueueOverflow string `required:"true" split_words:"true" default:"drop-oldest"`
+
+	// LiveHouseBatchSize is the maximum number of reports pushed to LiveHouse in a single batch.
+	LiveHouseBatchSize int `required:"true" split_words:"true" default:"500"`
+
+	// LiveHouseRetryAttempts is the number of attempts to push a batch to LiveHouse, with exponential backoff
+	// starting from LiveHouseRetryDelay and capped at LiveHouseRetryMaxDelay in-between.
+	LiveHouseRetryAttempts uint `required:"true" split_words:"true" default:"5"`
+
+	LiveHouseRetryDelay    time.Duration `required:"true" split_words:"true" default:"1s"`
+	LiveHouseRetryMaxDelay time.Duration `required:"true" split_words:"true" default:"30s"`
+
+	// LiveHouseSpillEnabled spills the batches failed to be pushed to LiveHouse after all attempts to Redis,
+	// instead of dropping them. Spilled batches are pushed again once LiveHouse is back, even after a restart.
+	LiveHouseSpillEnabled bool `split_words:"true" default:"false"`
+
+	// LiveHouseSpillMaxBatches is the maximum number of batches kept spilled in Redis. The oldest batches are
+	// dropped when more batches are spilled.
+	LiveHouseSpillMaxBatches int `required:"true" split_words:"true" default:"1000"`
+
+	// LiveFlushInterval is the interval at which the drops accepted by the workers are published to the
+	// /v3/live subscribers of every instance.
+	LiveFlushInterval time.Duration `required:"true" split_words:"true" default:"1s"`
+
+	// LiveHeartbeatInterval is the interval at which /v3/live connections are pinged. A connection not
+	// answering within twice the interval is closed.
+	LiveHeartbeatInterval time.Duration `required:"true" split_words:"true" default:"30s"`
+
+	// LiveMaxSubscriptions is the maximum number of subscriptions of a single /v3/live connection.
+	LiveMaxSubscriptions int `required:"true" split_words:"true" default:"32"`
+
+	// LiveSendBuffer is the number of updates buffered for a single /v3/live connection. A connection
+	// falling further behind is closed.
+	LiveSendBuffer int `required:"true" split_words:"true" default:"64"`
+
+	// LiveReplayBufferSize is the approximate number of the latest live updates kept in Redis, for
+	// /v3/live/events clients to resume from with Last-Event-ID after a brief disconnect.
+	LiveReplayBufferSize int `required:"true" split_words:"true" default:"300"`
+
+	// ProbeFlushInterval is the interval at which the frontend probe events received are saved in batches.
+	ProbeFlushInterval time.Duration `required:"true" split_words:"true" default:"10s"`
+
+	// ProbeQueueSize is the maximum number of probe events waiting to be saved. Events received while full
+	// are dropped.
+	ProbeQueueSize int `required:"true" split_words:"true" default:"10000"`
+
+	// ProbeRetention is the duration probe events are kept for. Older events are purged hourly, and aggregates
+	// are limited to the retention.
+	ProbeRetention time.Duration `required:"true" split_words:"true" default:"720h"`
+
 	// DatadogProfilerEnabled to indicate whether to enable Datadog profiler.
 	DatadogProfilerEnabled bool `split_words:"true" default:"false"`
 
@@ -112,6 +226,12 @@ type ConfigSpec struct {
 	// WorkerTrendEnabled describes whether to enable the trend worker
 	WorkerTrendEnabled bool `required:"true" split_words:"true" default:"true"`
 
+	// TrendGranularities describes the trend granularities the trend worker calculates and stores, chosen per server
+	// and stage type. Available granularities are: 1h (for the first 3 days of a stage), 1d and 1w.
+	// Daily trends are always calculated regardless of this setting, as the v2 API depends on them.
+	// See TrendGranularityMap for the format.
+	TrendGranularities TrendGranularityMap `required:"true" split_words:"true" default:"*/*:1d"`
+
 	// WorkerSeparation describes the separation time in-between different microtasks
 	WorkerSeparation time.Duration `required:"true" split_words:"true" default:"3s"`
 
@@ -132,6 +252,121 @@ type ConfigSpec struct {
 	// MatrixWorkerSourceCategories is a list of categories that the matrix worker will run for.
 	// Available categories are: all, automated, manual.
 	MatrixWorkerSourceCategories []string `required:"true" split_words:"true" default:"all"`
+
+	// WorkerBiasDetectionEnabled describes whether the stats worker compares automated and manual drop rates
+	// after every batch, to detect recognizer bugs or selective reporting.
+	WorkerBiasDetectionEnabled bool `split_words:"true" default:"false"`
+
+	// WorkerSnapshotEnabled describes whether the worker snapshots the rendered matrix, pattern and trend payloads
+	// for incremental updates after every successful batch. Unchanged payloads are not snapshotted again.
+	WorkerSnapshotEnabled bool `split_words:"true" default:"false"`
+
+	// SnapshotRetentionCount is the number of latest snapshots of every realm kept regardless of their age.
+	// The latest snapshot is always kept.
+	SnapshotRetentionCount int `required:"true" split_words:"true" default:"100"`
+
+	// SnapshotRetentionPeriod is the duration within which snapshots are kept regardless of their count.
+	// Snapshots beyond both SnapshotRetentionCount and SnapshotRetentionPeriod are deleted after every
+	// snapshot. Setting both to 0 disables the deletion.
+	SnapshotRetentionPeriod time.Duration `required:"true" split_words:"true" default:"720h"`
+
+	// SnapshotPrecomputedDiffs is the number of previous versions whose diffs to a newly saved snapshot are
+	// precomputed and stored in Redis. Diffs between other versions are served as a chain of precomputed diffs
+	// when there is one, and are otherwise computed on demand from the two snapshots and stored in Redis as well.
+	// An on-demand diff is about the size of the newer snapshot at most for bsdiff and JSON merge patches, while a
+	// JSON patch carries the path of every changed value besides the value itself, and may outgrow the newer
+	// snapshot when most of its values have changed.
+	SnapshotPrecomputedDiffs int `required:"true" split_words:"true" default:"5"`
+
+	// SnapshotDiffTTL is the duration precomputed and on-demand diffs are kept in Redis.
+	SnapshotDiffTTL time.Duration `required:"true" split_words:"true" default:"720h"`
+
+	// SnapshotSigningKey is the base64-encoded Ed25519 private key (either the 32-byte seed or the 64-byte key)
+	// used to sign snapshot manifests. Leaving this empty disables the manifest endpoints.
+	SnapshotSigningKey string `split_words:"true"`
+
+	// SessionTokenSecret is the base64-encoded HMAC-SHA256 key, of at least 32 bytes, used to sign the session
+	// tokens issued in exchange for PenguinIDs. Leaving this empty disables session tokens, leaving PenguinIDs as
+	// the only way to authenticate.
+	SessionTokenSecret string `split_words:"true"`
+
+	// SessionAccessTokenTTL is the duration a session token is valid for before it has to be refreshed.
+	SessionAccessTokenTTL time.Duration `required:"true" split_words:"true" default:"15m"`
+
+	// SessionRefreshTokenTTL is the duration a session is kept without being refreshed. Every refresh extends it.
+	SessionRefreshTokenTTL time.Duration `required:"true" split_words:"true" default:"720h"`
+
+	// BiasDetectionWindow is the time window, ending at the time of detection, that the bias detection runs over.
+	BiasDetectionWindow time.Duration `required:"true" split_words:"true" default:"720h"`
+
+	// BiasDetectionMinTimes is the minimum number of reports required on both sides of a comparison.
+	BiasDetectionMinTimes int `required:"true" split_words:"true" default:"200"`
+
+	// BiasDetectionZThreshold is the absolute z-score above which a divergence is flagged as significant.
+	// The default of 3.29 corresponds to a two-sided p-value of 0.001.
+	BiasDetectionZThreshold float64 `required:"true" split_words:"true" default:"3.29"`
+
+	// CacheInvalidationAckTimeout is the maximum time to wait for every instance to acknowledge a cluster-wide
+	// cache invalidation. Instances that have not acknowledged within it are reported as unacknowledged.
+	CacheInvalidationAckTimeout time.Duration `required:"true" split_words:"true" default:"3s"`
+
+	// CDNPurger is the implementation used to purge responses from the CDN by their surrogate keys
+	// after caches are invalidated. Possible values are "noop" and "webhook".
+	CDNPurger string `required:"true" split_words:"true" default:"noop"`
+
+	// CDNPurgeWebhookURL is the URL the webhook purger POSTs the surrogate keys to purge to, as
+	// {"tags": [...]}. Required when CDNPurger is "webhook".
+	CDNPurgeWebhookURL string `split_words:"true"`
+
+	// CDNPurgeWebhookToken is sent as a bearer token in the Authorization header of webhook purge requests, if not empty.
+	CDNPurgeWebhookToken string `split_words:"true"`
+
+	// CDNPurgeWebhookTimeout is the timeout of a single webhook purge request.
+	CDNPurgeWebhookTimeout time.Duration `required:"true" split_words:"true" default:"10s"`
+
+	// QueryInlineMaxCost is the maximum estimated cost of a v3 query to be run inline within the HTTP request.
+	// Queries estimated above this cost are enqueued as query jobs instead.
+	QueryInlineMaxCost int `required:"true" split_words:"true" default:"2000"`
+
+	// QueryMaxCost is the maximum estimated cost of a v3 query to be accepted at all. Queries estimated above
+	// this cost are rejected with an explanation on how to narrow them down.
+	QueryMaxCost int `required:"true" split_words:"true" default:"100000"`
+
+	// QueryJobWorkers is the number of workers running enqueued query jobs on this instance.
+	QueryJobWorkers int `required:"true" split_words:"true" default:"2"`
+
+	// QueryJobQueueSize is the maximum number of query jobs waiting for a worker, shared by every instance.
+	QueryJobQueueSize int `required:"true" split_words:"true" default:"32"`
+
+	// QueryJobTimeout is the timeout for a single query job to run.
+	QueryJobTimeout time.Duration `required:"true" split_words:"true" default:"5m"`
+
+	// QueryJobResultTTL describes how long the status and result of a query job is kept in Redis.
+	QueryJobResultTTL time.Duration `required:"true" split_words:"true" default:"30m"`
+
+	// AccountJobWorkers is the number of workers running enqueued account export and deletion jobs on this instance.
+	AccountJobWorkers int `required:"true" split_words:"true" default:"1"`
+
+	// AccountJobQueueSize is the maximum number of account jobs waiting for a worker, shared by every instance.
+	AccountJobQueueSize int `required:"true" split_words:"true" default:"16"`
+
+	// AccountJobTimeout is the timeout for a single account job to run.
+	AccountJobTimeout time.Duration `required:"true" split_words:"true" default:"10m"`
+
+	// AccountJobResultTTL describes how long the status of an account job, and the archive of an export job, is
+	// kept in Redis.
+	AccountJobResultTTL time.Duration `required:"true" split_words:"true" default:"24h"`
+
+	// AccountDeletionPolicy decides what a deletion requested by an account does to its data:
+	//   - anonymize: the PenguinID of the account is replaced with an unusable one, and its reports are kept,
+	//     no longer related to anyone, in the drop matrices;
+	//   - delete: the account is deleted along with its reports.
+	// Either way, the IPs of its reports are purged and its recognition defects are detached or deleted.
+	AccountDeletionPolicy string `required:"true" split_words:"true" default:"anonymize"`
+
+	// AccountTrustedScore is the minimum trust score, out of 100, of the active accounts whose reports skip the
+	// expensive verifiers. Setting this above 100 makes no account trusted.
+	AccountTrustedScore int `required:"true" split_words:"true" default:"80"`
 }
 
 type Config struct {
@@ -23,3 +23,36 @@ func (m *WorkerHeartbeatURLMap) Decode(value string) error {
 	}
 	return nil
 }
+
+// TrendGranularityMap maps `<server>/<stageType>` to the trend granularities stored for such stages.
+// Both parts of a key accept `*` as a wildcard, and granularities are separated by `|`,
+// e.g. `*/ACTIVITY:1h|1d,CN/MAIN:1d|1w`.
+type TrendGranularityMap map[string][]string
+
+func (m *TrendGranularityMap) Decode(value string) error {
+	*m = TrendGranularityMap{}
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.Split(strings.TrimSpace(pair), ":")
+		if len(kv) != 2 || len(strings.Split(kv[0], "/")) != 2 {
+			return fmt.Errorf("invalid trend granularity map: expect a `<server>/<stageType>:<granularities>` rule for each element, but got: %s", pair)
+		}
+		granularities := strings.Split(kv[1], "|")
+		for _, granularity := range granularities {
+			if granularity != "1h" && granularity != "1d" && granularity != "1w" {
+				return fmt.Errorf("invalid trend granularity map: unknown granularity %s, available granularities are: 1h, 1d, 1w", granularity)
+			}
+		}
+		(*m)[kv[0]] = granularities
+	}
+	return nil
+}
+
+// Resolve returns the granularities of stages of stageType on server, using the most specific matching rule.
+func (m TrendGranularityMap) Resolve(server, stageType string) []string {
+	for _, key := range []string{server + "/" + stageType, server + "/*", "*/" + stageType, "*/*"} {
+		if granularities, ok := m[key]; ok {
+			return granularities
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -13,9 +14,7 @@ import (
 	"exusiai.dev/gommon/constant"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/gofiber/fiber/v2"
-	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
-	"github.com/samber/lo"
 	"github.com/tidwall/sjson"
 	"github.com/uptrace/bun"
 	"github.com/zeebo/xxh3"
@@ -25,6 +24,7 @@ import (
 	"exusiai.dev/backend-next/internal/model/cache"
 	"exusiai.dev/backend-next/internal/model/gamedata"
 	"exusiai.dev/backend-next/internal/model/types"
+	pkgcache "exusiai.dev/backend-next/internal/pkg/cache"
 	"exusiai.dev/backend-next/internal/pkg/pgerr"
 	"exusiai.dev/backend-next/internal/repo"
 	"exusiai.dev/backend-next/internal/server/svr"
@@ -35,22 +35,26 @@ import (
 type AdminController struct {
 	fx.In
 
-	PatternRepo           *repo.DropPattern
-	PatternElementRepo    *repo.DropPatternElement
-	RecognitionDefectRepo *repo.RecognitionDefect
-	AdminService          *service.Admin
-	ItemService           *service.Item
-	StageService          *service.Stage
-	DropMatrixService     *service.DropMatrix
-	PatternMatrixService  *service.PatternMatrix
-	TrendService          *service.Trend
-	SiteStatsService      *service.SiteStats
-	AnalyticsService      *service.Analytics
-	UpyunService          *service.Upyun
-	SnapshotService       *service.Snapshot
-	DropReportService     *service.DropReport
-	DropReportRepo        *repo.DropReport
-	PropertyRepo          *repo.Property
+	PatternRepo              *repo.DropPattern
+	PatternElementRepo       *repo.DropPatternElement
+	RecognitionDefectRepo    *repo.RecognitionDefect
+	AdminService             *service.Admin
+	AccountService           *service.Account
+	ItemService              *service.Item
+	StageService             *service.Stage
+	DropMatrixService        *service.DropMatrix
+	PatternMatrixService     *service.PatternMatrix
+	TrendService             *service.Trend
+	SiteStatsService         *service.SiteStats
+	AnalyticsService         *service.Analytics
+	BiasDetectionService     *service.BiasDetection
+	ProbeService             *service.Probe
+	CacheInvalidationService *service.CacheInvalidation
+	UpyunService             *service.Upyun
+	SnapshotService          *service.Snapshot
+	DropReportService        *service.DropReport
+	DropReportRepo           *repo.DropReport
+	PropertyRepo             *repo.Property
 }
 
 func RegisterAdmin(admin *svr.Admin, c AdminController) {
@@ -58,6 +62,14 @@ func RegisterAdmin(admin *svr.Admin, c AdminController) {
 	admin.Post("/save", c.SaveRenderedObjects)
 	admin.Post("/purge", c.PurgeCache)
 
+	admin.Get("/caches", c.GetCaches)
+	admin.Get("/caches/entry", c.GetCacheEntry)
+	admin.Post("/caches/evict", c.EvictCacheEntries)
+
+	admin.Post("/accounts/merge", c.MergeAccounts)
+	admin.Get("/accounts/:id", c.GetAccount)
+	admin.Post("/accounts/:id/moderation", c.ModerateAccount)
+
 	admin.Post("/rejections/reject-rules/reevaluation/preview", c.RejectRulesReevaluationPreview)
 	admin.Post("/rejections/reject-rules/reevaluation/apply", c.RejectRulesReevaluationApply)
 
@@ -67,11 +79,14 @@ func RegisterAdmin(admin *svr.Admin, c AdminController) {
 	admin.Get("/_temp/pattern/disambiguation", c.DisambiguatePatterns)
 
 	admin.Get("/analytics/report-unique-users/by-source", c.GetRecentUniqueUserCountBySource)
+	admin.Get("/analytics/bias/:server", c.GetBiasReport)
+	admin.Get("/analytics/probes", c.GetProbeAggregates)
 
 	admin.Get("/refresh/matrix/:server", c.RefreshAllDropMatrixElements)
 	admin.Get("/refresh/pattern/:server", c.RefreshAllPatternMatrixElements)
 	admin.Get("/refresh/trend/:server", c.RefreshAllTrendElements)
 	admin.Get("/refresh/sitestats/:server", c.RefreshAllSiteStats)
+	admin.Get("/refresh/bias/:server", c.RefreshBiasReport)
 
 	admin.Get("/recognition/defects", c.GetRecognitionDefects)
 	admin.Get("/recognition/defects/:defectId", c.GetRecognitionDefect)
@@ -279,10 +294,17 @@ func (c *AdminController) SaveRenderedObjects(ctx *fiber.Ctx) error {
 		return err
 	}
 
-	err := c.AdminService.SaveRenderedObjects(ctx.UserContext(), &request)
+	invalidation, err := c.AdminService.SaveRenderedObjects(ctx.UserContext(), &request)
 	if err != nil {
 		return err
 	}
+	ctx.Set("X-Penguin-Cache-Invalidation-Receivers", strconv.Itoa(invalidation.Receivers))
+	ctx.Set("X-Penguin-Cache-Invalidation-Acknowledged", strconv.Itoa(invalidation.Acknowledged))
+	if invalidation.FlushError != "" {
+		ctx.Set("X-Penguin-Cache-Invalidation-Error", invalidation.FlushError)
+	} else if invalidation.PublishError != "" {
+		ctx.Set("X-Penguin-Cache-Invalidation-Error", invalidation.PublishError)
+	}
 
 	return ctx.JSON(request)
 }
@@ -292,25 +314,146 @@ func (c *AdminController) PurgeCache(ctx *fiber.Ctx) error {
 	if err := rekuest.ValidBody(ctx, &request); err != nil {
 		return err
 	}
-	errs := lo.Filter(
-		lo.Map(request.Pairs, func(pair types.PurgeCachePair, _ int) error {
-			err := cache.Delete(pair.Name, pair.Key)
-			if err != nil {
-				return errors.Wrapf(err, "cache [%s:%s]", pair.Name, pair.Key.String)
-			}
-			return nil
-		}),
-		func(v error, i int) bool {
-			return v != nil
-		},
-	)
-	if len(errs) > 0 {
-		err := pgerr.New(http.StatusInternalServerError, "PURGE_CACHE_FAILED", "error occurred while purging cache")
-		err.Extras = &pgerr.Extras{
-			"caches": errs,
+	result, err := c.CacheInvalidationService.Invalidate(ctx.UserContext(), request.Pairs)
+	if err != nil {
+		return pgerr.New(http.StatusInternalServerError, "PURGE_CACHE_FAILED", "error occurred while purging cache: "+err.Error())
+	}
+	return ctx.JSON(result)
+}
+
+type CacheResponse struct {
+	Name            string `json:"name"`
+	Entries         int    `json:"entries"`
+	ApproximateSize int    `json:"approximateSize"`
+}
+
+type CacheEntryResponse struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	*pkgcache.EntryInfo
+	AgeSeconds      int64      `json:"ageSeconds"`
+	LastModified    *time.Time `json:"lastModified"`
+	ApproximateSize int        `json:"approximateSize"`
+}
+
+type CacheEvictResponse struct {
+	// Evicted is the number of entries evicted on the instance handling the request.
+	Evicted      int                              `json:"evicted"`
+	Invalidation *service.CacheInvalidationResult `json:"invalidation"`
+}
+
+// GetCaches lists every cache of the instance handling the request, with its number of entries and
+// approximate size in bytes of their JSON representation.
+func (c *AdminController) GetCaches(ctx *fiber.Ctx) error {
+	caches := pkgcache.Caches()
+	response := make([]*CacheResponse, 0, len(caches))
+	for _, ch := range caches {
+		entries := ch.Inspect()
+		r := &CacheResponse{
+			Name:    ch.Name(),
+			Entries: len(entries),
+		}
+		for _, entry := range entries {
+			r.ApproximateSize += entry.ApproximateSize()
 		}
+		response = append(response, r)
+	}
+	return ctx.JSON(response)
+}
+
+func (c *AdminController) GetCacheEntry(ctx *fiber.Ctx) error {
+	name := ctx.Query("name")
+	key := ctx.Query("key")
+	if name == "" {
+		return pgerr.ErrInvalidReq.Msg("name is required")
+	}
+
+	ch, ok := pkgcache.Find(name)
+	if !ok {
+		return pgerr.ErrNotFound
 	}
-	return nil
+	entry, ok := ch.Inspect()[key]
+	if !ok {
+		return pgerr.ErrNotFound
+	}
+
+	response := &CacheEntryResponse{
+		Name:            name,
+		Key:             key,
+		EntryInfo:       entry,
+		AgeSeconds:      int64(time.Since(entry.CreatedAt).Seconds()),
+		ApproximateSize: entry.ApproximateSize(),
+	}
+	if lastModified, ok := cache.LastModified(name, key); ok {
+		response.LastModified = &lastModified
+	}
+	return ctx.JSON(response)
+}
+
+// EvictCacheEntries evicts the entries matching the patterns on every instance.
+func (c *AdminController) EvictCacheEntries(ctx *fiber.Ctx) error {
+	var request types.CacheEvictRequest
+	if err := rekuest.ValidBody(ctx, &request); err != nil {
+		return err
+	}
+	if _, err := path.Match(request.Name, ""); err != nil {
+		return pgerr.ErrInvalidReq.Msg("invalid name pattern: %s", err.Error())
+	}
+	if _, err := path.Match(request.Key, ""); err != nil {
+		return pgerr.ErrInvalidReq.Msg("invalid key pattern: %s", err.Error())
+	}
+
+	result, evicted, err := c.CacheInvalidationService.Evict(ctx.UserContext(), []types.CacheEvictRequest{request})
+	if err != nil {
+		return pgerr.New(http.StatusInternalServerError, "EVICT_CACHE_FAILED", "error occurred while evicting cache entries: "+err.Error())
+	}
+	return ctx.JSON(&CacheEvictResponse{
+		Evicted:      evicted,
+		Invalidation: result,
+	})
+}
+
+func (c *AdminController) MergeAccounts(ctx *fiber.Ctx) error {
+	var request types.MergeAccountRequest
+	if err := rekuest.ValidBody(ctx, &request); err != nil {
+		return err
+	}
+
+	merge, err := c.AccountService.MergeAccounts(ctx.UserContext(), request.SourcePenguinID, request.TargetPenguinID, model.AccountMergeInitiatorAdmin)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(merge)
+}
+
+func (c *AdminController) GetAccount(ctx *fiber.Ctx) error {
+	accountId := ctx.Params("id")
+	if err := rekuest.ValidVar(ctx, accountId, "required,number"); err != nil {
+		return err
+	}
+
+	account, err := c.AccountService.AccountRepo.GetAccountById(ctx.UserContext(), accountId)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(model.NewAdminAccount(account))
+}
+
+func (c *AdminController) ModerateAccount(ctx *fiber.Ctx) error {
+	accountId, err := ctx.ParamsInt("id")
+	if err != nil || accountId <= 0 {
+		return pgerr.ErrInvalidReq.Msg("invalid account id")
+	}
+	var request types.ModerateAccountRequest
+	if err := rekuest.ValidBody(ctx, &request); err != nil {
+		return err
+	}
+
+	account, err := c.AccountService.ModerateAccount(ctx.UserContext(), accountId, &request)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(model.NewAdminAccount(account))
 }
 
 func (c *AdminController) GetRecentUniqueUserCountBySource(ctx *fiber.Ctx) error {
@@ -322,6 +465,31 @@ func (c *AdminController) GetRecentUniqueUserCountBySource(ctx *fiber.Ctx) error
 	return ctx.JSON(result)
 }
 
+func (c *AdminController) GetProbeAggregates(ctx *fiber.Ctx) error {
+	recent := ctx.Query("recent", "24h")
+	limit := ctx.QueryInt("limit", 20)
+	if limit <= 0 || limit > 100 {
+		return pgerr.ErrInvalidReq.Msg("limit should be between 1 and 100")
+	}
+	result, err := c.ProbeService.GetProbeAggregates(ctx.UserContext(), recent, limit)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(result)
+}
+
+func (c *AdminController) GetBiasReport(ctx *fiber.Ctx) error {
+	server := ctx.Params("server")
+	if err := rekuest.ValidServer(ctx, server); err != nil {
+		return err
+	}
+	report, err := c.BiasDetectionService.GetBiasReport(ctx.UserContext(), server)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(report)
+}
+
 func (c *AdminController) RefreshAllDropMatrixElements(ctx *fiber.Ctx) error {
 	server := ctx.Params("server")
 	return c.DropMatrixService.RefreshAllDropMatrixElements(ctx.UserContext(), server, []string{constant.SourceCategoryAll})
@@ -343,6 +511,18 @@ func (c *AdminController) RefreshAllSiteStats(ctx *fiber.Ctx) error {
 	return err
 }
 
+func (c *AdminController) RefreshBiasReport(ctx *fiber.Ctx) error {
+	server := ctx.Params("server")
+	if err := rekuest.ValidServer(ctx, server); err != nil {
+		return err
+	}
+	report, err := c.BiasDetectionService.RefreshBiasReport(ctx.UserContext(), server)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(report)
+}
+
 type RecognitionDefectsResponseImage struct {
 	Original  string `json:"original,omitempty"`
 	Thumbnail string `json:"thumbnail,omitempty"`
@@ -20,7 +20,7 @@ type EventPeriod struct {
 }
 
 func RegisterEventPeriod(v2 *svr.V2, c EventPeriod) {
-	v2.Get("/period", c.GetEventPeriods)
+	v2.Get("/period", cachectrl.Surrogate(periodTags), c.GetEventPeriods)
 }
 
 // @Summary  Get All Event Periods
@@ -42,3 +42,7 @@ func (c *EventPeriod) GetEventPeriods(ctx *fiber.Ctx) (err error) {
 	cachectrl.OptIn(ctx, lastModifiedTime)
 	return ctx.JSON(activities)
 }
+
+func periodTags(*fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagPeriod, "", nil, nil)
+}
@@ -22,8 +22,8 @@ type Item struct {
 }
 
 func RegisterItem(v2 *svr.V2, c Item) {
-	v2.Get("/items", c.GetItems)
-	v2.Get("/items/:itemId", c.GetItemByArkId)
+	v2.Get("/items", cachectrl.Surrogate(itemsTags), c.GetItems)
+	v2.Get("/items/:itemId", cachectrl.Surrogate(itemTags), c.GetItemByArkId)
 }
 
 // @Summary  Get All Items
@@ -62,3 +62,11 @@ func (c *Item) GetItemByArkId(ctx *fiber.Ctx) error {
 	}
 	return ctx.JSON(item)
 }
+
+func itemsTags(*fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagItems, "", nil, nil)
+}
+
+func itemTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagItems, "", nil, []string{ctx.Params("itemId")})
+}
@@ -19,7 +19,7 @@ type Notice struct {
 }
 
 func RegisterNotice(v2 *svr.V2, c Notice) {
-	v2.Get("/notice", c.GetNotices)
+	v2.Get("/notice", cachectrl.Surrogate(noticesTags), c.GetNotices)
 }
 
 // @Summary  Get All Notices
@@ -40,3 +40,7 @@ func (c *Notice) GetNotices(ctx *fiber.Ctx) error {
 	cachectrl.OptIn(ctx, lastModifiedTime)
 	return ctx.JSON(notices)
 }
+
+func noticesTags(*fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagNotices, "", nil, nil)
+}
@@ -1,13 +1,10 @@
 package v2
 
 import (
-	"time"
-
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/fx"
 	"gopkg.in/guregu/null.v3"
 
-	"exusiai.dev/backend-next/internal/model/cache"
 	modelv2 "exusiai.dev/backend-next/internal/model/v2"
 	"exusiai.dev/backend-next/internal/pkg/cachectrl"
 	"exusiai.dev/backend-next/internal/pkg/middlewares"
@@ -31,9 +28,9 @@ type Private struct {
 
 func RegisterPrivate(v2 *svr.V2, c Private) {
 	result := v2.Group("/_private/result")
-	result.Get("/matrix/:server/:source/:category?", middlewares.ValidateServerAsParam, middlewares.ValidateCategoryAsParam, c.GetDropMatrix)
-	result.Get("/pattern/:server/:source/:category?", middlewares.ValidateServerAsParam, middlewares.ValidateCategoryAsParam, c.GetPatternMatrix)
-	result.Get("/trend/:server", middlewares.ValidateServerAsParam, c.GetTrends)
+	result.Get("/matrix/:server/:source/:category?", middlewares.ValidateServerAsParam, middlewares.ValidateCategoryAsParam, cachectrl.Surrogate(privateDropMatrixTags), cachectrl.Conditional(resolvePrivateDropMatrix), c.GetDropMatrix)
+	result.Get("/pattern/:server/:source/:category?", middlewares.ValidateServerAsParam, middlewares.ValidateCategoryAsParam, cachectrl.Surrogate(privatePatternMatrixTags), cachectrl.Conditional(resolvePrivatePatternMatrix), c.GetPatternMatrix)
+	result.Get("/trend/:server", middlewares.ValidateServerAsParam, cachectrl.Surrogate(privateTrendsTags), cachectrl.Conditional(resolvePrivateTrends), c.GetTrends)
 }
 
 // @Summary  Get Drop Matrix
@@ -52,7 +49,7 @@ func (c *Private) GetDropMatrix(ctx *fiber.Ctx) error {
 
 	accountId := null.NewInt(0, false)
 	if isPersonal {
-		account, err := c.AccountService.GetAccountFromRequest(ctx)
+		account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReadPersonal)
 		if err != nil {
 			return err
 		}
@@ -65,15 +62,6 @@ func (c *Private) GetDropMatrix(ctx *fiber.Ctx) error {
 		return err
 	}
 
-	if !accountId.Valid {
-		key := server + constant.CacheSep + "true" + constant.CacheSep + category
-		var lastModifiedTime time.Time
-		if err := cache.LastModifiedTime.Get("[shimMaxAccumulableDropMatrixResults#server|showClosedZoned|sourceCategory:"+key+"]", &lastModifiedTime); err != nil {
-			lastModifiedTime = time.Now()
-		}
-		cachectrl.OptIn(ctx, lastModifiedTime)
-	}
-
 	return ctx.JSON(shimResult)
 }
 
@@ -93,7 +81,7 @@ func (c *Private) GetPatternMatrix(ctx *fiber.Ctx) error {
 
 	accountId := null.NewInt(0, false)
 	if isPersonal {
-		account, err := c.AccountService.GetAccountFromRequest(ctx)
+		account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReadPersonal)
 		if err != nil {
 			return err
 		}
@@ -106,15 +94,6 @@ func (c *Private) GetPatternMatrix(ctx *fiber.Ctx) error {
 		return err
 	}
 
-	if !accountId.Valid {
-		key := server + constant.CacheSep + category
-		var lastModifiedTime time.Time
-		if err := cache.LastModifiedTime.Get("[shimLatestPatternMatrixResults#server|sourceCategory:"+key+"]", &lastModifiedTime); err != nil {
-			lastModifiedTime = time.Now()
-		}
-		cachectrl.OptIn(ctx, lastModifiedTime)
-	}
-
 	return ctx.JSON(shimResult)
 }
 
@@ -132,11 +111,39 @@ func (c *Private) GetTrends(ctx *fiber.Ctx) error {
 		return err
 	}
 
-	var lastModifiedTime time.Time
-	if err := cache.LastModifiedTime.Get("[shimSavedTrendResults#server:"+server+"]", &lastModifiedTime); err != nil {
-		lastModifiedTime = time.Now()
+	return ctx.JSON(shimResult)
+}
+
+// resolvePrivateDropMatrix resolves the cache entry of global drop matrix requests.
+func resolvePrivateDropMatrix(ctx *fiber.Ctx) (name string, key string, ok bool) {
+	if ctx.Params("source") == "personal" {
+		return "", "", false
 	}
-	cachectrl.OptIn(ctx, lastModifiedTime)
+	key = ctx.Params("server") + constant.CacheSep + "true" + constant.CacheSep + ctx.Params("category", "all")
+	return "shimMaxAccumulableDropMatrixResults#server|showClosedZoned|sourceCategory", key, true
+}
 
-	return ctx.JSON(shimResult)
+// resolvePrivatePatternMatrix resolves the cache entry of global pattern matrix requests.
+func resolvePrivatePatternMatrix(ctx *fiber.Ctx) (name string, key string, ok bool) {
+	if ctx.Params("source") == "personal" {
+		return "", "", false
+	}
+	key = ctx.Params("server") + constant.CacheSep + ctx.Params("category", "all")
+	return "shimLatestPatternMatrixResults#server|sourceCategory", key, true
+}
+
+func resolvePrivateTrends(ctx *fiber.Ctx) (name string, key string, ok bool) {
+	return "shimSavedTrendResults#server", ctx.Params("server"), true
+}
+
+func privateDropMatrixTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagMatrix, ctx.Params("server"), nil, nil)
+}
+
+func privatePatternMatrixTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagPattern, ctx.Params("server"), nil, nil)
+}
+
+func privateTrendsTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagTrend, ctx.Params("server"), nil, nil)
 }
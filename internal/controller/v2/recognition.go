@@ -9,6 +9,7 @@ import (
 
 	"exusiai.dev/backend-next/internal/model"
 	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/pkg/ratelimit"
 	"exusiai.dev/backend-next/internal/repo"
 	"exusiai.dev/backend-next/internal/server/svr"
 	"exusiai.dev/backend-next/internal/service"
@@ -21,11 +22,12 @@ type Recognition struct {
 	RecognitionDefectRepo *repo.RecognitionDefect
 	AccountService        *service.Account
 	UpyunService          *service.Upyun
+	RateLimiter           *ratelimit.Limiter
 }
 
 func RegisterUpyun(v2 *svr.V2, c Recognition) {
 	r := v2.Group("/recognition")
-	r.Post("/defects/report/init", c.InitDefectReport)
+	r.Post("/defects/report/init", c.RateLimiter.Middleware(ratelimit.GroupRecognitionInit), c.InitDefectReport)
 	r.Post("/defects/report/callback/:defectId", c.RetrieveDefectReportImageCallback)
 }
 
@@ -61,7 +63,7 @@ func (c *Recognition) InitDefectReport(ctx *fiber.Ctx) error {
 	}
 
 	var accountId int
-	account, _ := c.AccountService.GetAccountFromRequest(ctx)
+	account, _ := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReport)
 	if account != nil {
 		accountId = account.AccountID
 	}
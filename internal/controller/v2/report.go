@@ -19,6 +19,7 @@ import (
 	"exusiai.dev/backend-next/internal/pkg/middlewares"
 	"exusiai.dev/backend-next/internal/pkg/pgerr"
 	"exusiai.dev/backend-next/internal/pkg/pgid"
+	"exusiai.dev/backend-next/internal/pkg/ratelimit"
 	"exusiai.dev/backend-next/internal/server/svr"
 	"exusiai.dev/backend-next/internal/service"
 	"exusiai.dev/backend-next/internal/util/rekuest"
@@ -32,9 +33,11 @@ type Report struct {
 	Crypto         *crypto.Crypto
 	ReportService  *service.Report
 	AccountService *service.Account
+	RateLimiter    *ratelimit.Limiter
 }
 
 func RegisterReport(v2 *svr.V2, c Report) {
+	// the rate limit comes after the idempotency middleware so that replayed responses are not counted
 	v2.Post("/report", middlewares.Idempotency(&middlewares.IdempotencyConfig{
 		Lifetime:  constant.ReportIdempotencyLifetime,
 		KeyHeader: constant.IdempotencyKeyHeader,
@@ -47,16 +50,20 @@ func RegisterReport(v2 *svr.V2, c Report) {
 		},
 		Storage: fiberstore.NewRedis(c.Redis, constant.ReportIdempotencyRedisHashKey),
 		RedSync: c.RedSync,
-	}), middlewares.InjectValidBody[types.SingularReportRequest](), c.MiddlewareGetOrCreateAccount, c.SingularReport)
-	v2.Post("/report/recall", middlewares.InjectValidBody[types.SingularReportRecallRequest](), c.RecallSingularReport)
-	v2.Post("/report/recognition", c.MiddlewareGetOrCreateAccount, c.RecognitionReport)
+	}), c.RateLimiter.Middleware(ratelimit.GroupReport), middlewares.InjectValidBody[types.SingularReportRequest](), c.MiddlewareGetOrCreateAccount, c.SingularReport)
+	v2.Post("/report/recall", c.RateLimiter.Middleware(ratelimit.GroupReport), middlewares.InjectValidBody[types.SingularReportRecallRequest](), c.RecallSingularReport)
+	v2.Post("/report/recognition", c.RateLimiter.Middleware(ratelimit.GroupReport), c.MiddlewareGetOrCreateAccount, c.RecognitionReport)
 }
 
 func (c *Report) MiddlewareGetOrCreateAccount(ctx *fiber.Ctx) error {
 	var accountId int
 
-	account, err := c.AccountService.GetAccountFromRequest(ctx)
+	account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReport)
 	if err != nil {
+		// requests presenting session tokens are never given new accounts, as their reports belong to the accounts of the tokens
+		if pgid.ExtractToken(ctx) != "" {
+			return err
+		}
 		createdAccount, err := c.AccountService.CreateAccountWithRandomPenguinId(ctx.UserContext())
 		if err != nil {
 			return err
@@ -64,6 +71,9 @@ func (c *Report) MiddlewareGetOrCreateAccount(ctx *fiber.Ctx) error {
 		accountId = createdAccount.AccountID
 		pgid.Inject(ctx, createdAccount.PenguinID)
 	} else {
+		if err := c.AccountService.CheckReportable(account); err != nil {
+			return err
+		}
 		accountId = account.AccountID
 	}
 
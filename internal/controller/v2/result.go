@@ -14,12 +14,12 @@ import (
 	"gopkg.in/guregu/null.v3"
 
 	"exusiai.dev/backend-next/internal/model"
-	"exusiai.dev/backend-next/internal/model/cache"
 	"exusiai.dev/backend-next/internal/model/types"
 	modelv2 "exusiai.dev/backend-next/internal/model/v2"
 	"exusiai.dev/backend-next/internal/pkg/cachectrl"
 	"exusiai.dev/backend-next/internal/pkg/middlewares"
 	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/pkg/ratelimit"
 	"exusiai.dev/backend-next/internal/server/svr"
 	"exusiai.dev/backend-next/internal/service"
 	"exusiai.dev/backend-next/internal/util/rekuest"
@@ -37,6 +37,7 @@ type Result struct {
 	AccountService       *service.Account
 	ItemService          *service.Item
 	StageService         *service.Stage
+	RateLimiter          *ratelimit.Limiter
 }
 
 func RegisterResult(v2 *svr.V2, c Result) {
@@ -79,19 +80,10 @@ func RegisterResult(v2 *svr.V2, c Result) {
 		},
 	}))
 
-	group.Get("/matrix", middlewares.ValidateServerAsQuery, c.GetDropMatrix)
-	group.Get("/pattern", middlewares.ValidateServerAsQuery, c.GetPatternMatrix)
-	group.Get("/trends", middlewares.ValidateServerAsQuery, c.GetTrends)
-	group.Post("/advanced", limiter.New(limiter.Config{
-		LimitReached: func(c *fiber.Ctx) error {
-			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-				"code":    "TOO_MANY_REQUESTS",
-				"message": "Your client is sending requests too frequently. The Penguin Stats advanced query API is limited to 30 requests per 5 minutes.",
-			})
-		},
-		Max:        30,
-		Expiration: time.Minute * 5,
-	}), c.AdvancedQuery)
+	group.Get("/matrix", middlewares.ValidateServerAsQuery, cachectrl.Surrogate(dropMatrixTags), cachectrl.Conditional(resolveDropMatrix), c.GetDropMatrix)
+	group.Get("/pattern", middlewares.ValidateServerAsQuery, cachectrl.Surrogate(patternMatrixTags), cachectrl.Conditional(resolvePatternMatrix), c.GetPatternMatrix)
+	group.Get("/trends", middlewares.ValidateServerAsQuery, cachectrl.Surrogate(trendsTags), cachectrl.Conditional(resolveTrends), c.GetTrends)
+	group.Post("/advanced", c.RateLimiter.Middleware(ratelimit.GroupQuery), c.AdvancedQuery)
 }
 
 // @Summary   Get Drop Matrix
@@ -130,7 +122,7 @@ func (c *Result) GetDropMatrix(ctx *fiber.Ctx) error {
 
 	accountId := null.NewInt(0, false)
 	if isPersonal {
-		account, err := c.AccountService.GetAccountFromRequest(ctx)
+		account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReadPersonal)
 		if err != nil {
 			return err
 		}
@@ -143,24 +135,19 @@ func (c *Result) GetDropMatrix(ctx *fiber.Ctx) error {
 		return err
 	}
 
-	useCache := !accountId.Valid && stageFilterStr == "" && itemFilterStr == ""
-	if useCache {
-		key := server + constant.CacheSep + strconv.FormatBool(showClosedZones) + constant.CacheSep + constant.SourceCategoryAll
-		var lastModifiedTime time.Time
-		if err := cache.LastModifiedTime.Get("[shimMaxAccumulableDropMatrixResults#server|showClosedZoned|sourceCategory:"+key+"]", &lastModifiedTime); err != nil {
-			lastModifiedTime = time.Now()
-		}
-		cachectrl.OptIn(ctx, lastModifiedTime)
-	}
-
 	return ctx.JSON(shimQueryResult)
 }
 
 // @Summary   Get Pattern Matrix
 // @Tags      Result
 // @Produce   json
-// @Param     server       query     string  true   "Server; default to CN"  Enums(CN, US, JP, KR)
-// @Param     is_personal  query     bool    false  "Whether to query for personal drop matrix or not. If `is_personal` equals to `true`, a valid PenguinID would be required to be provided (PenguinIDAuth)"
+// @Param     server       query     string    true   "Server; default to CN"  Enums(CN, US, JP, KR)
+// @Param     is_personal  query     bool      false  "Whether to query for personal drop matrix or not. If `is_personal` equals to `true`, a valid PenguinID would be required to be provided (PenguinIDAuth)"
+// @Param     accumulable  query     bool      false  "Whether to combine results over the max-accumulable time ranges of every stage, instead of only its latest time range"
+// @Param     stageFilter  query     []string  false  "Comma separated list of stage IDs to filter"  collectionFormat(csv)
+// @Param     start        query     int       false  "Exclude time ranges starting before this timestamp, in milliseconds"
+// @Param     end          query     int       false  "Exclude time ranges ending after this timestamp, in milliseconds"
+// @Param     min_times    query     int       false  "Exclude stages whose total times (sample count) is less than this number"
 // @Success   200          {object}  modelv2.PatternMatrixQueryResult
 // @Failure   500          {object}  pgerr.PenguinError  "An unexpected error occurred"
 // @Security  PenguinIDAuth
@@ -178,7 +165,7 @@ func (c *Result) GetPatternMatrix(ctx *fiber.Ctx) error {
 
 	accountId := null.NewInt(0, false)
 	if isPersonal {
-		account, err := c.AccountService.GetAccountFromRequest(ctx)
+		account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReadPersonal)
 		if err != nil {
 			return err
 		}
@@ -186,18 +173,14 @@ func (c *Result) GetPatternMatrix(ctx *fiber.Ctx) error {
 		accountId.Valid = true
 	}
 
-	shimResult, err := c.PatternMatrixService.GetShimLatestPatternMatrixResults(ctx.UserContext(), server, accountId, constant.SourceCategoryAll)
-	if err != nil {
+	var query types.PatternMatrixQuery
+	if err := rekuest.ValidQuery(ctx, &query); err != nil {
 		return err
 	}
 
-	if !accountId.Valid {
-		key := server + constant.CacheSep + constant.SourceCategoryAll
-		var lastModifiedTime time.Time
-		if err := cache.LastModifiedTime.Get("[shimLatestPatternMatrixResults#server|sourceCategory:"+key+"]", &lastModifiedTime); err != nil {
-			lastModifiedTime = time.Now()
-		}
-		cachectrl.OptIn(ctx, lastModifiedTime)
+	shimResult, err := c.PatternMatrixService.GetShimPatternMatrixResults(ctx.UserContext(), server, &query, accountId, constant.SourceCategoryAll)
+	if err != nil {
+		return err
 	}
 
 	return ctx.JSON(shimResult)
@@ -221,12 +204,6 @@ func (c *Result) GetTrends(ctx *fiber.Ctx) error {
 		return err
 	}
 
-	var lastModifiedTime time.Time
-	if err := cache.LastModifiedTime.Get("[shimSavedTrendResults#server:"+server+"]", &lastModifiedTime); err != nil {
-		lastModifiedTime = time.Now()
-	}
-	cachectrl.OptIn(ctx, lastModifiedTime)
-
 	return ctx.JSON(shimResult)
 }
 
@@ -264,7 +241,7 @@ func (c *Result) handleAdvancedQuery(ctx *fiber.Ctx, query *types.AdvancedQuery)
 	}
 	accountId := null.NewInt(0, false)
 	if isPersonal {
-		account, err := c.AccountService.GetAccountFromRequest(ctx)
+		account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReadPersonal)
 		if err != nil {
 			return nil, err
 		}
@@ -334,6 +311,51 @@ func (c *Result) handleAdvancedQuery(ctx *fiber.Ctx, query *types.AdvancedQuery)
 	}
 }
 
+// resolveDropMatrix resolves the cache entry of global drop matrix requests without filters.
+func resolveDropMatrix(ctx *fiber.Ctx) (name string, key string, ok bool) {
+	if ctx.Query("is_personal", "false") != "false" || ctx.Query("stageFilter") != "" || ctx.Query("itemFilter") != "" {
+		return "", "", false
+	}
+	showClosedZones, err := strconv.ParseBool(ctx.Query("show_closed_zones", "false"))
+	if err != nil {
+		return "", "", false
+	}
+	key = ctx.Query("server", "CN") + constant.CacheSep + strconv.FormatBool(showClosedZones) + constant.CacheSep + constant.SourceCategoryAll
+	return "shimMaxAccumulableDropMatrixResults#server|showClosedZoned|sourceCategory", key, true
+}
+
+// resolvePatternMatrix resolves the cache entry of global pattern matrix requests without filters.
+func resolvePatternMatrix(ctx *fiber.Ctx) (name string, key string, ok bool) {
+	if ctx.Query("is_personal", "false") != "false" {
+		return "", "", false
+	}
+	var query types.PatternMatrixQuery
+	if err := ctx.QueryParser(&query); err != nil || query.HasFilters() {
+		return "", "", false
+	}
+	name = "shimLatestPatternMatrixResults#server|sourceCategory"
+	if query.Accumulable {
+		name = "shimMaxAccumulablePatternMatrixResults#server|sourceCategory"
+	}
+	return name, ctx.Query("server", "CN") + constant.CacheSep + constant.SourceCategoryAll, true
+}
+
+func resolveTrends(ctx *fiber.Ctx) (name string, key string, ok bool) {
+	return "shimSavedTrendResults#server", ctx.Query("server", "CN"), true
+}
+
+func dropMatrixTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagMatrix, ctx.Query("server", "CN"), cachectrl.SplitFilter(ctx.Query("stageFilter")), cachectrl.SplitFilter(ctx.Query("itemFilter")))
+}
+
+func patternMatrixTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagPattern, ctx.Query("server", "CN"), cachectrl.SplitFilter(ctx.Query("stageFilter")), nil)
+}
+
+func trendsTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagTrend, ctx.Query("server", "CN"), nil, nil)
+}
+
 func (c *Result) calcIntervalNum(startTime, endTime time.Time, intervalLength time.Duration) int {
 	diff := endTime.Sub(startTime)
 	// implicit float64 to int: drops fractional part (truncates towards 0)
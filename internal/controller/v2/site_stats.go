@@ -1,12 +1,9 @@
 package v2
 
 import (
-	"time"
-
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/fx"
 
-	"exusiai.dev/backend-next/internal/model/cache"
 	modelv2 "exusiai.dev/backend-next/internal/model/v2"
 	"exusiai.dev/backend-next/internal/pkg/cachectrl"
 	"exusiai.dev/backend-next/internal/pkg/middlewares"
@@ -24,7 +21,7 @@ type SiteStats struct {
 }
 
 func RegisterSiteStats(v2 *svr.V2, c SiteStats) {
-	v2.Get("/stats", middlewares.ValidateServerAsQuery, c.GetSiteStats)
+	v2.Get("/stats", middlewares.ValidateServerAsQuery, cachectrl.Surrogate(siteStatsTags), cachectrl.Conditional(resolveSiteStats), c.GetSiteStats)
 }
 
 // @Summary  Get Site Stats
@@ -45,11 +42,13 @@ func (c *SiteStats) GetSiteStats(ctx *fiber.Ctx) error {
 		return err
 	}
 
-	var lastModifiedTime time.Time
-	if err := cache.LastModifiedTime.Get("[shimSiteStats#server:"+server+"]", &lastModifiedTime); err != nil {
-		lastModifiedTime = time.Now()
-	}
-	cachectrl.OptIn(ctx, lastModifiedTime)
-
 	return ctx.JSON(siteStats)
 }
+
+func resolveSiteStats(ctx *fiber.Ctx) (name string, key string, ok bool) {
+	return "shimSiteStats#server", ctx.Query("server", "CN"), true
+}
+
+func siteStatsTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagStats, ctx.Query("server", "CN"), nil, nil)
+}
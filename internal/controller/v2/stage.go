@@ -23,8 +23,8 @@ type Stage struct {
 }
 
 func RegisterStage(v2 *svr.V2, c Stage) {
-	v2.Get("/stages", middlewares.ValidateServerAsQuery, c.GetStages)
-	v2.Get("/stages/:stageId", middlewares.ValidateServerAsQuery, c.GetStageByArkId)
+	v2.Get("/stages", middlewares.ValidateServerAsQuery, cachectrl.Surrogate(stagesTags), c.GetStages)
+	v2.Get("/stages/:stageId", middlewares.ValidateServerAsQuery, cachectrl.Surrogate(stageTags), c.GetStageByArkId)
 }
 
 // @Summary  Get All Stages
@@ -66,3 +66,11 @@ func (c *Stage) GetStageByArkId(ctx *fiber.Ctx) error {
 	}
 	return ctx.JSON(stage)
 }
+
+func stagesTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagStages, ctx.Query("server", "CN"), nil, nil)
+}
+
+func stageTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagStages, ctx.Query("server", "CN"), []string{ctx.Params("stageId")}, nil)
+}
@@ -22,8 +22,8 @@ type Zone struct {
 }
 
 func RegisterZone(v2 *svr.V2, c Zone) {
-	v2.Get("/zones", c.GetZones)
-	v2.Get("/zones/:zoneId", c.GetZoneByArkId)
+	v2.Get("/zones", cachectrl.Surrogate(zonesTags), c.GetZones)
+	v2.Get("/zones/:zoneId", cachectrl.Surrogate(zonesTags), c.GetZoneByArkId)
 }
 
 // @Summary  Get All Zones
@@ -62,3 +62,7 @@ func (c *Zone) GetZoneByArkId(ctx *fiber.Ctx) error {
 	}
 	return ctx.JSON(zone)
 }
+
+func zonesTags(*fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagZones, "", nil, nil)
+}
@@ -7,11 +7,16 @@ import (
 func Module() fx.Option {
 	return fx.Module("controllers.v3", fx.Invoke(
 		RegisterItem,
+		RegisterAuth,
 		RegisterLive,
+		RegisterMe,
+		RegisterProbe,
 		RegisterStage,
 		RegisterZone,
 		RegisterDataset,
 		RegisterInit,
 		RegisterIncremental,
+		RegisterQuery,
+		RegisterTrend,
 	))
 }
@@ -0,0 +1,78 @@
+package v3
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+
+	"exusiai.dev/backend-next/internal/model/types"
+	"exusiai.dev/backend-next/internal/pkg/flog"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/pkg/ratelimit"
+	"exusiai.dev/backend-next/internal/server/svr"
+	"exusiai.dev/backend-next/internal/service"
+	"exusiai.dev/backend-next/internal/util/rekuest"
+)
+
+type AuthController struct {
+	fx.In
+
+	AccountService *service.Account
+	SessionService *service.Session
+	RateLimiter    *ratelimit.Limiter
+}
+
+func RegisterAuth(v3 *svr.V3, c AuthController) {
+	v3.Post("/auth/login", c.RateLimiter.Middleware(ratelimit.GroupAuth), c.Login)
+	v3.Post("/auth/refresh", c.RateLimiter.Middleware(ratelimit.GroupAuth), c.Refresh)
+	v3.Post("/auth/revoke", c.Revoke)
+}
+
+// Login exchanges a PenguinID for the tokens of a new session, which can be presented in place of the
+// PenguinID as `Authorization: Bearer <accessToken>`.
+func (c *AuthController) Login(ctx *fiber.Ctx) error {
+	var request types.SessionLoginRequest
+	if err := rekuest.ValidBody(ctx, &request); err != nil {
+		return err
+	}
+
+	account, err := c.AccountService.GetAccountByPenguinId(ctx.UserContext(), request.PenguinID)
+	if errors.Is(err, pgerr.ErrNotFound) {
+		flog.WarnFrom(ctx, "session.login.invalid").
+			Msg("failed to log in: PenguinID is invalid")
+		return pgerr.ErrInvalidReq.Msg("PenguinID is invalid")
+	} else if err != nil {
+		return err
+	}
+
+	tokens, err := c.SessionService.Issue(ctx.UserContext(), account, request.Scopes)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(tokens)
+}
+
+func (c *AuthController) Refresh(ctx *fiber.Ctx) error {
+	var request types.SessionRefreshRequest
+	if err := rekuest.ValidBody(ctx, &request); err != nil {
+		return err
+	}
+
+	tokens, err := c.SessionService.Refresh(ctx.UserContext(), request.RefreshToken)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(tokens)
+}
+
+func (c *AuthController) Revoke(ctx *fiber.Ctx) error {
+	var request types.SessionRefreshRequest
+	if err := rekuest.ValidBody(ctx, &request); err != nil {
+		return err
+	}
+
+	if err := c.SessionService.Revoke(ctx.UserContext(), request.RefreshToken); err != nil {
+		return err
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
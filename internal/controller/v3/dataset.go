@@ -10,6 +10,7 @@ import (
 	"go.uber.org/fx"
 	"gopkg.in/guregu/null.v3"
 
+	"exusiai.dev/backend-next/internal/model/types"
 	modelv2 "exusiai.dev/backend-next/internal/model/v2"
 	modelv3 "exusiai.dev/backend-next/internal/model/v3"
 	"exusiai.dev/backend-next/internal/server/svr"
@@ -43,7 +44,7 @@ func (c Dataset) aggregateMatrix(ctx *fiber.Ctx) (*modelv2.DropMatrixQueryResult
 
 	accountId := null.NewInt(0, false)
 	if isPersonal {
-		account, err := c.AccountService.GetAccountFromRequest(ctx)
+		account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReadPersonal)
 		if err != nil {
 			return nil, err
 		}
@@ -86,7 +87,7 @@ func (c Dataset) aggregatePattern(ctx *fiber.Ctx) (*modelv3.PatternMatrixQueryRe
 
 	accountId := null.NewInt(0, false)
 	if isPersonal {
-		account, err := c.AccountService.GetAccountFromRequest(ctx)
+		account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReadPersonal)
 		if err != nil {
 			return nil, err
 		}
@@ -94,7 +95,12 @@ func (c Dataset) aggregatePattern(ctx *fiber.Ctx) (*modelv3.PatternMatrixQueryRe
 		accountId.Valid = true
 	}
 
-	shimResult, err := c.PatternMatrixService.GetShimLatestPatternMatrixResults(ctx.UserContext(), server, accountId, constant.SourceCategoryAll)
+	var query types.PatternMatrixQuery
+	if err := rekuest.ValidQuery(ctx, &query); err != nil {
+		return nil, err
+	}
+
+	shimResult, err := c.PatternMatrixService.GetShimPatternMatrixResults(ctx.UserContext(), server, &query, accountId, constant.SourceCategoryAll)
 	if err != nil {
 		return nil, err
 	}
@@ -1,11 +1,13 @@
 package v3
 
 import (
+	"net/http"
 	"strings"
 	"time"
 
 	"exusiai.dev/gommon/constant"
 	"github.com/gofiber/fiber/v2"
+	"github.com/klauspost/compress/zstd"
 	"go.uber.org/fx"
 
 	dtov3 "exusiai.dev/backend-next/internal/model/dto/v3"
@@ -15,22 +17,36 @@ import (
 	"exusiai.dev/backend-next/internal/service"
 )
 
-var ErrIncrementalInvalidVersions = pgerr.ErrInvalidReq.Msg("invalid versions: `versions` after /patch shall be two `from` and `to` versions, respectively, separated by three dots")
+var (
+	ErrIncrementalInvalidVersions = pgerr.ErrInvalidReq.Msg("invalid versions: `versions` after /patch shall be two `from` and `to` versions, respectively, separated by three dots")
+	ErrIncrementalNotAcceptable   = pgerr.New(http.StatusNotAcceptable, "NOT_ACCEPTABLE", "unsupported diff format: `Accept` shall be one of application/x-bsdiff, application/octet-stream (bsdiff), application/json-patch+json or application/merge-patch+json")
+)
+
+// HeaderIncrementalVersion is the response header of the version of the snapshot a diff results in, i.e. the SHA1
+// hash of its content, for clients to verify the content after applying the diff.
+const HeaderIncrementalVersion = "X-Penguin-Incremental-Version"
+
+// zstdEncoder compresses diffs for clients accepting the zstd content encoding.
+var zstdEncoder, _ = zstd.NewWriter(nil)
 
 type IncrementalController struct {
 	fx.In
 
-	SnapshotService *service.Snapshot
+	SnapshotService         *service.Snapshot
+	SnapshotManifestService *service.SnapshotManifest
 }
 
 func RegisterIncremental(v3 *svr.V3, c IncrementalController) {
 	group := v3.Group("/incremental")
+	group.Get("/public-key", c.GetPublicKey)
+	group.Get("/:server/:realm/manifest", c.GetManifest)
 	group.Get("/:server/:realm/latest", c.GetLatestIncrementalVersion)
 	group.Get("/:server/:realm/patch/:versions", c.GetDiffBetweenVersions)
+	group.Get("/:server/:realm/versions/:version", c.GetSnapshotByVersion)
 }
 
 func (c *IncrementalController) GetLatestIncrementalVersion(ctx *fiber.Ctx) error {
-	snapshot, err := c.SnapshotService.SnapshotRepo.GetLatestSnapshotByKey(ctx.UserContext(), c.GetSnapshotKeyFromPathParams(ctx))
+	snapshot, err := c.SnapshotService.GetLatestSnapshotByKey(ctx.UserContext(), c.GetSnapshotKeyFromPathParams(ctx))
 	if err != nil {
 		return err
 	}
@@ -40,6 +56,24 @@ func (c *IncrementalController) GetLatestIncrementalVersion(ctx *fiber.Ctx) erro
 	})
 }
 
+// GetManifest returns the signed manifest of the latest snapshot, for clients to verify the content they have
+// reconstructed by applying diffs.
+func (c *IncrementalController) GetManifest(ctx *fiber.Ctx) error {
+	manifest, err := c.SnapshotManifestService.GetSignedManifest(ctx.UserContext(), c.GetSnapshotKeyFromPathParams(ctx))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(manifest)
+}
+
+func (c *IncrementalController) GetPublicKey(ctx *fiber.Ctx) error {
+	publicKey, err := c.SnapshotManifestService.GetPublicKey()
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(publicKey)
+}
+
 func (c *IncrementalController) GetDiffBetweenVersions(ctx *fiber.Ctx) error {
 	key := c.GetSnapshotKeyFromPathParams(ctx)
 	var fromVersion, toVersion string
@@ -58,18 +92,97 @@ func (c *IncrementalController) GetDiffBetweenVersions(ctx *fiber.Ctx) error {
 		}
 	}
 
-	diff, err := c.SnapshotService.GetDiffBetweenVersions(ctx.UserContext(), key, fromVersion, toVersion)
+	format, err := negotiateDiffFormat(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.SnapshotService.GetDiffBetweenVersions(ctx.UserContext(), key, fromVersion, toVersion, format)
 	if err != nil {
 		return err
 	}
 
-	if len(diff) == 0 {
+	ctx.Vary(fiber.HeaderAccept, fiber.HeaderAcceptEncoding)
+	ctx.Set(HeaderIncrementalVersion, toVersion)
+
+	if result.Diff == nil {
+		return c.sendPlan(ctx, toVersion, result.Chain)
+	}
+
+	if len(result.Diff) == 0 {
 		return ctx.SendStatus(fiber.StatusNoContent)
 	}
 
 	cachectrl.OptInCustom(ctx, time.Now(), time.Hour*24*365)
 
-	return ctx.Send(diff)
+	ctx.Set(fiber.HeaderContentType, format)
+	return c.sendEncoded(ctx, result.Diff)
+}
+
+// negotiateDiffFormat returns the diff format accepted by the client. Clients that have only opted in to the v3
+// API in the Accept header without asking for a diff format get bsdiff diffs, and clients that have only asked
+// for unsupported diff formats get ErrIncrementalNotAcceptable.
+func negotiateDiffFormat(ctx *fiber.Ctx) (string, error) {
+	format := ctx.Accepts(service.SnapshotDiffFormatBsdiff, fiber.MIMEOctetStream, service.SnapshotDiffFormatJSONPatch, service.SnapshotDiffFormatMergePatch)
+	switch format {
+	case "":
+		for _, mediaType := range strings.Split(ctx.Get(fiber.HeaderAccept), ",") {
+			mediaType, _, _ = strings.Cut(mediaType, ";")
+			if mediaType = strings.TrimSpace(mediaType); mediaType != "" && mediaType != svr.MIMEV3 {
+				return "", ErrIncrementalNotAcceptable
+			}
+		}
+		return service.SnapshotDiffFormatBsdiff, nil
+	case fiber.MIMEOctetStream:
+		return service.SnapshotDiffFormatBsdiff, nil
+	default:
+		return format, nil
+	}
+}
+
+// GetSnapshotByVersion returns the full content of a snapshot, for clients which cannot reach it by diffs.
+func (c *IncrementalController) GetSnapshotByVersion(ctx *fiber.Ctx) error {
+	version := ctx.Params("version")
+	snapshot, err := c.SnapshotService.GetSnapshotByVersion(ctx.UserContext(), c.GetSnapshotKeyFromPathParams(ctx), version)
+	if err != nil {
+		return err
+	}
+
+	ctx.Vary(fiber.HeaderAcceptEncoding)
+	ctx.Set(HeaderIncrementalVersion, version)
+	cachectrl.OptInCustom(ctx, time.Now(), time.Hour*24*365)
+
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.sendEncoded(ctx, []byte(snapshot.Content))
+}
+
+// sendPlan responds with 300 Multiple Choices telling the client to reach toVersion by applying the precomputed
+// diffs of chain one after another, as there is no precomputed diff to it.
+func (c *IncrementalController) sendPlan(ctx *fiber.Ctx, toVersion string, chain []string) error {
+	base := strings.TrimSuffix(ctx.Path(), "/patch/"+ctx.Params("versions"))
+	plan := &dtov3.IncrementalPlanResponse{
+		Plan:    dtov3.IncrementalPlanChain,
+		Version: toVersion,
+	}
+	for i := 1; i < len(chain); i++ {
+		plan.Steps = append(plan.Steps, &dtov3.IncrementalPlanStep{
+			From: chain[i-1],
+			To:   chain[i],
+			URL:  base + "/patch/" + chain[i-1] + "..." + chain[i],
+		})
+	}
+
+	// plans change as snapshots are saved and precomputed diffs expire
+	cachectrl.OptOut(ctx)
+	return ctx.Status(fiber.StatusMultipleChoices).JSON(plan)
+}
+
+func (c *IncrementalController) sendEncoded(ctx *fiber.Ctx, body []byte) error {
+	if ctx.AcceptsEncodings("identity", "zstd") == "zstd" {
+		ctx.Set(fiber.HeaderContentEncoding, "zstd")
+		return ctx.Send(zstdEncoder.EncodeAll(body, make([]byte, 0, len(body))))
+	}
+	return ctx.Send(body)
 }
 
 func (c *IncrementalController) GetSnapshotKeyFromPathParams(ctx *fiber.Ctx) string {
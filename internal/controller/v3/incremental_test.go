@@ -0,0 +1,63 @@
+package v3
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/server/svr"
+	"exusiai.dev/backend-next/internal/service"
+)
+
+func TestNegotiateDiffFormat(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: func(ctx *fiber.Ctx, err error) error {
+		if pe, ok := err.(*pgerr.PenguinError); ok {
+			return ctx.SendStatus(pe.StatusCode)
+		}
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}})
+	_, v3, _, _ := svr.CreateEndpointGroups(app, &appconfig.Config{})
+	v3.Get("/format", func(ctx *fiber.Ctx) error {
+		format, err := negotiateDiffFormat(ctx)
+		if err != nil {
+			return err
+		}
+		return ctx.SendString(format)
+	})
+
+	tests := []struct {
+		name   string
+		accept string
+		status int
+		format string
+	}{
+		{"v3 opt-in only", svr.MIMEV3, fiber.StatusOK, service.SnapshotDiffFormatBsdiff},
+		{"v3 opt-in with parameters", svr.MIMEV3 + ";q=0.9", fiber.StatusOK, service.SnapshotDiffFormatBsdiff},
+		{"bsdiff", svr.MIMEV3 + ", " + service.SnapshotDiffFormatBsdiff, fiber.StatusOK, service.SnapshotDiffFormatBsdiff},
+		{"octet-stream", svr.MIMEV3 + ", " + fiber.MIMEOctetStream, fiber.StatusOK, service.SnapshotDiffFormatBsdiff},
+		{"json patch", svr.MIMEV3 + ", " + service.SnapshotDiffFormatJSONPatch, fiber.StatusOK, service.SnapshotDiffFormatJSONPatch},
+		{"merge patch", svr.MIMEV3 + ", " + service.SnapshotDiffFormatMergePatch, fiber.StatusOK, service.SnapshotDiffFormatMergePatch},
+		{"wildcard", svr.MIMEV3 + ", */*", fiber.StatusOK, service.SnapshotDiffFormatBsdiff},
+		{"unsupported format", svr.MIMEV3 + ", application/vnd.example.diff", fiber.StatusNotAcceptable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v3alpha/format", nil)
+			req.Header.Set(fiber.HeaderAccept, tt.accept)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.status == fiber.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, tt.format, string(body))
+			}
+		})
+	}
+}
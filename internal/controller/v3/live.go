@@ -1,78 +1,437 @@
 package v3
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"time"
+
+	"exusiai.dev/gommon/constant"
+	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+	"google.golang.org/protobuf/proto"
 
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	dtov3 "exusiai.dev/backend-next/internal/model/dto/v3"
+	"exusiai.dev/backend-next/internal/model/pb"
+	"exusiai.dev/backend-next/internal/model/types"
+	"exusiai.dev/backend-next/internal/pkg/cachectrl"
+	"exusiai.dev/backend-next/internal/pkg/middlewares"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
 	"exusiai.dev/backend-next/internal/server/svr"
+	"exusiai.dev/backend-next/internal/service"
+	"exusiai.dev/backend-next/internal/util"
+	"exusiai.dev/backend-next/internal/util/rekuest"
 )
 
-type LiveController struct{}
+// liveReadLimit is the maximum size of a message sent by a /v3/live client. Clients only send
+// subscription requests and probe events, which are a few hundred bytes long at most.
+const liveReadLimit = 2048
+
+type LiveController struct {
+	fx.In
 
-func RegisterLive(v3 *svr.V3) {
-	c := &LiveController{}
+	LiveHubService           *service.LiveHub
+	LiveHouseEmbeddedService *service.LiveHouseEmbedded
+	ProbeService             *service.Probe
+	StageService             *service.Stage
+	ItemService              *service.Item
+	Config                   *appconfig.Config
+}
 
-	v3.Get("/live", c.Live())
+func RegisterLive(v3 *svr.V3, c LiveController) {
+	v3.AcceptOptOut(fiber.MethodGet, "/live", c.Upgrade, c.Live())
+	v3.AcceptOptOut(fiber.MethodGet, "/live/events/:server", middlewares.ValidateServerAsParam, c.Events)
+	v3.Get("/live/matrix/:server", middlewares.ValidateServerAsParam, c.GetLiveMatrix)
 }
 
+func (c *LiveController) Upgrade(ctx *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(ctx) {
+		return fiber.ErrUpgradeRequired
+	}
+	// the request is gone once the connection is upgraded, so keep what the probe ingestion needs
+	ctx.Locals("ip", util.ExtractIP(ctx))
+	ctx.Locals("acceptLanguage", ctx.Get(fiber.HeaderAcceptLanguage))
+	return ctx.Next()
+}
+
+// Live serves the matrix subscription protocol: clients send MatrixUpdateSubscribeReq messages to subscribe
+// to a stage or an item of a server, each answered by a MatrixUpdateSubscribeResp, and receive
+// MatrixUpdateMessage deltas of their subscriptions as reports are accepted. Clients may also send probe
+// events as JSON text messages, each acknowledged by a PROBE_SERVER_ACK.
 func (c *LiveController) Live() func(ctx *fiber.Ctx) error {
-	// return websocket.New(func(c *websocket.Conn) {
-	// 	defer c.Close()
-
-	// 	_, b, err := c.ReadMessage()
-	// 	if err != nil {
-	// 		log.Println(err)
-	// 		return
-	// 	}
-
-	// 	log.Println(hex.EncodeToString(b))
-
-	// 	elements := []*protos.MatrixUpdateMessage_Segment_Element{}
-	// 	for i := 0; i < 25; i++ {
-	// 		elements = append(elements, &protos.MatrixUpdateMessage_Segment_Element{
-	// 			Id: &protos.MatrixUpdateMessage_Segment_Element_StageId{
-	// 				StageId: int32(rand.Intn(100)),
-	// 			},
-	// 			Amount: int32(rand.Intn(1000000)),
-	// 		})
-	// 	}
-
-	// 	segments := []*protos.MatrixUpdateMessage_Segment{}
-	// 	for i := 0; i < 3; i++ {
-	// 		segments = append(segments, &protos.MatrixUpdateMessage_Segment{
-	// 			Bucket: &protos.MatrixUpdateMessage_Segment_ItemId{
-	// 				ItemId: int32(rand.Intn(500)),
-	// 			},
-	// 			Elements: elements,
-	// 		})
-	// 	}
-
-	// 	msg := protos.MatrixUpdateMessage{
-	// 		Header: &protos.Header{
-	// 			Type: protos.MessageType_MATRIX_ABSOLUTE_UPDATE_MESSAGE,
-	// 		},
-	// 		Segments: segments,
-	// 	}
-
-	// 	res, err := proto.Marshal(&msg)
-	// 	if err != nil {
-	// 		log.Println(err)
-	// 		return
-	// 	}
-
-	// 	c.WriteMessage(websocket.BinaryMessage, res)
-
-	// 	time.Sleep(time.Millisecond * 100)
-
-	// 	err = c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second*5))
-	// 	if err != nil {
-	// 		log.Println(err)
-	// 		return
-	// 	}
-	// }, websocket.Config{
-	// 	Subprotocols:      []string{"v3.penguin-stats.live+proto"},
-	// 	EnableCompression: true,
-	// })
-	return func(ctx *fiber.Ctx) error {
-		return nil
+	return websocket.New(c.serve, websocket.Config{
+		Subprotocols:      []string{"v3.penguin-stats.live+proto"},
+		EnableCompression: true,
+	})
+}
+
+func (c *LiveController) serve(conn *websocket.Conn) {
+	client := c.LiveHubService.Connect()
+	defer client.Close()
+
+	heartbeat := c.Config.LiveHeartbeatInterval
+	conn.SetReadLimit(liveReadLimit)
+	_ = conn.SetReadDeadline(time.Now().Add(heartbeat * 2))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(heartbeat * 2))
+	})
+
+	// responses are written by the writer alone, as the connection does not support concurrent writers
+	responses := make(chan []byte, 1)
+	readerDone := make(chan struct{})
+	go c.read(conn, client, responses, readerDone)
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case u := <-client.Send():
+			var b []byte
+			b, err = proto.Marshal(&pb.MatrixUpdateMessage{
+				Header:   &pb.Header{Type: pb.MessageType_MATRIX_UPDATE_MESSAGE},
+				Segments: u.Elements,
+			})
+			if err == nil {
+				err = c.write(conn, websocket.BinaryMessage, b)
+			}
+		case b := <-responses:
+			err = c.write(conn, websocket.BinaryMessage, b)
+		case <-ticker.C:
+			err = c.write(conn, websocket.PingMessage, nil)
+		case <-client.Closed():
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow"),
+				time.Now().Add(time.Second*5))
+			return
+		case <-readerDone:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *LiveController) write(conn *websocket.Conn, messageType int, b []byte) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(c.Config.LiveHeartbeatInterval))
+	return conn.WriteMessage(messageType, b)
+}
+
+// probe ingests a probe event sent as a text message, and returns the acknowledgement to reply with.
+func (c *LiveController) probe(conn *websocket.Conn, b []byte) ([]byte, bool) {
+	var event types.ProbeEvent
+	if err := json.Unmarshal(b, &event); err != nil {
+		return nil, false
+	}
+	if err := rekuest.Validate.Struct(&event); err != nil {
+		return nil, false
+	}
+
+	ip, _ := conn.Locals("ip").(string)
+	acceptLanguage, _ := conn.Locals("acceptLanguage").(string)
+	c.ProbeService.Ingest(ip, acceptLanguage, []*types.ProbeEvent{&event})
+
+	out, err := proto.Marshal(&pb.Skeleton{
+		Header: &pb.Header{Type: pb.MessageType_PROBE_SERVER_ACK},
+	})
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+func (c *LiveController) read(conn *websocket.Conn, client *service.LiveClient, responses chan<- []byte, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		messageType, b, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Debug().
+					Str("evt.name", "live.read.failed").
+					Err(err).
+					Msg("failed to read live message")
+			}
+			return
+		}
+		if messageType == websocket.TextMessage {
+			out, ok := c.probe(conn, b)
+			if !ok {
+				continue
+			}
+			select {
+			case responses <- out:
+			case <-client.Closed():
+				return
+			}
+			continue
+		}
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		var skeleton pb.Skeleton
+		if err := proto.Unmarshal(b, &skeleton); err != nil {
+			return
+		}
+		if skeleton.GetHeader().GetType() != pb.MessageType_MATRIX_UPDATE_SUBSCRIBE_REQ {
+			continue
+		}
+
+		resp := &pb.MatrixUpdateSubscribeResp{
+			Header: &pb.Header{Type: pb.MessageType_MATRIX_UPDATE_SUBSCRIBE_RESP},
+		}
+		var req pb.MatrixUpdateSubscribeReq
+		if err := proto.Unmarshal(b, &req); err != nil {
+			return
+		}
+		if err := client.Subscribe(&req); err != nil {
+			resp.Error = err.Error()
+		}
+
+		out, err := proto.Marshal(resp)
+		if err != nil {
+			return
+		}
+		select {
+		case responses <- out:
+		case <-client.Closed():
+			return
+		}
+	}
+}
+
+// Events streams the matrix updates of the stages and items given by the comma separated `stageId` and `itemId`
+// queries as Server-Sent Events, for clients unable to hold a WebSocket. Every update is a `matrix` event of a
+// JSON-encoded dtov3.LiveMatrixUpdate. Clients reconnecting with Last-Event-ID receive the updates they have missed
+// from the replay buffer; if some of them are no longer available, a `reset` event is sent first, telling the
+// client to fetch the matrix again.
+func (c *LiveController) Events(ctx *fiber.Ctx) error {
+	server := ctx.Params("server")
+	pbserv := pb.Server(constant.ServerIDMapping[server])
+
+	stageIds := cachectrl.SplitFilter(ctx.Query("stageId"))
+	itemIds := cachectrl.SplitFilter(ctx.Query("itemId"))
+	if len(stageIds) == 0 && len(itemIds) == 0 {
+		return pgerr.ErrInvalidReq.Msg("either `stageId` or `itemId` is required")
+	}
+
+	client := c.LiveHubService.Connect()
+	subscribed := false
+	defer func() {
+		if !subscribed {
+			client.Close()
+		}
+	}()
+
+	for _, arkStageId := range stageIds {
+		stage, err := c.StageService.GetStageByArkId(ctx.UserContext(), arkStageId)
+		if err != nil {
+			return err
+		}
+		if err := client.Subscribe(&pb.MatrixUpdateSubscribeReq{
+			Server: pbserv,
+			Id:     &pb.MatrixUpdateSubscribeReq_StageId{StageId: uint32(stage.StageID)},
+		}); err != nil {
+			return pgerr.ErrInvalidReq.Msg(err.Error())
+		}
+	}
+	for _, arkItemId := range itemIds {
+		item, err := c.ItemService.GetItemByArkId(ctx.UserContext(), arkItemId)
+		if err != nil {
+			return err
+		}
+		if err := client.Subscribe(&pb.MatrixUpdateSubscribeReq{
+			Server: pbserv,
+			Id:     &pb.MatrixUpdateSubscribeReq_ItemId{ItemId: uint32(item.ItemID)},
+		}); err != nil {
+			return pgerr.ErrInvalidReq.Msg(err.Error())
+		}
 	}
+
+	// the client is subscribed before replaying, so that no update is lost in between; the updates received
+	// both ways are deduplicated by their IDs
+	var replay []*service.LiveUpdate
+	reset := false
+	if lastEventID := ctx.Get("Last-Event-ID"); lastEventID != "" {
+		updates, complete, err := c.LiveHubService.Replay(ctx.UserContext(), client, lastEventID)
+		if err != nil {
+			log.Warn().
+				Str("evt.name", "live.replay.failed").
+				Err(err).
+				Msg("failed to replay live updates")
+		}
+		replay, reset = updates, !complete
+	}
+
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	ctx.Set(fiber.HeaderConnection, "keep-alive")
+	ctx.Set("X-Accel-Buffering", "no")
+	cachectrl.OptOut(ctx)
+
+	subscribed = true
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer client.Close()
+		if err := c.stream(w, client, replay, reset); err != nil {
+			log.Debug().
+				Str("evt.name", "live.events.closed").
+				Err(err).
+				Msg("live event stream closed")
+		}
+	})
+	return nil
+}
+
+func (c *LiveController) stream(w *bufio.Writer, client *service.LiveClient, replay []*service.LiveUpdate, reset bool) error {
+	if reset {
+		if _, err := fmt.Fprint(w, "event: reset\ndata: {}\n\n"); err != nil {
+			return err
+		}
+	}
+
+	var lastEventID string
+	for _, u := range replay {
+		if err := c.writeEvent(w, u); err != nil {
+			return err
+		}
+		lastEventID = u.ID
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(c.Config.LiveHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case u := <-client.Send():
+			if u.ID != "" && service.CompareLiveEventIDs(u.ID, lastEventID) <= 0 {
+				continue
+			}
+			if err := c.writeEvent(w, u); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return err
+			}
+		case <-client.Closed():
+			return errors.New("client too slow")
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *LiveController) writeEvent(w *bufio.Writer, u *service.LiveUpdate) error {
+	data, err := c.renderUpdate(u)
+	if err != nil {
+		return err
+	}
+	if u.ID != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", u.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: matrix\ndata: %s\n\n", data)
+	return err
+}
+
+// renderUpdate renders an update with the string form IDs of its stages and items.
+func (c *LiveController) renderUpdate(u *service.LiveUpdate) ([]byte, error) {
+	arkIds, err := c.arkIds(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	update := &dtov3.LiveMatrixUpdate{
+		Elements: make([]*dtov3.LiveMatrixElement, 0, len(u.Elements)),
+	}
+	for _, el := range u.Elements {
+		arkStageId, arkItemId, ok := arkIds(el.StageId, el.ItemId)
+		if !ok {
+			continue
+		}
+		update.Elements = append(update.Elements, &dtov3.LiveMatrixElement{
+			Server:   el.Server.String(),
+			StageID:  arkStageId,
+			ItemID:   arkItemId,
+			Quantity: el.Quantity,
+			Times:    el.Times,
+		})
+	}
+	return json.Marshal(update)
+}
+
+// GetLiveMatrix returns the live matrix of a server kept by the embedded LiveHouse.
+func (c *LiveController) GetLiveMatrix(ctx *fiber.Ctx) error {
+	server := ctx.Params("server")
+	matrix, err := c.LiveHouseEmbeddedService.LiveMatrix(ctx.UserContext(), pb.Server(constant.ServerIDMapping[server]))
+	if err != nil {
+		return err
+	}
+	arkIds, err := c.arkIds(ctx.UserContext())
+	if err != nil {
+		return err
+	}
+
+	resp := &dtov3.LiveMatrixResponse{
+		Server:     server,
+		Generation: matrix.Generation,
+		Matrix:     make([]*dtov3.LiveMatrixElement, 0, len(matrix.Matrix)),
+	}
+	for _, m := range matrix.Matrix {
+		arkStageId, arkItemId, ok := arkIds(m.StageId, m.ItemId)
+		if !ok {
+			continue
+		}
+		resp.Matrix = append(resp.Matrix, &dtov3.LiveMatrixElement{
+			Server:   server,
+			StageID:  arkStageId,
+			ItemID:   arkItemId,
+			Quantity: m.Quantity,
+			Times:    m.Times,
+		})
+	}
+
+	cachectrl.OptOut(ctx)
+	return ctx.JSON(resp)
+}
+
+// arkIds returns a function resolving the string form IDs of a stage and an item by their numerical IDs.
+// An item ID of 0 resolves to an empty string; ok is false if either of them is unknown.
+func (c *LiveController) arkIds(ctx context.Context) (func(stageId, itemId uint32) (arkStageId, arkItemId string, ok bool), error) {
+	stages, err := c.StageService.GetStagesMapById(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items, err := c.ItemService.GetItemsMapById(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(stageId, itemId uint32) (string, string, bool) {
+		stage, ok := stages[int(stageId)]
+		if !ok {
+			return "", "", false
+		}
+		if itemId == 0 {
+			return stage.ArkStageID, "", true
+		}
+		item, ok := items[int(itemId)]
+		if !ok {
+			return "", "", false
+		}
+		return stage.ArkStageID, item.ArkItemID, true
+	}, nil
 }
@@ -0,0 +1,157 @@
+package v3
+
+import (
+	"bufio"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+	"gopkg.in/guregu/null.v3"
+
+	"exusiai.dev/backend-next/internal/model"
+	"exusiai.dev/backend-next/internal/model/types"
+	modelv3 "exusiai.dev/backend-next/internal/model/v3"
+	"exusiai.dev/backend-next/internal/pkg/flog"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/pkg/ratelimit"
+	"exusiai.dev/backend-next/internal/server/svr"
+	"exusiai.dev/backend-next/internal/service"
+	"exusiai.dev/backend-next/internal/util/rekuest"
+)
+
+type MeController struct {
+	fx.In
+
+	AccountService    *service.Account
+	AccountJobService *service.AccountJob
+	RateLimiter       *ratelimit.Limiter
+}
+
+func RegisterMe(v3 *svr.V3, c MeController) {
+	v3.Delete("/me", c.Delete)
+	v3.Post("/me/merge", c.RateLimiter.Middleware(ratelimit.GroupAuth), c.Merge)
+	v3.Delete("/me/sessions", c.RevokeSessions)
+	// GET is the route clients have been given; POST is accepted as well since submitting an export is not safe
+	v3.Get("/me/export", c.Export)
+	v3.Post("/me/export", c.Export)
+	v3.Get("/me/jobs/:id", c.GetJob)
+	v3.Get("/me/jobs/:id/archive", c.GetArchive)
+}
+
+// Merge merges the account of the PenguinID in the body into the account of the requester, so that a user who
+// has lost their PenguinID on a device can take the reports of one of them over to the other. Presenting both
+// PenguinIDs proves the ownership of both accounts.
+func (c *MeController) Merge(ctx *fiber.Ctx) error {
+	account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeAccount)
+	if err != nil {
+		return err
+	}
+
+	var request types.MergeIntoAccountRequest
+	if err := rekuest.ValidBody(ctx, &request); err != nil {
+		return err
+	}
+
+	merge, err := c.AccountService.MergeAccounts(ctx.UserContext(), request.SourcePenguinID, account.PenguinID, model.AccountMergeInitiatorSelf)
+	if errors.Is(err, pgerr.ErrNotFound) {
+		flog.WarnFrom(ctx, "account.merge.invalid").
+			Int("accountId", account.AccountID).
+			Msg("failed to merge account: source PenguinID is invalid")
+		return pgerr.ErrInvalidReq.Msg("PenguinID is invalid")
+	} else if err != nil {
+		return err
+	}
+	return ctx.JSON(merge)
+}
+
+// RevokeSessions revokes every session of the requester, along with their session tokens.
+func (c *MeController) RevokeSessions(ctx *fiber.Ctx) error {
+	account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeAccount)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := c.AccountService.SessionService.RevokeAccount(ctx.UserContext(), account.AccountID)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(fiber.Map{"revoked": revoked})
+}
+
+// Export enqueues an export job of the data of the requester. The archive can be downloaded from the job once it
+// has succeeded.
+func (c *MeController) Export(ctx *fiber.Ctx) error {
+	return c.submit(ctx, modelv3.AccountJobKindExport, "jobs/")
+}
+
+// Delete enqueues a deletion job of the data of the requester, carried out as per the configured deletion policy.
+func (c *MeController) Delete(ctx *fiber.Ctx) error {
+	return c.submit(ctx, modelv3.AccountJobKindDeletion, "me/jobs/")
+}
+
+// submit enqueues a job of kind for the requester, and responds with the job located relatively at jobsPath.
+func (c *MeController) submit(ctx *fiber.Ctx, kind string, jobsPath string) error {
+	account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeAccount)
+	if err != nil {
+		return err
+	}
+
+	job, err := c.AccountJobService.Submit(ctx.UserContext(), account, kind)
+	if err != nil {
+		return err
+	}
+	ctx.Location(jobsPath + job.JobID)
+	return ctx.Status(fiber.StatusAccepted).JSON(job)
+}
+
+func (c *MeController) GetJob(ctx *fiber.Ctx) error {
+	jobId := ctx.Params("id")
+	if err := rekuest.ValidVar(ctx, jobId, "required,alphanum,max=32"); err != nil {
+		return err
+	}
+
+	// the account is only required for export jobs
+	accountId := null.NewInt(0, false)
+	if account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeAccount); err == nil {
+		accountId = null.IntFrom(int64(account.AccountID))
+	}
+
+	job, err := c.AccountJobService.GetJob(ctx.UserContext(), jobId, accountId)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(job)
+}
+
+func (c *MeController) GetArchive(ctx *fiber.Ctx) error {
+	jobId := ctx.Params("id")
+	if err := rekuest.ValidVar(ctx, jobId, "required,alphanum,max=32"); err != nil {
+		return err
+	}
+	account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeAccount)
+	if err != nil {
+		return err
+	}
+
+	writeArchive, err := c.AccountJobService.GetArchive(ctx.UserContext(), jobId, account.AccountID)
+	if err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderContentType, "application/gzip")
+	ctx.Set(fiber.HeaderCacheControl, "private, no-store")
+	ctx.Attachment("penguin-stats-export-" + jobId + ".json.gz")
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := writeArchive(w); err != nil {
+			// the response has been started already, so the client is left with a truncated archive
+			log.Error().
+				Str("evt.name", "account.export.download_failed").
+				Str("jobId", jobId).
+				Err(err).
+				Msg("failed to stream export archive")
+		}
+	})
+	return nil
+}
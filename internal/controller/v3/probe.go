@@ -0,0 +1,40 @@
+package v3
+
+import (
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/fx"
+
+	"exusiai.dev/backend-next/internal/model/types"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/server/svr"
+	"exusiai.dev/backend-next/internal/service"
+	"exusiai.dev/backend-next/internal/util"
+	"exusiai.dev/backend-next/internal/util/rekuest"
+)
+
+type ProbeController struct {
+	fx.In
+
+	ProbeService *service.Probe
+}
+
+func RegisterProbe(v3 *svr.V3, c ProbeController) {
+	v3.AcceptOptOut(fiber.MethodPost, "/probe", c.Beacon)
+}
+
+// Beacon ingests a batch of probe events. The body is parsed as JSON regardless of its content type, as
+// navigator.sendBeacon() sends strings as text/plain.
+func (c *ProbeController) Beacon(ctx *fiber.Ctx) error {
+	var req types.ProbeBeaconRequest
+	if err := json.Unmarshal(ctx.Body(), &req); err != nil {
+		return pgerr.ErrInvalidReq.Msg("invalid request: %s", err)
+	}
+	if err := rekuest.ValidStruct(ctx, &req); err != nil {
+		return err
+	}
+
+	c.ProbeService.Ingest(util.ExtractIP(ctx), ctx.Get(fiber.HeaderAcceptLanguage), req.Events)
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
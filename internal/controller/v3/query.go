@@ -0,0 +1,74 @@
+package v3
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/fx"
+	"gopkg.in/guregu/null.v3"
+
+	"exusiai.dev/backend-next/internal/model/types"
+	"exusiai.dev/backend-next/internal/pkg/ratelimit"
+	"exusiai.dev/backend-next/internal/server/svr"
+	"exusiai.dev/backend-next/internal/service"
+	"exusiai.dev/backend-next/internal/util/rekuest"
+)
+
+type QueryController struct {
+	fx.In
+
+	AccountService  *service.Account
+	QueryJobService *service.QueryJob
+	RateLimiter     *ratelimit.Limiter
+}
+
+func RegisterQuery(v3 *svr.V3, c QueryController) {
+	v3.Post("/query", c.RateLimiter.Middleware(ratelimit.GroupQueryV3), c.Query)
+	v3.Get("/query-jobs/:id", c.GetQueryJob)
+}
+
+func (c *QueryController) Query(ctx *fiber.Ctx) error {
+	var request types.ArbitraryQueryRequest
+	if err := rekuest.ValidBody(ctx, &request); err != nil {
+		return err
+	}
+
+	accountId := null.NewInt(0, false)
+	if request.IsPersonal.Valid && request.IsPersonal.Bool {
+		account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReadPersonal)
+		if err != nil {
+			return err
+		}
+		accountId.Int64 = int64(account.AccountID)
+		accountId.Valid = true
+	}
+
+	result, job, err := c.QueryJobService.Submit(ctx.UserContext(), &request, accountId)
+	if err != nil {
+		return err
+	}
+	if job != nil {
+		ctx.Location("query-jobs/" + job.JobID)
+		return ctx.Status(fiber.StatusAccepted).JSON(job)
+	}
+
+	return ctx.JSON(result)
+}
+
+func (c *QueryController) GetQueryJob(ctx *fiber.Ctx) error {
+	jobId := ctx.Params("id")
+	if err := rekuest.ValidVar(ctx, jobId, "required,alphanum,max=32"); err != nil {
+		return err
+	}
+
+	// the account is only required for personal query jobs
+	accountId := null.NewInt(0, false)
+	if account, err := c.AccountService.GetAccountFromRequest(ctx, service.SessionScopeReadPersonal); err == nil {
+		accountId = null.IntFrom(int64(account.AccountID))
+	}
+
+	job, err := c.QueryJobService.GetJob(ctx.UserContext(), jobId, accountId)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(job)
+}
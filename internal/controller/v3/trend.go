@@ -0,0 +1,87 @@
+package v3
+
+import (
+	"exusiai.dev/gommon/constant"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/fx"
+
+	"exusiai.dev/backend-next/internal/model"
+	"exusiai.dev/backend-next/internal/model/types"
+	modelv2 "exusiai.dev/backend-next/internal/model/v2"
+	modelv3 "exusiai.dev/backend-next/internal/model/v3"
+	"exusiai.dev/backend-next/internal/pkg/cachectrl"
+	"exusiai.dev/backend-next/internal/pkg/middlewares"
+	"exusiai.dev/backend-next/internal/server/svr"
+	"exusiai.dev/backend-next/internal/service"
+	"exusiai.dev/backend-next/internal/util/rekuest"
+)
+
+type TrendController struct {
+	fx.In
+
+	TrendService *service.Trend
+}
+
+func RegisterTrend(v3 *svr.V3, c TrendController) {
+	v3.Get("/trends/:server", middlewares.ValidateServerAsParam, cachectrl.Surrogate(trendsTags), cachectrl.Conditional(resolveTrends), c.GetTrends)
+}
+
+func (c *TrendController) GetTrends(ctx *fiber.Ctx) error {
+	server := ctx.Params("server")
+
+	var query types.TrendQuery
+	if err := rekuest.ValidQuery(ctx, &query); err != nil {
+		return err
+	}
+	if query.Interval == "" {
+		query.Interval = model.TrendGranularityDay
+	}
+	if query.SourceCategory == "" {
+		query.SourceCategory = constant.SourceCategoryAll
+	}
+
+	result, err := c.TrendService.GetShimTrendResultsByGranularity(ctx.UserContext(), server, query.Interval, query.SourceCategory)
+	if err != nil {
+		return err
+	}
+
+	if query.StageID == "" && query.ItemID == "" {
+		return ctx.JSON(result)
+	}
+
+	filtered := &modelv3.TrendQueryResult{
+		Granularity: result.Granularity,
+		Trend:       make(map[string]*modelv2.StageTrend),
+	}
+	for stageId, stageTrend := range result.Trend {
+		if query.StageID != "" && stageId != query.StageID {
+			continue
+		}
+		if query.ItemID == "" {
+			filtered.Trend[stageId] = stageTrend
+			continue
+		}
+		if itemTrend, ok := stageTrend.Results[query.ItemID]; ok {
+			filtered.Trend[stageId] = &modelv2.StageTrend{
+				StartTime: stageTrend.StartTime,
+				Results:   map[string]*modelv2.OneItemTrend{query.ItemID: itemTrend},
+			}
+		}
+	}
+
+	return ctx.JSON(filtered)
+}
+
+// resolveTrends resolves the cache entry of trend requests which are not filtered by stage or item.
+func resolveTrends(ctx *fiber.Ctx) (name string, key string, ok bool) {
+	if ctx.Query("stageId") != "" || ctx.Query("itemId") != "" {
+		return "", "", false
+	}
+	interval := ctx.Query("interval", model.TrendGranularityDay)
+	sourceCategory := ctx.Query("sourceCategory", constant.SourceCategoryAll)
+	return "shimTrendResults#server|granularity|sourceCategory", ctx.Params("server") + constant.CacheSep + interval + constant.CacheSep + sourceCategory, true
+}
+
+func trendsTags(ctx *fiber.Ctx) []string {
+	return cachectrl.ResponseTags(cachectrl.TagTrend, ctx.Params("server"), cachectrl.SplitFilter(ctx.Query("stageId")), cachectrl.SplitFilter(ctx.Query("itemId")))
+}
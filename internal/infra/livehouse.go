@@ -10,7 +10,7 @@ import (
 )
 
 func LiveHouse(conf *appconfig.Config) (pb.ConnectedLiveServiceClient, error) {
-	if conf.LiveHouseEnabled {
+	if conf.LiveHouseEnabled && conf.LiveHouseMode == "grpc" {
 		conn, err := grpc.Dial(conf.LiveHouseGRPCAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
 		if err != nil {
 			log.Error().Err(err).Msg("infra: failed to connect to livehouse")
@@ -18,7 +18,7 @@ func LiveHouse(conf *appconfig.Config) (pb.ConnectedLiveServiceClient, error) {
 		}
 
 		return pb.NewConnectedLiveServiceClient(conn), nil
-	} else {
+	} else if !conf.LiveHouseEnabled {
 		log.Info().Msg("infra: livehouse is disabled")
 	}
 
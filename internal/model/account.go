@@ -6,6 +6,12 @@ import (
 	"github.com/uptrace/bun"
 )
 
+const (
+	AccountStatusActive       = "active"
+	AccountStatusBanned       = "banned"
+	AccountStatusShadowbanned = "shadowbanned"
+)
+
 type Account struct {
 	bun.BaseModel `bun:"accounts"`
 
@@ -14,4 +20,34 @@ type Account struct {
 	Weight    float64 `json:"weight"`
 	// Tags      []string `json:"tags"`
 	CreatedAt time.Time `json:"createdAt"`
+
+	// The moderation state below is never serialized along with the account, as a shadowban the user can see is
+	// not one. Admins see it through AdminAccount.
+
+	// Status is one of AccountStatusActive, AccountStatusBanned and AccountStatusShadowbanned.
+	Status string `bun:",nullzero,notnull,default:'active'" json:"-"`
+	// TrustScore ranges from 0 to 100. Accounts scoring at least AccountTrustedScore skip expensive verifiers.
+	TrustScore       int        `bun:",notnull" json:"-"`
+	ModerationReason string     `json:"-"`
+	ModeratedAt      *time.Time `json:"-"`
+}
+
+// AdminAccount is the representation of an account to admins, including its moderation state.
+type AdminAccount struct {
+	*Account
+
+	Status           string     `json:"status"`
+	TrustScore       int        `json:"trustScore"`
+	ModerationReason string     `json:"moderationReason,omitempty"`
+	ModeratedAt      *time.Time `json:"moderatedAt,omitempty"`
+}
+
+func NewAdminAccount(account *Account) *AdminAccount {
+	return &AdminAccount{
+		Account:          account,
+		Status:           account.Status,
+		TrustScore:       account.TrustScore,
+		ModerationReason: account.ModerationReason,
+		ModeratedAt:      account.ModeratedAt,
+	}
 }
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	AccountMergeInitiatorAdmin = "admin"
+	AccountMergeInitiatorSelf  = "self"
+)
+
+// AccountMerge is the audit record of an account merged into another one. The source account is deleted by the
+// merge, so its PenguinID is only kept here.
+type AccountMerge struct {
+	bun.BaseModel `bun:"account_merges"`
+
+	MergeID         int       `bun:",pk,autoincrement" json:"id"`
+	SourceAccountID int       `json:"sourceAccountId"`
+	SourcePenguinID string    `json:"sourcePenguinId"`
+	TargetAccountID int       `json:"targetAccountId"`
+	TargetPenguinID string    `json:"targetPenguinId"`
+	Reports         int       `json:"reports"`
+	Defects         int       `json:"defects"`
+	Initiator       string    `json:"initiator"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
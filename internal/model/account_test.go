@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountModerationStateIsAdminOnly(t *testing.T) {
+	now := time.Now()
+	account := &Account{
+		AccountID:        1,
+		PenguinID:        "12345678",
+		Status:           AccountStatusShadowbanned,
+		TrustScore:       10,
+		ModerationReason: "spam",
+		ModeratedAt:      &now,
+	}
+
+	var user map[string]any
+	b, err := json.Marshal(account)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &user))
+	assert.Equal(t, "12345678", user["penguinId"])
+	for _, field := range []string{"status", "trustScore", "moderationReason", "moderatedAt"} {
+		assert.NotContains(t, user, field)
+	}
+
+	var admin map[string]any
+	b, err = json.Marshal(NewAdminAccount(account))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &admin))
+	assert.Equal(t, "12345678", admin["penguinId"])
+	assert.Equal(t, AccountStatusShadowbanned, admin["status"])
+	assert.EqualValues(t, 10, admin["trustScore"])
+	assert.Equal(t, "spam", admin["moderationReason"])
+	assert.Contains(t, admin, "moderatedAt")
+}
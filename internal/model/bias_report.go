@@ -0,0 +1,49 @@
+package model
+
+// BiasReport compares automated and manual drop rates of a server over a time window.
+type BiasReport struct {
+	Server      string `json:"server"`
+	StartTime   int64  `json:"start"`
+	EndTime     int64  `json:"end"`
+	GeneratedAt int64  `json:"generatedAt"`
+	// Divergences are the (stage, item) pairs whose automated drop rate significantly diverges from the manual one,
+	// sorted by the absolute z-score in descending order.
+	Divergences []*BiasDivergence `json:"divergences"`
+	// Sources groups the divergences by automated source name and recognizer version.
+	Sources []*BiasSourceSummary `json:"sources"`
+}
+
+type BiasDivergence struct {
+	StageID string `json:"stageId"`
+	ItemID  string `json:"itemId"`
+
+	Manual    *BiasSample `json:"manual"`
+	Automated *BiasSample `json:"automated"`
+	ZScore    float64     `json:"zScore"`
+
+	// Sources breaks the automated side down by source name and recognizer version, each compared against the manual side.
+	Sources []*BiasSourceSample `json:"sources"`
+}
+
+type BiasSample struct {
+	Times    int     `json:"times"`
+	Quantity int     `json:"quantity"`
+	Rate     float64 `json:"rate"`
+	StdDev   float64 `json:"stdDev"`
+}
+
+type BiasSourceSample struct {
+	SourceName string `json:"sourceName"`
+	Version    string `json:"version"`
+	BiasSample
+	ZScore      float64 `json:"zScore"`
+	Significant bool    `json:"significant"`
+}
+
+type BiasSourceSummary struct {
+	SourceName string `json:"sourceName"`
+	Version    string `json:"version"`
+	Times      int    `json:"times"`
+	// Divergences is the number of divergences in which this source is significantly biased by itself.
+	Divergences int `json:"divergences"`
+}
@@ -2,15 +2,19 @@ package cache
 
 import (
 	"context"
+	"path"
 	"sync"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/goccy/go-json"
 
 	"gopkg.in/guregu/null.v3"
 
+	"exusiai.dev/backend-next/internal/app/appconfig"
 	"exusiai.dev/backend-next/internal/model"
 	modelv2 "exusiai.dev/backend-next/internal/model/v2"
+	modelv3 "exusiai.dev/backend-next/internal/model/v3"
 	"exusiai.dev/backend-next/internal/pkg/cache"
 	"exusiai.dev/backend-next/internal/repo"
 )
@@ -44,7 +48,8 @@ var (
 	Activities     *cache.Singular[[]*model.Activity]
 	ShimActivities *cache.Singular[[]*modelv2.Activity]
 
-	ShimLatestPatternMatrixResults *cache.Set[modelv2.PatternMatrixQueryResult]
+	ShimLatestPatternMatrixResults         *cache.Set[modelv2.PatternMatrixQueryResult]
+	ShimMaxAccumulablePatternMatrixResults *cache.Set[modelv2.PatternMatrixQueryResult]
 
 	ShimSiteStats *cache.Set[modelv2.SiteStats]
 
@@ -61,6 +66,7 @@ var (
 	MaxAccumulableTimeRanges *cache.Set[map[int]map[int][]*model.TimeRange]
 
 	ShimSavedTrendResults *cache.Set[modelv2.TrendQueryResult]
+	ShimTrendResults      *cache.Set[modelv3.TrendQueryResult]
 
 	Zones           *cache.Singular[[]*model.Zone]
 	ZoneByArkID     *cache.Set[model.Zone]
@@ -79,9 +85,17 @@ var (
 	SingularFlusherMap map[string]Flusher
 )
 
-func Initialize(propertyRepo *repo.Property) {
+func Initialize(conf *appconfig.Config, redisClient *redis.Client, propertyRepo *repo.Property) {
 	once.Do(func() {
-		initializeCaches()
+		if conf.CacheL2Enabled {
+			cache.ConfigureL2(cache.L2Config{
+				Client:     redisClient,
+				Timeout:    conf.CacheL2Timeout,
+				DefaultTTL: conf.CacheL2DefaultTTL,
+				TTLs:       conf.CacheL2TTLs,
+			})
+		}
+		initializeCaches(conf)
 		populateProperties(propertyRepo)
 	})
 }
@@ -107,7 +121,36 @@ func Delete(name string, key null.String) error {
 	return nil
 }
 
-func initializeCaches() {
+// Evict evicts the entries whose cache name and key match the glob patterns, and returns the number of entries evicted.
+func Evict(namePattern string, keyPattern string) (int, error) {
+	evicted := 0
+	for _, c := range cache.Caches() {
+		matched, err := path.Match(namePattern, c.Name())
+		if err != nil {
+			return evicted, err
+		}
+		if !matched {
+			continue
+		}
+		n, err := c.Evict(keyPattern)
+		evicted += n
+		if err != nil {
+			return evicted, err
+		}
+	}
+	return evicted, nil
+}
+
+// LastModified returns the last modified time recorded for the entry, if any.
+func LastModified(name string, key string) (time.Time, bool) {
+	var t time.Time
+	if err := LastModifiedTime.Get("["+name+":"+key+"]", &t); err != nil {
+		return t, false
+	}
+	return t, true
+}
+
+func initializeCaches(conf *appconfig.Config) {
 	SetMap = make(map[string]Flusher)
 	SingularFlusherMap = make(map[string]Flusher)
 
@@ -126,7 +169,7 @@ func initializeCaches() {
 	SetMap["itemDropSet#server|stageId|startTime|endTime"] = ItemDropSetByStageIdAndTimeRange.Flush
 
 	// drop_matrix
-	ShimMaxAccumulableDropMatrixResults = cache.NewSet[modelv2.DropMatrixQueryResult]("shimMaxAccumulableDropMatrixResults#server|showClosedZoned|sourceCategory")
+	ShimMaxAccumulableDropMatrixResults = cache.NewSet[modelv2.DropMatrixQueryResult]("shimMaxAccumulableDropMatrixResults#server|showClosedZoned|sourceCategory").WithStaleWhileRevalidate(conf.CacheStaleWhileRevalidate)
 
 	SetMap["shimMaxAccumulableDropMatrixResults#server|showClosedZoned|sourceCategory"] = ShimMaxAccumulableDropMatrixResults.Flush
 
@@ -170,12 +213,14 @@ func initializeCaches() {
 	SingularFlusherMap["shimActivities"] = ShimActivities.Delete
 
 	// pattern_matrix
-	ShimLatestPatternMatrixResults = cache.NewSet[modelv2.PatternMatrixQueryResult]("shimLatestPatternMatrixResults#server|sourceCategory")
+	ShimLatestPatternMatrixResults = cache.NewSet[modelv2.PatternMatrixQueryResult]("shimLatestPatternMatrixResults#server|sourceCategory").WithStaleWhileRevalidate(conf.CacheStaleWhileRevalidate)
+	ShimMaxAccumulablePatternMatrixResults = cache.NewSet[modelv2.PatternMatrixQueryResult]("shimMaxAccumulablePatternMatrixResults#server|sourceCategory").WithStaleWhileRevalidate(conf.CacheStaleWhileRevalidate)
 
 	SetMap["shimLatestPatternMatrixResults#server|sourceCategory"] = ShimLatestPatternMatrixResults.Flush
+	SetMap["shimMaxAccumulablePatternMatrixResults#server|sourceCategory"] = ShimMaxAccumulablePatternMatrixResults.Flush
 
 	// site_stats
-	ShimSiteStats = cache.NewSet[modelv2.SiteStats]("shimSiteStats#server")
+	ShimSiteStats = cache.NewSet[modelv2.SiteStats]("shimSiteStats#server").WithStaleWhileRevalidate(conf.CacheStaleWhileRevalidate)
 
 	SetMap["shimSiteStats#server"] = ShimSiteStats.Flush
 
@@ -206,9 +251,11 @@ func initializeCaches() {
 	SetMap["maxAccumulableTimeRanges#server"] = MaxAccumulableTimeRanges.Flush
 
 	// trend
-	ShimSavedTrendResults = cache.NewSet[modelv2.TrendQueryResult]("shimSavedTrendResults#server")
+	ShimSavedTrendResults = cache.NewSet[modelv2.TrendQueryResult]("shimSavedTrendResults#server").WithStaleWhileRevalidate(conf.CacheStaleWhileRevalidate)
+	ShimTrendResults = cache.NewSet[modelv3.TrendQueryResult]("shimTrendResults#server|granularity|sourceCategory").WithStaleWhileRevalidate(conf.CacheStaleWhileRevalidate)
 
 	SetMap["shimSavedTrendResults#server"] = ShimSavedTrendResults.Flush
+	SetMap["shimTrendResults#server|granularity|sourceCategory"] = ShimTrendResults.Flush
 
 	// zone
 	Zones = cache.NewSingular[[]*model.Zone]("zones")
@@ -114,3 +114,22 @@ type ItemTrend struct {
 	MinGroupID int        `json:"-"`
 	MaxGroupID int        `json:"-"`
 }
+
+// Bias Detection
+type TotalTimesResultBySource struct {
+	StageID    int    `json:"stageId" bun:"stage_id"`
+	SourceName string `json:"sourceName" bun:"source_name"`
+	Version    string `json:"version" bun:"version"`
+	TotalTimes int    `json:"totalTimes" bun:"total_times"`
+}
+
+type TotalQuantityResultBySource struct {
+	StageID    int    `json:"stageId" bun:"stage_id"`
+	ItemID     int    `json:"itemId" bun:"item_id"`
+	SourceName string `json:"sourceName" bun:"source_name"`
+	Version    string `json:"version" bun:"version"`
+	// TotalQuantity and TotalSquaredQuantity are the first and second moments of the per-report quantity,
+	// which are used to estimate the variance of the drop rate.
+	TotalQuantity        int `json:"totalQuantity" bun:"total_quantity"`
+	TotalSquaredQuantity int `json:"totalSquaredQuantity" bun:"total_squared_quantity"`
+}
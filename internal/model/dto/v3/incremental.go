@@ -3,3 +3,19 @@ package dtov3
 type GetLatestIncrementalVersionResponse struct {
 	Version string `json:"version"`
 }
+
+const IncrementalPlanChain = "chain"
+
+// IncrementalPlanResponse tells how to reach a version when there is no precomputed diff from the requested version.
+type IncrementalPlanResponse struct {
+	// Plan is "chain", to apply the diffs of Steps in order.
+	Plan    string                 `json:"plan"`
+	Version string                 `json:"version"`
+	Steps   []*IncrementalPlanStep `json:"steps"`
+}
+
+type IncrementalPlanStep struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	URL  string `json:"url"`
+}
@@ -0,0 +1,24 @@
+package dtov3
+
+// LiveMatrixUpdate is the data of a matrix event of /v3/live/events, carrying the quantities and times accepted
+// since the previous event for the stages and items subscribed to.
+type LiveMatrixUpdate struct {
+	Elements []*LiveMatrixElement `json:"elements"`
+}
+
+type LiveMatrixElement struct {
+	Server  string `json:"server"`
+	StageID string `json:"stageId"`
+	// ItemID is empty for the element carrying the times of every report of the stage, regardless of its drops.
+	ItemID   string `json:"itemId,omitempty"`
+	Quantity uint64 `json:"quantity"`
+	Times    uint64 `json:"times"`
+}
+
+// LiveMatrixResponse is the live matrix of a server, i.e. its matrix as of the latest generation calculated plus
+// the reports accepted since.
+type LiveMatrixResponse struct {
+	Server     string               `json:"server"`
+	Generation uint64               `json:"generation"`
+	Matrix     []*LiveMatrixElement `json:"matrix"`
+}
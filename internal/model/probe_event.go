@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// ProbeEvent is a frontend telemetry event. IPs are not stored; only the country they are located in is.
+type ProbeEvent struct {
+	bun.BaseModel `bun:"probe_events,alias:pe"`
+
+	EventID int64 `bun:",pk,autoincrement" json:"id"`
+	// Type is the name of the PROBE_* MessageType of the event.
+	Type string `bun:"type" json:"type"`
+	// Language is the name of the Language of the frontend.
+	Language string `bun:"language" json:"language"`
+	// Country is the ISO 3166-1 alpha-2 code of the country of the client, or empty if unknown.
+	Country   string    `bun:"country" json:"country"`
+	Server    string    `bun:"server" json:"server"`
+	Path      string    `bun:"path" json:"path"`
+	StageID   string    `bun:"stage_id" json:"stageId"`
+	ItemID    string    `bun:"item_id" json:"itemId"`
+	Query     string    `bun:"query" json:"query"`
+	Shape     string    `bun:"shape" json:"shape"`
+	CreatedAt time.Time `bun:"created_at" json:"createdAt"`
+}
+
+type ProbeCount struct {
+	Key   string `bun:"key" json:"key"`
+	Count int    `bun:"count" json:"count"`
+}
+
+// ProbeAggregates summarizes the probe events within a time window.
+type ProbeAggregates struct {
+	StartTime int64 `json:"start"`
+	EndTime   int64 `json:"end"`
+	// Stages and Items are the stages and items most navigated to or searched for.
+	Stages []*ProbeCount `json:"stages"`
+	Items  []*ProbeCount `json:"items"`
+	// SearchTerms are the terms whose search results are most entered.
+	SearchTerms []*ProbeCount `json:"searchTerms"`
+	// QueryShapes are the most executed shapes of advanced queries.
+	QueryShapes []*ProbeCount `json:"queryShapes"`
+}
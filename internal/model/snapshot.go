@@ -15,3 +15,14 @@ type Snapshot struct {
 	Version    string     `bun:"version" json:"version"`
 	Content    string     `bun:"content" json:"content"`
 }
+
+// SnapshotManifest describes the latest snapshot of a realm, for clients to verify the content they have
+// reconstructed by applying diffs.
+type SnapshotManifest struct {
+	Key     string `json:"key"`
+	Version string `json:"version"`
+	// SHA256 is the hex-encoded SHA-256 hash of the content.
+	SHA256    string    `json:"sha256"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -6,6 +6,12 @@ import (
 	"github.com/uptrace/bun"
 )
 
+const (
+	TrendGranularityHour = "1h"
+	TrendGranularityDay  = "1d"
+	TrendGranularityWeek = "1w"
+)
+
 type TrendElement struct {
 	bun.BaseModel `bun:"trend_elements,alias:te"`
 
@@ -19,4 +25,8 @@ type TrendElement struct {
 	Times          int        `json:"times"`
 	Server         string     `json:"server"`
 	SourceCategory string     `json:"sourceCategory"` // sourceCategory can be: "automated", "manual", "all"
+	Granularity    string     `json:"granularity"`    // granularity can be: "1h", "1d", "1w"
+	// Backfilled elements are calculated for historical time windows by the backfill script, and are kept
+	// when the trend worker replaces the elements it calculates.
+	Backfilled bool `bun:",notnull" json:"backfilled"`
 }
@@ -0,0 +1,30 @@
+package types
+
+// MergeAccountRequest merges the account of SourcePenguinID into the account of TargetPenguinID.
+type MergeAccountRequest struct {
+	SourcePenguinID string `json:"sourcePenguinId" validate:"required,max=32"`
+	TargetPenguinID string `json:"targetPenguinId" validate:"required,max=32"`
+}
+
+// MergeIntoAccountRequest merges the account of SourcePenguinID into the account of the requester.
+type MergeIntoAccountRequest struct {
+	SourcePenguinID string `json:"sourcePenguinId" validate:"required,max=32"`
+}
+
+// SessionLoginRequest exchanges a PenguinID for the tokens of a session granted Scopes, or all scopes if empty.
+type SessionLoginRequest struct {
+	PenguinID string   `json:"penguinId" validate:"required,max=32"`
+	Scopes    []string `json:"scopes" validate:"max=8,dive,max=32"`
+}
+
+// SessionRefreshRequest exchanges a refresh token for new tokens, or revokes its session.
+type SessionRefreshRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required,max=128"`
+}
+
+// ModerateAccountRequest sets the status and/or the trust score of an account, for Reason.
+type ModerateAccountRequest struct {
+	Status     *string `json:"status" validate:"omitempty,oneof=active banned shadowbanned"`
+	TrustScore *int    `json:"trustScore" validate:"omitempty,min=0,max=100"`
+	Reason     string  `json:"reason" validate:"required,max=512"`
+}
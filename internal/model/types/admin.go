@@ -38,6 +38,13 @@ type PurgeCachePair struct {
 	Key  null.String `json:"key" swaggertype:"string"`
 }
 
+// CacheEvictRequest evicts the entries whose cache name and key match the glob patterns, e.g.
+// `{"name": "shim*", "key": "CN|*"}`.
+type CacheEvictRequest struct {
+	Name string `json:"name" validate:"required,max=128"`
+	Key  string `json:"key" validate:"required,max=256"`
+}
+
 type RejectRulesReevaluationPreviewRequest struct {
 	RuleID          int `json:"ruleId"`
 	ReevaluateRange struct {
@@ -0,0 +1,18 @@
+package types
+
+type PatternMatrixQuery struct {
+	// Accumulable combines results over the max-accumulable time ranges of every stage, instead of only its latest time range.
+	Accumulable bool `query:"accumulable"`
+	// StageFilter is a comma separated list of stage IDs to filter. Unknown stage IDs are rejected.
+	StageFilter string `query:"stageFilter" validate:"max=4096"`
+	// StartTime excludes time ranges starting before it, in milliseconds.
+	StartTime int64 `query:"start" validate:"min=0"`
+	// EndTime excludes time ranges ending after it, in milliseconds. Time ranges still open are kept if they start before it.
+	EndTime int64 `query:"end" validate:"min=0"`
+	// MinTimes excludes stages whose total times (sample count) is less than it.
+	MinTimes int `query:"min_times" validate:"min=0"`
+}
+
+func (q *PatternMatrixQuery) HasFilters() bool {
+	return q.StageFilter != "" || q.StartTime > 0 || q.EndTime > 0 || q.MinTimes > 0
+}
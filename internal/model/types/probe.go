@@ -0,0 +1,33 @@
+package types
+
+// ProbeEvent is a frontend telemetry event, either sent as a text message of the /v3/live socket or in a batch
+// to the beacon endpoint.
+type ProbeEvent struct {
+	// Type is the number of the PROBE_* MessageType of the event.
+	Type int32 `json:"type" validate:"oneof=1 2 3"`
+	// Language is the name of the Language of the frontend. Defaults to the one of the Accept-Language header.
+	Language string `json:"language" validate:"omitempty,oneof=ZH_CN EN_US JA_JP KO_KR OTHER"`
+	Server   string `json:"server" validate:"omitempty,arkserver"`
+	// Path is the route navigated to, for PROBE_NAVIGATED.
+	Path    string `json:"path" validate:"lte=256"`
+	StageID string `json:"stageId" validate:"lte=128"`
+	ItemID  string `json:"itemId" validate:"lte=128"`
+	// Query is the search term whose result is entered, for PROBE_ENTERED_SEARCH_RESULT.
+	Query string `json:"query" validate:"lte=128"`
+	// Shape is the shape of the advanced query executed, for PROBE_EXECUTED_ADVANCED_QUERY.
+	Shape *ProbeQueryShape `json:"shape" validate:"omitempty"`
+}
+
+// ProbeQueryShape describes an advanced query without its exact stages, items and time ranges.
+type ProbeQueryShape struct {
+	Queries        int    `json:"queries" validate:"min=0,max=5"`
+	Items          int    `json:"items" validate:"min=0"`
+	Personal       bool   `json:"personal"`
+	SourceCategory string `json:"sourceCategory" validate:"omitempty,sourcecategory"`
+	TimeRange      bool   `json:"timeRange"`
+	Interval       bool   `json:"interval"`
+}
+
+type ProbeBeaconRequest struct {
+	Events []*ProbeEvent `json:"events" validate:"required,min=1,max=50,dive"`
+}
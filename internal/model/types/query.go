@@ -0,0 +1,29 @@
+package types
+
+import "gopkg.in/guregu/null.v3"
+
+const (
+	QueryResultMatrix  = "matrix"
+	QueryResultPattern = "pattern"
+	QueryResultTrend   = "trend"
+)
+
+type ArbitraryQueryRequest struct {
+	Server string `json:"server" validate:"required,arkserver" required:"true"`
+	// StageIDs filters the stages to query. Leave empty to query every stage that has drops within the time window.
+	StageIDs []string `json:"stageIds" validate:"max=500,dive,printascii"`
+	// ItemIDs filters the items to query. Pattern results are always calculated over all items of a stage.
+	ItemIDs        []string  `json:"itemIds" validate:"max=500,dive,printascii"`
+	IsPersonal     null.Bool `json:"isPersonal" swaggertype:"boolean"`
+	SourceCategory string    `json:"sourceCategory" validate:"sourcecategory"`
+	StartTime      int64     `json:"start" validate:"required,min=0" required:"true"`
+	EndTime        null.Int  `json:"end" swaggertype:"integer"`
+	// Interval is the trend interval length in milliseconds. Defaults to one day when trend results are requested.
+	Interval null.Int `json:"interval" swaggertype:"integer"`
+	// Include selects the result kinds to return. Defaults to all of matrix, pattern and trend.
+	Include []string `json:"include" validate:"max=3,dive,oneof=matrix pattern trend"`
+	// Cursor is the opaque nextCursor value returned by the previous page.
+	Cursor string `json:"cursor" validate:"max=256"`
+	// Limit is the maximum number of stages returned in one page.
+	Limit int `json:"limit" validate:"omitempty,min=1,max=100"`
+}
@@ -0,0 +1,9 @@
+package types
+
+type TrendQuery struct {
+	// Interval is the granularity of the trend. Defaults to 1d.
+	Interval       string `query:"interval" validate:"omitempty,oneof=1h 1d 1w"`
+	StageID        string `query:"stageId" validate:"max=128"`
+	ItemID         string `query:"itemId" validate:"max=128"`
+	SourceCategory string `query:"sourceCategory" validate:"sourcecategory"`
+}
@@ -0,0 +1,9 @@
+package v3
+
+import "exusiai.dev/backend-next/internal/model"
+
+// AccountExportReport is a drop report in the archive of an export job, along with its extra.
+type AccountExportReport struct {
+	*model.DropReport
+	Extra *model.DropReportExtra `json:"extra,omitempty"`
+}
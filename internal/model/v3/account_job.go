@@ -0,0 +1,31 @@
+package v3
+
+import "gopkg.in/guregu/null.v3"
+
+const (
+	AccountJobKindExport   = "export"
+	AccountJobKindDeletion = "deletion"
+)
+
+// AccountJob is an asynchronous job exporting or deleting the data of an account. Its status values are the
+// same as the ones of QueryJob.
+type AccountJob struct {
+	JobID      string                 `json:"jobId"`
+	Kind       string                 `json:"kind"`
+	Status     string                 `json:"status"`
+	CreatedAt  int64                  `json:"createdAt"`
+	StartedAt  null.Int               `json:"startedAt" swaggertype:"integer" extensions:"x-nullable"`
+	FinishedAt null.Int               `json:"finishedAt" swaggertype:"integer" extensions:"x-nullable"`
+	Error      null.String            `json:"error,omitempty" swaggertype:"string"`
+	Deletion   *AccountDeletionResult `json:"deletion,omitempty"`
+}
+
+// AccountDeletionResult describes what a deletion job has done to the data of the account.
+type AccountDeletionResult struct {
+	// Policy is the deletion policy the job has followed, either "anonymize" or "delete".
+	Policy  string `json:"policy"`
+	Reports int    `json:"reports"`
+	Defects int    `json:"defects"`
+	// PurgedIPs is the number of report extras whose IPs have been purged.
+	PurgedIPs int `json:"purgedIps"`
+}
@@ -0,0 +1,21 @@
+package v3
+
+import "gopkg.in/guregu/null.v3"
+
+const (
+	QueryJobStatusQueued    = "queued"
+	QueryJobStatusRunning   = "running"
+	QueryJobStatusSucceeded = "succeeded"
+	QueryJobStatusFailed    = "failed"
+)
+
+type QueryJob struct {
+	JobID         string                `json:"jobId"`
+	Status        string                `json:"status"`
+	EstimatedCost int                   `json:"estimatedCost"`
+	CreatedAt     int64                 `json:"createdAt"`
+	StartedAt     null.Int              `json:"startedAt" swaggertype:"integer" extensions:"x-nullable"`
+	FinishedAt    null.Int              `json:"finishedAt" swaggertype:"integer" extensions:"x-nullable"`
+	Error         null.String           `json:"error,omitempty" swaggertype:"string"`
+	Result        *ArbitraryQueryResult `json:"result,omitempty"`
+}
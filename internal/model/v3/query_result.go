@@ -1,6 +1,10 @@
 package v3
 
-import "gopkg.in/guregu/null.v3"
+import (
+	"gopkg.in/guregu/null.v3"
+
+	modelv2 "exusiai.dev/backend-next/internal/model/v2"
+)
 
 // DropPattern
 type PatternMatrixQueryResult struct {
@@ -25,3 +29,18 @@ type OneDrop struct {
 	ItemID   string `json:"itemId" example:"30012"`
 	Quantity int    `json:"quantity" example:"1"`
 }
+
+// ArbitraryQuery
+type ArbitraryQueryResult struct {
+	Matrix   []*modelv2.OneDropMatrixElement `json:"matrix,omitempty"`
+	Patterns []*OnePatternMatrixElement      `json:"patterns,omitempty"`
+	Trends   map[string]*modelv2.StageTrend  `json:"trends,omitempty"`
+	// NextCursor is present when there are more stages to fetch; pass it back as the cursor of the next request.
+	NextCursor null.String `json:"nextCursor" swaggertype:"string" extensions:"x-nullable"`
+}
+
+// Trend
+type TrendQueryResult struct {
+	Granularity string                         `json:"granularity"`
+	Trend       map[string]*modelv2.StageTrend `json:"trend"`
+}
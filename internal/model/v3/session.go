@@ -0,0 +1,12 @@
+package v3
+
+// SessionTokens are the tokens of a session issued in exchange for a PenguinID.
+type SessionTokens struct {
+	// AccessToken is presented as `Authorization: Bearer <accessToken>` in place of the PenguinID.
+	AccessToken string `json:"accessToken"`
+	// RefreshToken is exchanged for new tokens once the access token expires. It is rotated by every refresh.
+	RefreshToken string   `json:"refreshToken"`
+	TokenType    string   `json:"tokenType"`
+	ExpiresIn    int      `json:"expiresIn"`
+	Scopes       []string `json:"scopes"`
+}
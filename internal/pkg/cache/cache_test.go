@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupL2(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	ConfigureL2(L2Config{
+		Client:     redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Timeout:    time.Second,
+		DefaultTTL: time.Hour,
+	})
+	t.Cleanup(func() {
+		l2.Store(nil)
+	})
+	return mr
+}
+
+// expiresIn returns the duration until the in-memory entry of the full key expires.
+func expiresIn[T any](t *testing.T, c *Set[T], key string) time.Duration {
+	t.Helper()
+
+	item, ok := c.c.Items()[key]
+	require.True(t, ok, "entry %s not found in memory", key)
+	return time.Until(time.Unix(0, item.Expiration))
+}
+
+func TestSetL2Hydration(t *testing.T) {
+	mr := setupL2(t)
+	c := NewSet[string]("test-l2-hydration")
+
+	c.Set("key", "value", time.Hour)
+	// another instance has written the entry to L2 with a shorter remaining lifetime
+	mr.SetTTL(L2RedisPrefix+c.key("key"), time.Second*10)
+	c.c.Delete(c.key("key"))
+
+	var value string
+	require.NoError(t, c.Get("key", &value))
+	assert.Equal(t, "value", value)
+	assert.InDelta(t, float64(time.Second*10), float64(expiresIn(t, c, c.key("key"))), float64(time.Second))
+
+	// the entry is gone once the L2 entry has expired
+	mr.FastForward(time.Second * 11)
+	c.c.Delete(c.key("key"))
+	assert.ErrorIs(t, c.Get("key", &value), ErrNotFound)
+}
+
+func TestSingularL2Hydration(t *testing.T) {
+	mr := setupL2(t)
+	c := NewSingular[string]("test-singular-l2-hydration")
+
+	c.Set("value", time.Hour)
+	mr.SetTTL(L2RedisPrefix+c.key, time.Second*10)
+	c.c.Flush()
+
+	var value string
+	require.NoError(t, c.Get(&value))
+	assert.Equal(t, "value", value)
+	item, ok := c.c.Items()[c.key]
+	require.True(t, ok)
+	assert.InDelta(t, float64(time.Second*10), float64(time.Until(time.Unix(0, item.Expiration))), float64(time.Second))
+}
+
+func TestSetStaleWhileRevalidate(t *testing.T) {
+	c := NewSet[string]("test-swr").WithStaleWhileRevalidate(time.Hour)
+
+	c.Set("key", "stale", time.Hour)
+	c.swrMeta[c.key("key")].freshUntil = time.Now().Add(-time.Second)
+
+	var value string
+	assert.ErrorIs(t, c.Get("key", &value), ErrNotFound, "Get should not serve stale entries")
+	assert.False(t, c.Fresh("key"))
+
+	revalidated := make(chan struct{})
+	valueFunc := func() (*string, error) {
+		defer close(revalidated)
+		v := "fresh"
+		return &v, nil
+	}
+	calculated, err := c.MutexGetSet("key", &value, valueFunc, time.Hour)
+	require.NoError(t, err)
+	assert.False(t, calculated)
+	assert.Equal(t, "stale", value, "MutexGetSet should serve stale entries while revalidating")
+
+	<-revalidated
+	assert.Eventually(t, func() bool {
+		c.swrm.Lock()
+		defer c.swrm.Unlock()
+		return c.swrMeta[c.key("key")].revalidated
+	}, time.Second, time.Millisecond*10)
+
+	calculated, err = c.MutexGetSet("key", &value, valueFunc, time.Hour)
+	require.NoError(t, err)
+	assert.True(t, calculated, "the first call after revalidation should report the value as calculated")
+	assert.Equal(t, "fresh", value)
+
+	calculated, err = c.MutexGetSet("key", &value, valueFunc, time.Hour)
+	require.NoError(t, err)
+	assert.False(t, calculated)
+	assert.True(t, c.Fresh("key"))
+}
+
+func TestSetStaleWhileRevalidateL2Hydration(t *testing.T) {
+	mr := setupL2(t)
+	c := NewSet[string]("test-swr-l2-hydration").WithStaleWhileRevalidate(time.Hour)
+
+	c.Set("key", "value", time.Hour)
+	mr.SetTTL(L2RedisPrefix+c.key("key"), time.Second*10)
+	c.c.Delete(c.key("key"))
+
+	var value string
+	require.NoError(t, c.Get("key", &value))
+
+	c.swrm.Lock()
+	meta, ok := c.swrMeta[c.key("key")]
+	c.swrm.Unlock()
+	require.True(t, ok, "hydrated entries should have their freshness recorded")
+	assert.InDelta(t, float64(time.Second*10), float64(time.Until(meta.freshUntil)), float64(time.Second))
+	assert.InDelta(t, float64(time.Hour+time.Second*10), float64(expiresIn(t, c, c.key("key"))), float64(time.Second))
+}
+
+func TestSetStaleWhileRevalidateForgetsExpiredEntries(t *testing.T) {
+	c := NewSet[string]("test-swr-expiry").WithStaleWhileRevalidate(time.Millisecond)
+
+	c.Set("key", "value", time.Millisecond)
+	time.Sleep(time.Millisecond * 10)
+	c.c.DeleteExpired()
+
+	c.swrm.Lock()
+	defer c.swrm.Unlock()
+	assert.Empty(t, c.swrMeta)
+}
+
+func TestSingularEvict(t *testing.T) {
+	c := NewSingular[string]("test-singular-evict")
+
+	tests := []struct {
+		pattern string
+		evicted int
+	}{
+		{"CN|*", 0},
+		{"test-singular-*", 1},
+		{"*", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			c.Set("value", time.Hour)
+			evicted, err := c.Evict(tt.pattern)
+			require.NoError(t, err)
+			assert.Equal(t, tt.evicted, evicted)
+		})
+	}
+}
+
+func TestSetEvictL2(t *testing.T) {
+	mr := setupL2(t)
+	c := NewSet[string]("test-set-evict-l2")
+
+	c.Set("CN|main", "value", time.Hour)
+	c.Set("CN|recruit", "value", time.Hour)
+	c.Set("US|main", "value", time.Hour)
+	// entries only in L2, as if written by other instances or evicted from memory here
+	c.c.Delete(c.key("CN|recruit"))
+	c.c.Delete(c.key("US|main"))
+
+	evicted, err := c.Evict("CN|*")
+	require.NoError(t, err)
+	assert.Equal(t, 2, evicted)
+	assert.False(t, mr.Exists(L2RedisPrefix+c.key("CN|main")))
+	assert.False(t, mr.Exists(L2RedisPrefix+c.key("CN|recruit")))
+
+	var value string
+	assert.ErrorIs(t, c.Get("CN|recruit", &value), ErrNotFound)
+	require.NoError(t, c.Get("US|main", &value))
+	assert.Equal(t, "value", value)
+}
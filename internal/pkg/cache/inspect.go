@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Cache is the introspection interface implemented by both Set and Singular.
+type Cache interface {
+	// Name is the name of the cache, which is the prefix of a Set or the key of a Singular.
+	Name() string
+	// Inspect returns the metadata of every in-memory entry.
+	Inspect() map[string]*EntryInfo
+	// Evict deletes every entry whose key matches the glob pattern, and returns the number of entries deleted.
+	Evict(pattern string) (int, error)
+	// Fresh reports whether key exists in memory and is up to date, i.e. it is neither stale nor revalidated
+	// without a caller of MutexGetSet having been told. The key of a Singular is ignored.
+	Fresh(key string) bool
+}
+
+type entry[T any] struct {
+	value     T
+	createdAt time.Time
+}
+
+func newEntry[T any](value T) *entry[T] {
+	return &entry[T]{
+		value:     value,
+		createdAt: time.Now(),
+	}
+}
+
+// EntryInfo is the metadata of an in-memory cache entry.
+type EntryInfo struct {
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	// FreshUntil is the time the entry becomes stale, for sets with stale-while-revalidate enabled.
+	FreshUntil *time.Time `json:"freshUntil,omitempty"`
+
+	size func() int
+}
+
+func (e *entry[T]) info(expiration int64) *EntryInfo {
+	info := &EntryInfo{
+		CreatedAt: e.createdAt,
+		size: func() int {
+			b, err := json.Marshal(e.value)
+			if err != nil {
+				return 0
+			}
+			return len(b)
+		},
+	}
+	if expiration > 0 {
+		expiresAt := time.Unix(0, expiration)
+		info.ExpiresAt = &expiresAt
+	}
+	return info
+}
+
+// ApproximateSize returns the size of the entry in its JSON representation, which is calculated on every call.
+func (e *EntryInfo) ApproximateSize() int {
+	if e.size == nil {
+		return 0
+	}
+	return e.size()
+}
+
+var registry sync.Map
+
+func register(c Cache) {
+	registry.Store(c.Name(), c)
+}
+
+// Caches returns every cache created, sorted by name.
+func Caches() []Cache {
+	caches := make([]Cache, 0)
+	registry.Range(func(_, value any) bool {
+		caches = append(caches, value.(Cache))
+		return true
+	})
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].Name() < caches[j].Name()
+	})
+	return caches
+}
+
+// Find returns the cache with the given name.
+func Find(name string) (Cache, bool) {
+	c, ok := registry.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return c.(Cache), true
+}
+
+// matchPattern reports whether key matches the glob pattern, as in path.Match.
+func matchPattern(pattern, key string) (bool, error) {
+	return path.Match(pattern, key)
+}
@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// L2RedisPrefix is the prefix of every L2 entry in Redis.
+const L2RedisPrefix = "cache:"
+
+// l2OutageBackoff is the duration the L2 tier is bypassed for after an error, so that an L2 outage
+// does not add the Redis timeout to every cache miss.
+const l2OutageBackoff = time.Second * 10
+
+type L2Config struct {
+	Client *redis.Client
+	// Timeout is the timeout of every L2 operation.
+	Timeout time.Duration
+	// DefaultTTL is the TTL of entries of caches without a TTL in TTLs.
+	DefaultTTL time.Duration
+	// TTLs are the per-cache TTLs, keyed by the name of a Set or the key of a Singular.
+	// A zero TTL disables the L2 tier for that cache.
+	TTLs map[string]time.Duration
+}
+
+// l2Tier is the optional second tier behind every Set and Singular, shared among instances via Redis.
+// Values are serialized with msgpack. Every error is logged and treated as a cache miss, so that
+// an L2 outage falls back to the in-memory tier only.
+type l2Tier struct {
+	L2Config
+
+	// downUntil is the unix nano time until which the L2 tier is bypassed
+	downUntil atomic.Int64
+}
+
+var l2 atomic.Pointer[l2Tier]
+
+// ConfigureL2 enables the L2 tier for every Set and Singular. It should be called before the caches are used.
+func ConfigureL2(conf L2Config) {
+	l2.Store(&l2Tier{L2Config: conf})
+}
+
+// getL2 returns the L2 tier, or nil if it is not configured or is currently bypassed due to an outage.
+func getL2() *l2Tier {
+	t := l2.Load()
+	if t == nil || time.Now().UnixNano() < t.downUntil.Load() {
+		return nil
+	}
+	return t
+}
+
+// enabled returns whether the L2 tier is enabled for the cache.
+func (t *l2Tier) enabled(name string) bool {
+	return t.ttl(name, 0) > 0
+}
+
+// ttl returns the L2 TTL of an entry of the cache, capped by the in-memory expiration of the entry.
+func (t *l2Tier) ttl(name string, expire time.Duration) time.Duration {
+	ttl, ok := t.TTLs[name]
+	if !ok {
+		ttl = t.DefaultTTL
+	}
+	if expire > 0 && expire < ttl {
+		ttl = expire
+	}
+	return ttl
+}
+
+func (t *l2Tier) fail(op string, key string, err error) {
+	if t.downUntil.Swap(time.Now().Add(l2OutageBackoff).UnixNano()) < time.Now().UnixNano() {
+		log.Warn().
+			Str("evt.name", "cache.l2.unavailable").
+			Str("op", op).
+			Str("key", key).
+			Err(err).
+			Dur("backoff", l2OutageBackoff).
+			Msg("L2 cache operation failed. bypassing L2 cache for a while")
+	}
+}
+
+// get reads the L2 entry of key into dest, and returns the remaining TTL of the entry, so that entries hydrated
+// into memory expire along with it.
+func (t *l2Tier) get(key string, dest any) (time.Duration, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+	defer cancel()
+
+	var getCmd *redis.StringCmd
+	var ttlCmd *redis.DurationCmd
+	_, err := t.Client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		getCmd = p.Get(ctx, L2RedisPrefix+key)
+		ttlCmd = p.PTTL(ctx, L2RedisPrefix+key)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return 0, false
+	} else if err != nil {
+		t.fail("get", key, err)
+		return 0, false
+	}
+	// PTTL is -1 if the entry has no TTL, which the L2 tier never sets, and is -2 or rounded down to 0 if the entry
+	// has expired since GET or is about to
+	ttl := ttlCmd.Val()
+	if ttl == -1 {
+		ttl = t.DefaultTTL
+	} else if ttl <= 0 {
+		return 0, false
+	}
+	b, err := getCmd.Bytes()
+	if err != nil {
+		return 0, false
+	}
+	if err := msgpack.Unmarshal(b, dest); err != nil {
+		log.Error().Err(err).Str("key", key).Msg("failed to unmarshal L2 cache entry")
+		return 0, false
+	}
+	return ttl, true
+}
+
+func (t *l2Tier) set(key string, value any, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	b, err := msgpack.Marshal(value)
+	if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("failed to marshal L2 cache entry")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+	defer cancel()
+	if err := t.Client.Set(ctx, L2RedisPrefix+key, b, ttl).Err(); err != nil {
+		t.fail("set", key, err)
+	}
+}
+
+func (t *l2Tier) delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+	defer cancel()
+	return t.Client.Del(ctx, L2RedisPrefix+key).Err()
+}
+
+// flush deletes every L2 entry whose key starts with prefix.
+func (t *l2Tier) flush(prefix string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout*10)
+	defer cancel()
+
+	keys, err := t.scan(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return t.Client.Del(ctx, keys...).Err()
+}
+
+// keys returns the keys of every L2 entry whose key starts with prefix, without L2RedisPrefix.
+func (t *l2Tier) keys(prefix string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout*10)
+	defer cancel()
+
+	keys, err := t.scan(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, L2RedisPrefix)
+	}
+	return keys, nil
+}
+
+func (t *l2Tier) scan(ctx context.Context, prefix string) ([]string, error) {
+	iter := t.Client.Scan(ctx, 0, L2RedisPrefix+prefix+"*", 1000).Iterator()
+	keys := make([]string, 0)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
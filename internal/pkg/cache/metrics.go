@@ -0,0 +1,26 @@
+package cache
+
+import (
+	"exusiai.dev/backend-next/internal/pkg/observability"
+)
+
+const (
+	resultHit   = "hit"
+	resultL2Hit = "l2_hit"
+	resultMiss  = "miss"
+	resultStale = "stale"
+)
+
+func observeRequest(name string, result string) {
+	observability.CacheRequests.WithLabelValues(name, result).Inc()
+}
+
+func observeEviction(name string) {
+	observability.CacheEvictions.WithLabelValues(name).Inc()
+}
+
+func observeEvictions(name string, count int) {
+	if count > 0 {
+		observability.CacheEvictions.WithLabelValues(name).Add(float64(count))
+	}
+}
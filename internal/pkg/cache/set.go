@@ -1,70 +1,180 @@
 package cache
 
 import (
-	"reflect"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
 )
 
 func NewSet[T any](prefix string) *Set[T] {
-	return &Set[T]{
+	c := &Set[T]{
 		prefix: prefix + ":",
 		c:      cache.New(cache.NoExpiration, time.Minute*10),
 	}
+	c.c.OnEvicted(func(key string, _ any) {
+		observeEviction(prefix)
+		c.forgetSWRMeta(key)
+	})
+	register(c)
+	return c
 }
 
 type Set[T any] struct {
-	// m is a mutex for MutexGetSet for concurrent prevention
-	m sync.Mutex
+	// g deduplicates concurrent loads of the same key in MutexGetSet
+	g singleflight.Group
 
 	prefix string
 
 	c *cache.Cache
+
+	// swr is the stale-while-revalidate window. See WithStaleWhileRevalidate.
+	swr time.Duration
+	// swrm guards swrMeta
+	swrm sync.Mutex
+	// swrMeta holds the freshness of entries when swr is enabled, keyed by the full cache key
+	swrMeta map[string]*swrMeta
+}
+
+// swrRetryBackoff is the duration before a failed background recalculation is retried.
+const swrRetryBackoff = time.Minute
+
+type swrMeta struct {
+	freshUntil time.Time
+	// revalidated means the entry has been recalculated in the background, but no caller of
+	// MutexGetSet has been told yet that the value is calculated.
+	revalidated bool
+}
+
+// WithStaleWhileRevalidate enables stale-while-revalidate for MutexGetSet: entries are kept in memory for
+// window after they expire, during which MutexGetSet keeps serving the stale value and recalculates it in
+// the background. Only one background recalculation runs for a key at a time. Revalidated values are reported
+// as calculated to the first MutexGetSet caller afterwards, so that the last modified time can be recorded.
+func (c *Set[T]) WithStaleWhileRevalidate(window time.Duration) *Set[T] {
+	c.swr = window
+	c.swrMeta = make(map[string]*swrMeta)
+	return c
 }
 
 func (c *Set[T]) key(key string) string {
 	return c.prefix + key
 }
 
+func (c *Set[T]) Name() string {
+	return strings.TrimSuffix(c.prefix, ":")
+}
+
+// Get gets value from the in-memory tier, or from the L2 tier if the key does not exist in memory,
+// and writes to dest. Stale entries of a set with stale-while-revalidate enabled are only served by
+// MutexGetSet, which revalidates them, and are treated as missing by Get.
 func (c *Set[T]) Get(key string, dest *T) error {
+	if err := c.get(key, dest); err != nil {
+		return err
+	}
+	if c.stale(c.key(key)) {
+		observeRequest(c.Name(), resultStale)
+		return ErrNotFound
+	}
+	return nil
+}
+
+// get gets value from the in-memory tier regardless of its freshness, or from the L2 tier if the key does not
+// exist in memory, and writes to dest.
+func (c *Set[T]) get(key string, dest *T) error {
 	key = c.key(key)
 	result, ok := c.c.Get(key)
 	if !ok {
+		if t := getL2(); t != nil && t.enabled(c.Name()) {
+			var value T
+			if ttl, ok := t.get(key, &value); ok {
+				observeRequest(c.Name(), resultL2Hit)
+				c.hydrate(key, value, ttl)
+				*dest = value
+				return nil
+			}
+		}
+		observeRequest(c.Name(), resultMiss)
 		if l := log.Trace(); l.Enabled() {
 			l.Str("key", key).Msg("cache entry not found")
 		}
 		return ErrNotFound
 	}
 
-	// copy value to dest
-	var r reflect.Value
-	if reflect.ValueOf(result).Kind() == reflect.Ptr {
-		r = reflect.ValueOf(result).Elem()
-	} else {
-		r = reflect.ValueOf(result)
-	}
-	reflect.ValueOf(dest).Elem().Set(r)
+	observeRequest(c.Name(), resultHit)
+	*dest = result.(*entry[T]).value
 	return nil
 }
 
+// hydrate sets an entry read from the L2 tier to memory, expiring along with the L2 entry, whose remaining TTL
+// is ttl. Entries of a set with stale-while-revalidate enabled are fresh until then, as Set writes them to the
+// L2 tier for no longer than they are fresh.
+func (c *Set[T]) hydrate(key string, value T, ttl time.Duration) {
+	if c.swr > 0 {
+		c.c.Set(key, newEntry(value), ttl+c.swr)
+		c.swrm.Lock()
+		c.swrMeta[key] = &swrMeta{freshUntil: time.Now().Add(ttl)}
+		c.swrm.Unlock()
+		return
+	}
+	c.c.Set(key, newEntry(value), ttl)
+}
+
+// stale reports whether the entry of the full key has turned stale, for sets with stale-while-revalidate enabled.
+func (c *Set[T]) stale(key string) bool {
+	if c.swr <= 0 {
+		return false
+	}
+	c.swrm.Lock()
+	defer c.swrm.Unlock()
+	meta, ok := c.swrMeta[key]
+	return ok && time.Now().After(meta.freshUntil)
+}
+
+// forgetSWRMeta deletes the freshness of the entry of the full key once the entry no longer exists in memory,
+// as go-cache expires entries without going through Delete.
+func (c *Set[T]) forgetSWRMeta(key string) {
+	if c.swr <= 0 {
+		return
+	}
+	c.swrm.Lock()
+	defer c.swrm.Unlock()
+	// the entry might have been set again since it has been evicted
+	if _, ok := c.c.Get(key); !ok {
+		delete(c.swrMeta, key)
+	}
+}
+
 func (c *Set[T]) Set(key string, value T, expire time.Duration) {
 	key = c.key(key)
 	if l := log.Trace(); l.Enabled() {
 		l.Str("key", key).Msg("setting value to cache")
 	}
-	c.c.Set(key, value, expire)
+	if c.swr > 0 && expire > 0 {
+		c.c.Set(key, newEntry(value), expire+c.swr)
+		c.swrm.Lock()
+		c.swrMeta[key] = &swrMeta{freshUntil: time.Now().Add(expire)}
+		c.swrm.Unlock()
+	} else {
+		c.c.Set(key, newEntry(value), expire)
+	}
+	if t := getL2(); t != nil && t.enabled(c.Name()) {
+		t.set(key, value, t.ttl(c.Name(), expire))
+	}
 }
 
 // MutexGetSet gets value from cache and writes to dest, or if the key does not exist, it executes valueFunc
 // to get cache value if the key still not exists when serially dispatched, sets value to cache and
-// writes value to dest.
+// writes value to dest. Concurrent calls for the same key share a single execution of valueFunc.
 // The first return value means whether the value is got from cache or not. True means calculated; False means got from cache.
 func (c *Set[T]) MutexGetSet(key string, dest *T, valueFunc func() (*T, error), expire time.Duration) (bool, error) {
-	err := c.Get(key, dest)
+	err := c.get(key, dest)
 	if err == nil {
+		if c.swr > 0 {
+			return c.revalidate(key, valueFunc, expire), nil
+		}
 		return false, nil
 	}
 	// onwards, cache key does not exist
@@ -72,35 +182,84 @@ func (c *Set[T]) MutexGetSet(key string, dest *T, valueFunc func() (*T, error),
 	return true, c.slowMutexGetSet(key, dest, valueFunc, expire)
 }
 
+// revalidate starts a background recalculation if the entry is stale. It returns true if the entry has been
+// revalidated in the background since the last call.
+func (c *Set[T]) revalidate(key string, valueFunc func() (*T, error), expire time.Duration) bool {
+	fullKey := c.key(key)
+	c.swrm.Lock()
+	meta, ok := c.swrMeta[fullKey]
+	if !ok {
+		// entries set without an expiration have no freshness information and never turn stale
+		c.swrm.Unlock()
+		return false
+	}
+	if meta.revalidated {
+		meta.revalidated = false
+		c.swrm.Unlock()
+		return true
+	}
+	stale := time.Now().After(meta.freshUntil)
+	c.swrm.Unlock()
+	if !stale {
+		return false
+	}
+
+	observeRequest(c.Name(), resultStale)
+	go func() {
+		_, _, _ = c.g.Do("revalidate:"+key, func() (any, error) {
+			c.swrm.Lock()
+			meta, ok := c.swrMeta[fullKey]
+			stale := ok && time.Now().After(meta.freshUntil)
+			c.swrm.Unlock()
+			if !stale {
+				// revalidated by another goroutine already, or deleted in the meantime
+				return nil, nil
+			}
+
+			value, err := valueFunc()
+			if err != nil {
+				log.Error().Err(err).Str("key", fullKey).Msg("failed to revalidate stale cache entry. keep serving the stale value")
+				// back off so that a failing valueFunc is not retried on every call
+				c.swrm.Lock()
+				meta.freshUntil = time.Now().Add(swrRetryBackoff)
+				c.swrm.Unlock()
+				return nil, err
+			}
+			c.Set(key, *value, expire)
+
+			c.swrm.Lock()
+			if meta, ok := c.swrMeta[fullKey]; ok {
+				meta.revalidated = true
+			}
+			c.swrm.Unlock()
+			return nil, nil
+		})
+	}()
+	return false
+}
+
 func (c *Set[T]) slowMutexGetSet(key string, dest *T, valueFunc func() (*T, error), expire time.Duration) error {
-	c.m.Lock()
-	defer c.m.Unlock()
-	err := c.Get(key, dest)
+	v, err, _ := c.g.Do(key, func() (any, error) {
+		var cached T
+		if err := c.Get(key, &cached); err == nil {
+			return &cached, nil
+		}
 
-	if err == nil {
-		return nil
-	}
+		value, err := valueFunc()
+		if err != nil {
+			log.Error().Err(err).Str("key", key).Msg("failed to get value from valueFunc() in MutexGetSet")
+			return nil, err
+		}
 
-	value, err := valueFunc()
+		c.Set(key, *value, expire)
+		return value, nil
+	})
 	if err != nil {
-		log.Error().Err(err).Str("key", key).Msg("failed to get value from valueFunc() in MutexGetSet")
 		return err
 	}
 
-	c.Set(key, *value, expire)
-	// if err != nil {
-	// 	log.Error().Err(err).Str("key", key).Msg("failed to set value to cache in MutexGetSet")
-	// 	return err
-	// }
-
 	// copy value to dest
-	var r reflect.Value
-	if reflect.ValueOf(value).Kind() == reflect.Ptr {
-		r = reflect.ValueOf(value).Elem()
-	} else {
-		r = reflect.ValueOf(value)
-	}
-	reflect.ValueOf(dest).Elem().Set(r)
+	*dest = *v.(*T)
 
 	return nil
 }
@@ -111,11 +270,103 @@ func (c *Set[T]) Delete(key string) error {
 		l.Str("key", key).Msg("deleting value from cache")
 	}
 	c.c.Delete(key)
+	if c.swr > 0 {
+		c.swrm.Lock()
+		delete(c.swrMeta, key)
+		c.swrm.Unlock()
+	}
+	if t := l2.Load(); t != nil && t.enabled(c.Name()) {
+		if err := t.delete(key); err != nil {
+			log.Warn().Err(err).Str("key", key).Msg("failed to delete L2 cache entry")
+		}
+	}
 
 	return nil
 }
 
 func (c *Set[T]) Flush() error {
+	observeEvictions(c.Name(), c.c.ItemCount())
 	c.c.Flush()
+	if c.swr > 0 {
+		c.swrm.Lock()
+		c.swrMeta = make(map[string]*swrMeta)
+		c.swrm.Unlock()
+	}
+	if t := l2.Load(); t != nil && t.enabled(c.Name()) {
+		if err := t.flush(c.prefix); err != nil {
+			log.Warn().Err(err).Str("prefix", c.prefix).Msg("failed to flush L2 cache entries")
+		}
+	}
 	return nil
 }
+
+// Inspect returns the metadata of every in-memory entry, keyed by the key without the set prefix.
+func (c *Set[T]) Inspect() map[string]*EntryInfo {
+	infos := make(map[string]*EntryInfo)
+	for key, item := range c.c.Items() {
+		info := item.Object.(*entry[T]).info(item.Expiration)
+		if c.swr > 0 {
+			c.swrm.Lock()
+			if meta, ok := c.swrMeta[key]; ok {
+				freshUntil := meta.freshUntil
+				info.FreshUntil = &freshUntil
+			}
+			c.swrm.Unlock()
+		}
+		infos[strings.TrimPrefix(key, c.prefix)] = info
+	}
+	return infos
+}
+
+// Fresh reports whether key exists in memory and is up to date. An entry of a set with stale-while-revalidate
+// enabled is not fresh once it turns stale, or when it has been revalidated but not yet reported as calculated.
+func (c *Set[T]) Fresh(key string) bool {
+	key = c.key(key)
+	if _, ok := c.c.Get(key); !ok {
+		return false
+	}
+	if c.swr > 0 {
+		c.swrm.Lock()
+		defer c.swrm.Unlock()
+		meta, ok := c.swrMeta[key]
+		if !ok || meta.revalidated || time.Now().After(meta.freshUntil) {
+			return false
+		}
+	}
+	return true
+}
+
+// Evict deletes every entry whose key, without the set prefix, matches the glob pattern, from both the in-memory
+// tier and the L2 tier, where entries may exist that are in memory on no instance. It returns the number of entries
+// deleted.
+func (c *Set[T]) Evict(pattern string) (int, error) {
+	keys := make(map[string]struct{})
+	for key := range c.c.Items() {
+		keys[key] = struct{}{}
+	}
+	if t := l2.Load(); t != nil && t.enabled(c.Name()) {
+		l2Keys, err := t.keys(c.prefix)
+		if err != nil {
+			log.Warn().Err(err).Str("prefix", c.prefix).Msg("failed to list L2 cache entries")
+		}
+		for _, key := range l2Keys {
+			keys[key] = struct{}{}
+		}
+	}
+
+	evicted := 0
+	for key := range keys {
+		key = strings.TrimPrefix(key, c.prefix)
+		matched, err := matchPattern(pattern, key)
+		if err != nil {
+			return evicted, err
+		}
+		if matched {
+			if err := c.Delete(key); err != nil {
+				return evicted, err
+			}
+			evicted++
+		}
+	}
+	return evicted, nil
+}
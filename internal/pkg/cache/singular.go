@@ -1,54 +1,71 @@
 package cache
 
 import (
-	"reflect"
-	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
 )
 
 func NewSingular[T any](key string) *Singular[T] {
-	return &Singular[T]{
+	c := &Singular[T]{
 		key: key,
 		c:   cache.New(cache.NoExpiration, time.Minute*10),
 	}
+	c.c.OnEvicted(func(string, any) {
+		observeEviction(key)
+	})
+	register(c)
+	return c
 }
 
 type Singular[T any] struct {
-	// m is a mutex for MutexGetSet for concurrent prevention
-	m sync.Mutex
+	// g deduplicates concurrent loads in MutexGetSet
+	g singleflight.Group
 
 	key string
 
 	c *cache.Cache
 }
 
+func (c *Singular[T]) Name() string {
+	return c.key
+}
+
+// Get gets value from the in-memory tier, or from the L2 tier if it does not exist in memory, and writes to dest.
 func (c *Singular[T]) Get(dest *T) error {
 	result, ok := c.c.Get(c.key)
 	if !ok {
+		if t := getL2(); t != nil && t.enabled(c.key) {
+			var value T
+			if ttl, ok := t.get(c.key, &value); ok {
+				observeRequest(c.key, resultL2Hit)
+				// the in-memory entry expires along with the L2 entry
+				c.c.Set(c.key, newEntry(value), ttl)
+				*dest = value
+				return nil
+			}
+		}
+		observeRequest(c.key, resultMiss)
 		return ErrNotFound
 	}
-	// copy value to dest
-	var r reflect.Value
-	if reflect.ValueOf(result).Kind() == reflect.Ptr {
-		r = reflect.ValueOf(result).Elem()
-	} else {
-		r = reflect.ValueOf(result)
-	}
-	reflect.ValueOf(dest).Elem().Set(r)
 
+	observeRequest(c.key, resultHit)
+	*dest = result.(*entry[T]).value
 	return nil
 }
 
 func (c *Singular[T]) Set(value T, expire time.Duration) {
-	c.c.Set(c.key, value, expire)
+	c.c.Set(c.key, newEntry(value), expire)
+	if t := getL2(); t != nil && t.enabled(c.key) {
+		t.set(c.key, value, t.ttl(c.key, expire))
+	}
 }
 
 // MutexGetSet gets value from cache and writes to dest, or if the key does not exist, it executes valueFunc
 // to get cache value if the key still not exists when serially dispatched, sets value to cache and
-// writes value to dest.
+// writes value to dest. Concurrent calls share a single execution of valueFunc.
 // The first return value means whether the value is got from cache or not. True means calculated; False means got from cache.
 func (c *Singular[T]) MutexGetSet(dest *T, valueFunc func() (T, error), expire time.Duration) error {
 	err := c.Get(dest)
@@ -61,36 +78,69 @@ func (c *Singular[T]) MutexGetSet(dest *T, valueFunc func() (T, error), expire t
 }
 
 func (c *Singular[T]) slowMutexGetSet(dest *T, valueFunc func() (T, error), expire time.Duration) error {
-	c.m.Lock()
-	defer c.m.Unlock()
-	err := c.Get(dest)
-
-	if err == nil {
-		return nil
-	}
-
-	value, err := valueFunc()
+	v, err, _ := c.g.Do(c.key, func() (any, error) {
+		var cached T
+		if err := c.Get(&cached); err == nil {
+			return cached, nil
+		}
+
+		value, err := valueFunc()
+		if err != nil {
+			log.Error().Err(err).Str("key", c.key).Msg("failed to get value from valueFunc() in MutexGetSet")
+			return nil, err
+		}
+
+		c.Set(value, expire)
+		return value, nil
+	})
 	if err != nil {
-		log.Error().Err(err).Str("key", c.key).Msg("failed to get value from valueFunc() in MutexGetSet")
 		return err
 	}
 
-	c.Set(value, expire)
-
-	// copy value to dest
 	// copy value to dest
-	var r reflect.Value
-	if reflect.ValueOf(value).Kind() == reflect.Ptr {
-		r = reflect.ValueOf(value).Elem()
-	} else {
-		r = reflect.ValueOf(value)
-	}
-	reflect.ValueOf(dest).Elem().Set(r)
+	*dest = v.(T)
 
 	return nil
 }
 
 func (c *Singular[T]) Delete() error {
+	observeEvictions(c.key, c.c.ItemCount())
 	c.c.Flush()
+	if t := l2.Load(); t != nil && t.enabled(c.key) {
+		if err := t.delete(c.key); err != nil {
+			log.Warn().Err(err).Str("key", c.key).Msg("failed to delete L2 cache entry")
+		}
+	}
 	return nil
 }
+
+// Inspect returns the metadata of the in-memory entry keyed by an empty string, or an empty map if it does not exist.
+func (c *Singular[T]) Inspect() map[string]*EntryInfo {
+	infos := make(map[string]*EntryInfo)
+	if item, ok := c.c.Items()[c.key]; ok {
+		infos[""] = item.Object.(*entry[T]).info(item.Expiration)
+	}
+	return infos
+}
+
+// Fresh reports whether the entry exists in memory, regardless of the key.
+func (c *Singular[T]) Fresh(string) bool {
+	_, ok := c.c.Get(c.key)
+	return ok
+}
+
+// Evict deletes the entry if the glob pattern matches its key, which is the empty string as reported by Inspect,
+// or the name of the cache, and returns the number of entries deleted.
+func (c *Singular[T]) Evict(pattern string) (int, error) {
+	matched, err := matchPattern(pattern, "")
+	if err != nil {
+		return 0, err
+	}
+	if !matched {
+		if matched, err = matchPattern(pattern, c.key); err != nil || !matched {
+			return 0, err
+		}
+	}
+	evicted := c.c.ItemCount()
+	return evicted, c.Delete()
+}
@@ -0,0 +1,114 @@
+package cachectrl
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zeebo/xxh3"
+
+	"exusiai.dev/backend-next/internal/model/cache"
+	pkgcache "exusiai.dev/backend-next/internal/pkg/cache"
+)
+
+// ResolveFunc resolves the name and key of the cache entry a request is served from. ok is false if the response
+// is not served from a shared cache entry, e.g. personal or filtered requests, and shall not be cached by clients.
+type ResolveFunc func(ctx *fiber.Ctx) (name string, key string, ok bool)
+
+type validator struct {
+	lastModified time.Time
+	etag         string
+}
+
+// validators holds the ETag of the last response served from each cache entry, keyed by name:key.
+// A validator is only valid while the last modified time of its entry stays the same.
+var validators sync.Map
+
+// Conditional returns a middleware opting the responses served from the cache entry resolved by resolve in to
+// caching, with a strong ETag computed from the response body in addition to the headers set by OptIn.
+// Conditional requests (If-None-Match, If-Modified-Since) are answered with 304 Not Modified; when the entry
+// is fresh in memory and its ETag is known, the handler is skipped entirely.
+func Conditional(resolve ResolveFunc) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		name, key, ok := resolve(ctx)
+		if !ok {
+			return ctx.Next()
+		}
+		id := name + ":" + key
+
+		if c, found := pkgcache.Find(name); found && c.Fresh(key) {
+			if lastModified, ok := cache.LastModified(name, key); ok {
+				if v, ok := validators.Load(id); ok && v.(*validator).lastModified.Equal(lastModified) {
+					etag := v.(*validator).etag
+					if notModified(ctx, etag, lastModified) {
+						setValidators(ctx, etag, lastModified)
+						ctx.Status(fiber.StatusNotModified)
+						return nil
+					}
+				}
+			}
+		}
+
+		if err := ctx.Next(); err != nil {
+			return err
+		}
+		if ctx.Response().StatusCode() != fiber.StatusOK {
+			return nil
+		}
+
+		var etag string
+		lastModified, ok := cache.LastModified(name, key)
+		if ok {
+			if v, found := validators.Load(id); found && v.(*validator).lastModified.Equal(lastModified) {
+				etag = v.(*validator).etag
+			} else {
+				etag = computeETag(ctx.Response().Body())
+				validators.Store(id, &validator{lastModified: lastModified, etag: etag})
+			}
+		} else {
+			lastModified = time.Now()
+			etag = computeETag(ctx.Response().Body())
+		}
+
+		setValidators(ctx, etag, lastModified)
+		if notModified(ctx, etag, lastModified) {
+			ctx.Response().ResetBody()
+			ctx.Status(fiber.StatusNotModified)
+		}
+		return nil
+	}
+}
+
+func computeETag(body []byte) string {
+	return `"` + strconv.FormatUint(xxh3.Hash(body), 16) + `"`
+}
+
+func setValidators(ctx *fiber.Ctx, etag string, lastModified time.Time) {
+	OptIn(ctx, lastModified)
+	ctx.Set(fiber.HeaderETag, etag)
+}
+
+// notModified evaluates the conditional request headers. If-Modified-Since is ignored when If-None-Match is
+// present, as per RFC 7232.
+func notModified(ctx *fiber.Ctx, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := ctx.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince := ctx.Get(fiber.HeaderIfModifiedSince); ifModifiedSince != "" {
+		t, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		return !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
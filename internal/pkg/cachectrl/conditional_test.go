@@ -0,0 +1,107 @@
+package cachectrl
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"exusiai.dev/backend-next/internal/model/cache"
+	pkgcache "exusiai.dev/backend-next/internal/pkg/cache"
+)
+
+func TestConditional(t *testing.T) {
+	if cache.LastModifiedTime == nil {
+		cache.LastModifiedTime = pkgcache.NewSet[time.Time]("test-conditional-lastModifiedTime")
+	}
+	entries := pkgcache.NewSet[string]("test-conditional")
+	entries.Set("key", "value", time.Hour)
+	lastModified := time.Now().Add(-time.Hour).Truncate(time.Second)
+	cache.LastModifiedTime.Set("[test-conditional:key]", lastModified, 0)
+
+	calls := 0
+	app := fiber.New()
+	app.Get("/:key", Conditional(func(ctx *fiber.Ctx) (string, string, bool) {
+		if ctx.Params("key") == "personal" {
+			return "", "", false
+		}
+		return "test-conditional", ctx.Params("key"), true
+	}), func(ctx *fiber.Ctx) error {
+		calls++
+		return ctx.SendString("value")
+	})
+
+	get := func(path string, headers map[string]string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := get("/key", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get(fiber.HeaderETag)
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, lastModified.UTC().Format(http.TimeFormat), resp.Header.Get(fiber.HeaderLastModified))
+	assert.Equal(t, 1, calls)
+
+	t.Run("matching If-None-Match skips the handler", func(t *testing.T) {
+		resp := get("/key", map[string]string{fiber.HeaderIfNoneMatch: etag})
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Equal(t, etag, resp.Header.Get(fiber.HeaderETag))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("weak and listed If-None-Match", func(t *testing.T) {
+		resp := get("/key", map[string]string{fiber.HeaderIfNoneMatch: `"other", W/` + etag})
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+
+	t.Run("mismatched If-None-Match", func(t *testing.T) {
+		resp := get("/key", map[string]string{fiber.HeaderIfNoneMatch: `"other"`})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "value", string(body))
+	})
+
+	t.Run("If-None-Match takes precedence over If-Modified-Since", func(t *testing.T) {
+		resp := get("/key", map[string]string{
+			fiber.HeaderIfNoneMatch:     `"other"`,
+			fiber.HeaderIfModifiedSince: time.Now().UTC().Format(http.TimeFormat),
+		})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("If-Modified-Since", func(t *testing.T) {
+		resp := get("/key", map[string]string{fiber.HeaderIfModifiedSince: lastModified.UTC().Format(http.TimeFormat)})
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+		resp = get("/key", map[string]string{fiber.HeaderIfModifiedSince: lastModified.Add(-time.Second).UTC().Format(http.TimeFormat)})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("stale entry runs the handler", func(t *testing.T) {
+		_ = entries.Delete("key")
+		before := calls
+		resp := get("/key", map[string]string{fiber.HeaderIfNoneMatch: etag})
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Empty(t, body)
+		assert.Equal(t, before+1, calls)
+	})
+
+	t.Run("unresolved requests are not conditional", func(t *testing.T) {
+		resp := get("/personal", map[string]string{fiber.HeaderIfNoneMatch: "*"})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get(fiber.HeaderETag))
+	})
+}
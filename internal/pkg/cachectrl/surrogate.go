@@ -0,0 +1,146 @@
+package cachectrl
+
+import (
+	"sort"
+	"strings"
+
+	"exusiai.dev/gommon/constant"
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/guregu/null.v3"
+
+	"exusiai.dev/backend-next/internal/model/types"
+)
+
+// Surrogate keys describe what a response contains, so that the CDN can purge exactly the responses affected
+// by a change. A response carries the kind of its content, the kind scoped to its server, and the stages and
+// items it is filtered by, e.g. "matrix matrix:CN server:CN stage:main_01-07".
+const (
+	TagMatrix  = "matrix"
+	TagPattern = "pattern"
+	TagTrend   = "trend"
+	TagStats   = "stats"
+	TagStages  = "stages"
+	TagItems   = "items"
+	TagZones   = "zones"
+	TagNotices = "notices"
+	TagPeriod  = "period"
+)
+
+// cacheTags maps the caches in model/cache to the kind of responses they are served in.
+var cacheTags = map[string]string{
+	"shimMaxAccumulableDropMatrixResults#server|showClosedZoned|sourceCategory": TagMatrix,
+	"shimLatestPatternMatrixResults#server|sourceCategory":                      TagPattern,
+	"shimMaxAccumulablePatternMatrixResults#server|sourceCategory":              TagPattern,
+	"shimSavedTrendResults#server":                                              TagTrend,
+	"shimTrendResults#server|granularity|sourceCategory":                        TagTrend,
+	"shimSiteStats#server":                                                      TagStats,
+	"stages":                                                                    TagStages,
+	"stage#arkStageId":                                                          TagStages,
+	"shimStages#server":                                                         TagStages,
+	"shimStage#server|arkStageId":                                               TagStages,
+	"items":                                                                     TagItems,
+	"item#arkItemId":                                                            TagItems,
+	"shimItems":                                                                 TagItems,
+	"shimItem#arkItemId":                                                        TagItems,
+	"zones":                                                                     TagZones,
+	"zone#arkZoneId":                                                            TagZones,
+	"shimZones":                                                                 TagZones,
+	"shimZone#arkZoneId":                                                        TagZones,
+	"notices":                                                                   TagNotices,
+	"activities":                                                                TagPeriod,
+	"shimActivities":                                                            TagPeriod,
+}
+
+func ServerTag(server string) string {
+	return "server:" + server
+}
+
+func StageTag(arkStageId string) string {
+	return "stage:" + arkStageId
+}
+
+func ItemTag(arkItemId string) string {
+	return "item:" + arkItemId
+}
+
+// KindTag returns the tag of kind scoped to server, or kind itself if server is empty.
+func KindTag(kind, server string) string {
+	if server == "" {
+		return kind
+	}
+	return kind + ":" + server
+}
+
+// ResponseTags returns the surrogate keys of a response of kind for server, filtered by the given stages and
+// items. server may be empty for responses not specific to a server.
+func ResponseTags(kind, server string, arkStageIds, arkItemIds []string) []string {
+	tags := []string{kind}
+	if server != "" {
+		tags = append(tags, KindTag(kind, server), ServerTag(server))
+	}
+	for _, id := range arkStageIds {
+		if id != "" {
+			tags = append(tags, StageTag(id))
+		}
+	}
+	for _, id := range arkItemIds {
+		if id != "" {
+			tags = append(tags, ItemTag(id))
+		}
+	}
+	return tags
+}
+
+// PurgeTags returns the surrogate keys of the responses served from the caches being purged. Caches not served
+// in responses are ignored; purging a server-keyed cache without a key purges its kind on every server.
+func PurgeTags(pairs []types.PurgeCachePair) []string {
+	set := make(map[string]struct{})
+	for _, pair := range pairs {
+		kind, ok := cacheTags[pair.Name]
+		if !ok {
+			continue
+		}
+		set[KindTag(kind, serverOf(pair.Name, pair.Key))] = struct{}{}
+	}
+	tags := make([]string, 0, len(set))
+	for tag := range set {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// serverOf returns the server segment of key if the cache is keyed by server first.
+func serverOf(name string, key null.String) string {
+	if !key.Valid || !strings.Contains(name, "#server") {
+		return ""
+	}
+	return strings.SplitN(key.String, constant.CacheSep, 2)[0]
+}
+
+// Surrogate returns a middleware setting the Surrogate-Key and Cache-Tag headers of successful responses
+// to the tags returned by tags.
+func Surrogate(tags func(ctx *fiber.Ctx) []string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if err := ctx.Next(); err != nil {
+			return err
+		}
+		status := ctx.Response().StatusCode()
+		if status != fiber.StatusOK && status != fiber.StatusNotModified {
+			return nil
+		}
+		if t := tags(ctx); len(t) > 0 {
+			ctx.Set("Surrogate-Key", strings.Join(t, " "))
+			ctx.Set("Cache-Tag", strings.Join(t, ","))
+		}
+		return nil
+	}
+}
+
+// SplitFilter splits a comma separated filter query into its IDs.
+func SplitFilter(filter string) []string {
+	if filter == "" {
+		return nil
+	}
+	return strings.Split(filter, ",")
+}
@@ -0,0 +1,34 @@
+package cdnpurge
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+)
+
+const (
+	PurgerNoop    = "noop"
+	PurgerWebhook = "webhook"
+)
+
+// Purger purges the responses carrying any of the given surrogate keys from the CDN.
+type Purger interface {
+	Purge(ctx context.Context, tags []string) error
+}
+
+// New creates the Purger configured by conf.CDNPurger.
+func New(conf *appconfig.Config) (Purger, error) {
+	switch conf.CDNPurger {
+	case PurgerNoop:
+		return Noop{}, nil
+	case PurgerWebhook:
+		if conf.CDNPurgeWebhookURL == "" {
+			return nil, errors.New("cdnpurge: webhook url is required for the webhook purger")
+		}
+		return NewWebhook(conf.CDNPurgeWebhookURL, conf.CDNPurgeWebhookToken, conf.CDNPurgeWebhookTimeout), nil
+	default:
+		return nil, errors.Errorf("cdnpurge: unknown purger %q", conf.CDNPurger)
+	}
+}
@@ -0,0 +1,68 @@
+package cdnpurge
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Webhook is a Purger that POSTs the surrogate keys to purge to a generic HTTP endpoint as {"tags": [...]},
+// which is expected to respond with a 2xx status code once the purge has been requested from the CDN.
+type Webhook struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+type webhookRequest struct {
+	Tags []string `json:"tags"`
+}
+
+func NewWebhook(url, token string, timeout time.Duration) *Webhook {
+	return &Webhook{
+		url:   url,
+		token: token,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (w *Webhook) Purge(ctx context.Context, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(&webhookRequest{Tags: tags})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "cdnpurge: failed to request webhook")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("cdnpurge: webhook responded with status code %d", resp.StatusCode)
+	}
+
+	log.Info().
+		Str("evt.name", "cdnpurge.webhook.purged").
+		Strs("tags", tags).
+		Msg("requested CDN purge")
+	return nil
+}
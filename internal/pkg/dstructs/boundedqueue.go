@@ -0,0 +1,76 @@
+package dstructs
+
+import "sync"
+
+// OverflowPolicy decides which element a BoundedQueue discards when pushed to while full.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest element in the queue to make room for the pushed one.
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the pushed element.
+	DropNewest
+)
+
+// BoundedQueue is a thread-safe FIFO queue of a fixed capacity.
+type BoundedQueue[T any] struct {
+	mu     sync.Mutex
+	buf    []T
+	head   int
+	size   int
+	policy OverflowPolicy
+}
+
+func NewBoundedQueue[T any](capacity int, policy OverflowPolicy) *BoundedQueue[T] {
+	return &BoundedQueue[T]{
+		buf:    make([]T, capacity),
+		policy: policy,
+	}
+}
+
+// Push appends v to the queue, and returns whether an element has been discarded to do so.
+func (q *BoundedQueue[T]) Push(v T) (dropped bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.size == len(q.buf) {
+		if q.policy == DropNewest || len(q.buf) == 0 {
+			return true
+		}
+		var zero T
+		q.buf[q.head] = zero
+		q.head = (q.head + 1) % len(q.buf)
+		q.size--
+		dropped = true
+	}
+	q.buf[(q.head+q.size)%len(q.buf)] = v
+	q.size++
+	return dropped
+}
+
+// Flush removes and returns up to max of the oldest elements in the queue, or all of them if max is not positive.
+func (q *BoundedQueue[T]) Flush(max int) []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := q.size
+	if max > 0 && max < n {
+		n = max
+	}
+	out := make([]T, n)
+	var zero T
+	for i := 0; i < n; i++ {
+		out[i] = q.buf[q.head]
+		q.buf[q.head] = zero
+		q.head = (q.head + 1) % len(q.buf)
+	}
+	q.size -= n
+	return out
+}
+
+// Len returns the number of elements in the queue.
+func (q *BoundedQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
@@ -0,0 +1,76 @@
+package dstructs
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoundedQueue(t *testing.T) {
+	q := NewBoundedQueue[int](3, DropOldest)
+	for i := 1; i <= 3; i++ {
+		assert.False(t, q.Push(i))
+	}
+	assert.Equal(t, 3, q.Len())
+
+	assert.Equal(t, []int{1, 2}, q.Flush(2))
+	assert.Equal(t, 1, q.Len())
+
+	// wraps around the end of the buffer
+	assert.False(t, q.Push(4))
+	assert.False(t, q.Push(5))
+	assert.Equal(t, []int{3, 4, 5}, q.Flush(0))
+	assert.Equal(t, 0, q.Len())
+	assert.Empty(t, q.Flush(0))
+}
+
+func TestBoundedQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OverflowPolicy
+		want   []int
+	}{
+		{"drop oldest", DropOldest, []int{3, 4}},
+		{"drop newest", DropNewest, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewBoundedQueue[int](2, tt.policy)
+			assert.False(t, q.Push(1))
+			assert.False(t, q.Push(2))
+			assert.True(t, q.Push(3))
+			assert.True(t, q.Push(4))
+			assert.Equal(t, 2, q.Len())
+			assert.Equal(t, tt.want, q.Flush(0))
+		})
+	}
+
+	t.Run("zero capacity", func(t *testing.T) {
+		q := NewBoundedQueue[int](0, DropOldest)
+		assert.True(t, q.Push(1))
+		assert.Equal(t, 0, q.Len())
+		assert.Empty(t, q.Flush(0))
+	})
+}
+
+func TestBoundedQueueConcurrency(t *testing.T) {
+	q := NewBoundedQueue[int](1000, DropNewest)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				q.Push(j)
+			}
+		}()
+	}
+	flushed := 0
+	for flushed < 1000 {
+		flushed += len(q.Flush(10))
+	}
+	wg.Wait()
+	assert.Equal(t, 0, q.Len())
+}
@@ -0,0 +1,268 @@
+package jobqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// lease is how long a claimed job is kept from being requeued without being renewed by its worker. Jobs of
+	// instances that died without shutting down are requeued once their leases expire.
+	lease = 30 * time.Second
+
+	// pollInterval is the interval idle workers check the queue for new jobs at.
+	pollInterval = time.Second
+)
+
+var ErrFull = errors.New("jobqueue: queue is full")
+
+// pushScript pushes a job id to the queue, unless the queue already holds as many jobs as its size.
+var pushScript = redis.NewScript(`
+if redis.call('LLEN', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('LPUSH', KEYS[1], ARGV[1])
+return 1
+`)
+
+// claimScript moves the oldest job id of the queue to the running list and leases it.
+var claimScript = redis.NewScript(`
+local id = redis.call('RPOP', KEYS[1])
+if not id then
+	return false
+end
+redis.call('LPUSH', KEYS[2], id)
+redis.call('SET', ARGV[1] .. id, '1', 'PX', ARGV[2])
+return id
+`)
+
+// requeueScript moves a running job id back to the front of the queue. Unless ARGV[3] is set, the job id is only
+// moved when its lease has expired.
+var requeueScript = redis.NewScript(`
+if ARGV[3] ~= '1' and redis.call('EXISTS', ARGV[2]) == 1 then
+	return 0
+end
+if redis.call('LREM', KEYS[2], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call('DEL', ARGV[2])
+redis.call('RPUSH', KEYS[1], ARGV[1])
+return 1
+`)
+
+// Handler runs the job with the given id. ctx is cancelled when the instance shuts down, in which case the job
+// is put back to the queue to be run again, so handlers should leave such jobs in a state they can be resumed from.
+type Handler func(ctx context.Context, jobId string)
+
+// Queue is a durable queue of job ids kept in Redis, run by a pool of workers on every instance. Jobs of an
+// instance shutting down are put back to the queue, and jobs of an instance that died are put back once their
+// leases expire, so that every job is run to completion at least once.
+type Queue struct {
+	Redis *redis.Client
+
+	name    string
+	size    int
+	workers int
+	handler Handler
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a queue named name holding at most size jobs, to be run by workers handlers on every instance.
+func New(redisClient *redis.Client, name string, size, workers int, handler Handler) *Queue {
+	return &Queue{
+		Redis:   redisClient,
+		name:    name,
+		size:    size,
+		workers: workers,
+		handler: handler,
+	}
+}
+
+// Push enqueues a job id, and returns ErrFull when the queue is full.
+func (q *Queue) Push(ctx context.Context, jobId string) error {
+	pushed, err := pushScript.Run(ctx, q.Redis, []string{q.queueKey()}, jobId, q.size).Int()
+	if err != nil {
+		return err
+	}
+	if pushed == 0 {
+		return ErrFull
+	}
+	return nil
+}
+
+// Start starts the workers and the reaper requeueing the jobs of dead instances.
+func (q *Queue) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker(ctx)
+	}
+	q.wg.Add(1)
+	go q.reaper(ctx)
+}
+
+// Stop cancels the running jobs, which are put back to the queue, and waits for the workers to exit.
+func (q *Queue) Stop(ctx context.Context) error {
+	if q.cancel == nil {
+		return nil
+	}
+	q.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) worker(ctx context.Context) {
+	defer q.wg.Done()
+
+	for {
+		jobId, err := claimScript.Run(ctx, q.Redis, []string{q.queueKey(), q.runningKey()}, q.leaseKey(""), lease.Milliseconds()).Text()
+		if err == nil {
+			q.run(ctx, jobId)
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if !errors.Is(err, redis.Nil) {
+			log.Error().
+				Str("evt.name", "jobqueue.claim.failed").
+				Str("queue", q.name).
+				Err(err).
+				Msg("failed to claim job")
+		}
+
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *Queue) run(ctx context.Context, jobId string) {
+	renewed := make(chan struct{})
+	stopRenew := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		t := time.NewTicker(lease / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				q.Redis.PExpire(context.Background(), q.leaseKey(jobId), lease)
+			case <-stopRenew:
+				return
+			}
+		}
+	}()
+
+	q.handler(ctx, jobId)
+	close(stopRenew)
+	<-renewed
+
+	// ctx is already done if the instance is shutting down, so the job is settled with a fresh one
+	settleCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	if ctx.Err() != nil {
+		if err := requeueScript.Run(settleCtx, q.Redis, []string{q.queueKey(), q.runningKey()}, jobId, q.leaseKey(jobId), 1).Err(); err != nil {
+			log.Error().
+				Str("evt.name", "jobqueue.requeue.failed").
+				Str("queue", q.name).
+				Str("jobId", jobId).
+				Err(err).
+				Msg("failed to requeue interrupted job: it will be requeued once its lease expires")
+		}
+		return
+	}
+
+	_, err := q.Redis.TxPipelined(settleCtx, func(p redis.Pipeliner) error {
+		p.LRem(settleCtx, q.runningKey(), 1, jobId)
+		p.Del(settleCtx, q.leaseKey(jobId))
+		return nil
+	})
+	if err != nil {
+		log.Error().
+			Str("evt.name", "jobqueue.settle.failed").
+			Str("queue", q.name).
+			Str("jobId", jobId).
+			Err(err).
+			Msg("failed to remove finished job from the running list: it will be run again once its lease expires")
+	}
+}
+
+// reaper requeues the running jobs whose leases have expired, which were claimed by instances that died.
+func (q *Queue) reaper(ctx context.Context) {
+	defer q.wg.Done()
+
+	t := time.NewTicker(lease)
+	defer t.Stop()
+	for {
+		q.reap(ctx)
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *Queue) reap(ctx context.Context) {
+	jobIds, err := q.Redis.LRange(ctx, q.runningKey(), 0, -1).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error().
+				Str("evt.name", "jobqueue.reap.failed").
+				Str("queue", q.name).
+				Err(err).
+				Msg("failed to list running jobs")
+		}
+		return
+	}
+
+	for _, jobId := range jobIds {
+		requeued, err := requeueScript.Run(ctx, q.Redis, []string{q.queueKey(), q.runningKey()}, jobId, q.leaseKey(jobId), 0).Int()
+		if err != nil {
+			return
+		}
+		if requeued == 1 {
+			log.Warn().
+				Str("evt.name", "jobqueue.requeued").
+				Str("queue", q.name).
+				Str("jobId", jobId).
+				Msg("requeued job of an instance that died")
+		}
+	}
+}
+
+func (q *Queue) queueKey() string {
+	return q.name + ":queue"
+}
+
+func (q *Queue) runningKey() string {
+	return q.name + ":running"
+}
+
+func (q *Queue) leaseKey(jobId string) string {
+	return q.name + ":lease:" + jobId
+}
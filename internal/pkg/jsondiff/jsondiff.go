@@ -0,0 +1,166 @@
+// Package jsondiff creates JSON Patch (RFC 6902) and JSON Merge Patch (RFC 7386) documents which transform
+// one JSON document into another.
+package jsondiff
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// Operation is a JSON Patch operation.
+type Operation struct {
+	Op    string
+	Path  string
+	Value any
+}
+
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == OpRemove {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	return json.Marshal(struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value any    `json:"value"`
+	}{o.Op, o.Path, o.Value})
+}
+
+// CreatePatch returns the JSON Patch transforming from into to. Objects are diffed member by member, and arrays
+// element by element at the same indices, with the surplus elements appended or removed at the end.
+func CreatePatch(from, to []byte) ([]byte, error) {
+	a, err := decode(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decode(to)
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]Operation, 0)
+	diff("", a, b, &ops)
+	return json.Marshal(ops)
+}
+
+// CreateMergePatch returns the JSON Merge Patch transforming from into to. As merge patches cannot express
+// changes within arrays, changed arrays are replaced as a whole; members changed to null in to are removed
+// when the patch is applied, as null denotes deletion in a merge patch.
+func CreateMergePatch(from, to []byte) ([]byte, error) {
+	a, err := decode(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decode(to)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(a, b))
+}
+
+// decode decodes a JSON document keeping numbers as they are, so that they are neither compared nor patched
+// with a loss of precision.
+func decode(doc []byte) (any, error) {
+	var v any
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func diff(path string, a, b any, ops *[]Operation) {
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			for _, key := range sortedKeys(a) {
+				if bv, ok := b[key]; ok {
+					diff(path+"/"+escape(key), a[key], bv, ops)
+				} else {
+					*ops = append(*ops, Operation{Op: OpRemove, Path: path + "/" + escape(key)})
+				}
+			}
+			for _, key := range sortedKeys(b) {
+				if _, ok := a[key]; !ok {
+					*ops = append(*ops, Operation{Op: OpAdd, Path: path + "/" + escape(key), Value: b[key]})
+				}
+			}
+			return
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			n := len(a)
+			if len(b) < n {
+				n = len(b)
+			}
+			for i := 0; i < n; i++ {
+				diff(path+"/"+strconv.Itoa(i), a[i], b[i], ops)
+			}
+			// remove from the end so that the indices of the remaining elements stay the same
+			for i := len(a) - 1; i >= n; i-- {
+				*ops = append(*ops, Operation{Op: OpRemove, Path: path + "/" + strconv.Itoa(i)})
+			}
+			for i := n; i < len(b); i++ {
+				*ops = append(*ops, Operation{Op: OpAdd, Path: path + "/-", Value: b[i]})
+			}
+			return
+		}
+	}
+
+	*ops = append(*ops, Operation{Op: OpReplace, Path: path, Value: b})
+}
+
+func mergePatch(a, b any) any {
+	am, aok := a.(map[string]any)
+	bm, bok := b.(map[string]any)
+	if !aok || !bok {
+		return b
+	}
+
+	patch := make(map[string]any)
+	for key := range am {
+		if _, ok := bm[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	for key, bv := range bm {
+		av, ok := am[key]
+		if !ok {
+			patch[key] = bv
+		} else if !reflect.DeepEqual(av, bv) {
+			patch[key] = mergePatch(av, bv)
+		}
+	}
+	return patch
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escape escapes a member name as a JSON Pointer reference token.
+func escape(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,63 @@
+package jsondiff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{"equal", `{"a":1}`, `{"a":1}`, `[]`},
+		{"replace member", `{"a":1,"b":2}`, `{"a":1,"b":3}`, `[{"op":"replace","path":"/b","value":3}]`},
+		{"add and remove members", `{"a":1,"b":2}`, `{"b":2,"c":3}`, `[{"op":"remove","path":"/a"},{"op":"add","path":"/c","value":3}]`},
+		{"nested", `{"a":{"b":[1,2]}}`, `{"a":{"b":[1,3]}}`, `[{"op":"replace","path":"/a/b/1","value":3}]`},
+		{"append elements", `[1]`, `[1,2,3]`, `[{"op":"add","path":"/-","value":2},{"op":"add","path":"/-","value":3}]`},
+		{"remove elements from the end", `[1,2,3]`, `[1]`, `[{"op":"remove","path":"/2"},{"op":"remove","path":"/1"}]`},
+		{"change of type", `{"a":[1]}`, `{"a":{"b":1}}`, `[{"op":"replace","path":"/a","value":{"b":1}}]`},
+		{"escaped member names", `{"a/b":1,"c~d":1}`, `{"a/b":2,"c~d":2}`, `[{"op":"replace","path":"/a~1b","value":2},{"op":"replace","path":"/c~0d","value":2}]`},
+		{"precise numbers", `{"a":12345678901234567890}`, `{"a":12345678901234567891}`, `[{"op":"replace","path":"/a","value":12345678901234567891}]`},
+		{"replace root", `1`, `"a"`, `[{"op":"replace","path":"","value":"a"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := CreatePatch([]byte(tt.from), []byte(tt.to))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(patch))
+		})
+	}
+}
+
+func TestCreateMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{"equal", `{"a":1}`, `{"a":1}`, `{}`},
+		{"replace member", `{"a":1,"b":2}`, `{"a":1,"b":3}`, `{"b":3}`},
+		{"add and remove members", `{"a":1,"b":2}`, `{"b":2,"c":3}`, `{"a":null,"c":3}`},
+		{"nested", `{"a":{"b":1,"c":2}}`, `{"a":{"b":1,"c":3}}`, `{"a":{"c":3}}`},
+		{"arrays are replaced as a whole", `{"a":[1,2]}`, `{"a":[1,3]}`, `{"a":[1,3]}`},
+		{"replace root", `{"a":1}`, `[1]`, `[1]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := CreateMergePatch([]byte(tt.from), []byte(tt.to))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(patch))
+		})
+	}
+}
+
+func TestCreatePatchInvalidDocument(t *testing.T) {
+	_, err := CreatePatch([]byte(`{`), []byte(`{}`))
+	assert.Error(t, err)
+	_, err = CreateMergePatch([]byte(`{}`), []byte(`{`))
+	assert.Error(t, err)
+}
@@ -33,4 +33,32 @@ var (
 		Name: prometheus.BuildFQName(ServiceName, "worker", "calc_duration_seconds"),
 		Help: "Duration of last worker calculation in seconds",
 	}, []string{"service", "server"})
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(ServiceName, "cache", "requests_total"),
+		Help: "Requests to the caches by result: hit, l2_hit or miss. Hits on stale entries are counted as stale in addition",
+	}, []string{"cache", "result"})
+	CacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(ServiceName, "cache", "evictions_total"),
+		Help: "Entries evicted from the in-memory caches, either expired, deleted or flushed",
+	}, []string{"cache"})
+	LiveHouseQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName(ServiceName, "livehouse", "queue_length"),
+		Help: "Reports waiting in the LiveHouse queue",
+	})
+	LiveHouseReportsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(ServiceName, "livehouse", "reports_dropped_total"),
+		Help: "Reports dropped before reaching LiveHouse by reason: overflow, failed or spill_overflow",
+	}, []string{"reason"})
+	LiveHouseBatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(ServiceName, "livehouse", "batches_total"),
+		Help: "Report batches pushed to LiveHouse by result: success, retried, spilled, unspilled or failed",
+	}, []string{"result"})
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(ServiceName, "ratelimit", "rejections_total"),
+		Help: "Requests rejected by the rate limiter, by route group and the key of the rule exceeded",
+	}, []string{"group", "key"})
+	RateLimitErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(ServiceName, "ratelimit", "errors_total"),
+		Help: "Requests let through by the rate limiter as it has failed to count them, by route group",
+	}, []string{"group"})
 )
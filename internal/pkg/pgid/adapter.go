@@ -9,6 +9,10 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+// SessionTokenAuthorizationRealm is the realm of the Authorization header presenting a session token in place of
+// a PenguinID.
+const SessionTokenAuthorizationRealm = "Bearer "
+
 func Extract(ctx *fiber.Ctx) string {
 	authorization := ctx.Get(fiber.HeaderAuthorization)
 	if authorization != "" && !strings.HasPrefix(authorization, constant.PenguinIDAuthorizationRealm) {
@@ -23,6 +27,15 @@ func Extract(ctx *fiber.Ctx) string {
 	return penguinId
 }
 
+// ExtractToken returns the session token presented in the Authorization header, if any.
+func ExtractToken(ctx *fiber.Ctx) string {
+	authorization := ctx.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(authorization, SessionTokenAuthorizationRealm) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(authorization, SessionTokenAuthorizationRealm))
+}
+
 func Inject(ctx *fiber.Ctx, penguinId string) {
 	// we even got emojis in PenguinID for some internal testers :)
 	penguinId = url.QueryEscape(penguinId)
@@ -0,0 +1,248 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/pkg/observability"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/pkg/pgid"
+	"exusiai.dev/backend-next/internal/util"
+)
+
+const RedisPrefix = "ratelimit:"
+
+// Route groups, each limited by its own policy.
+const (
+	GroupReport          = "report"
+	GroupQuery           = "query"
+	GroupQueryV3         = "query-v3"
+	GroupRecognitionInit = "recognition-init"
+	GroupAuth            = "auth"
+)
+
+// Keys a rule counts requests by.
+const (
+	// KeyPenguinID counts requests by the account of the session token presented, or by the PenguinID presented,
+	// or by the client IP if neither is presented or the token is invalid. Unlike session tokens, the PenguinID is
+	// not verified, so a client presenting a new one on every request is never limited by it: pair it with a KeyIP
+	// rule, which is the one actually bounding a group.
+	KeyPenguinID = "penguinid"
+	// KeyIP counts requests by the client IP, as resolved through the trusted proxies.
+	KeyIP = "ip"
+	// KeySource counts requests by the report source in the JSON body. Requests without one are not counted.
+	KeySource = "source"
+)
+
+var ErrTooManyRequests = pgerr.New(http.StatusTooManyRequests, "TOO_MANY_REQUESTS", "Your client is sending requests too frequently. Please retry after the time in the RateLimit-Reset header.")
+
+// incrScript increments the counter of a window, starting the window on its first request, and returns the
+// count along with the milliseconds left in the window.
+var incrScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return {n, redis.call('PTTL', KEYS[1])}
+`)
+
+// Rule allows Limit requests per Window for every value of Key.
+type Rule struct {
+	Key    string
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRule parses a rule in the form of `<key>:<limit>/<window>`, e.g. `ip:300/5m`.
+func ParseRule(spec string) (Rule, error) {
+	key, quota, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	if !ok {
+		return Rule{}, errors.Errorf("invalid rate limit rule %q: expected <key>:<limit>/<window>", spec)
+	}
+	if key != KeyPenguinID && key != KeyIP && key != KeySource {
+		return Rule{}, errors.Errorf("invalid rate limit rule %q: unknown key %q", spec, key)
+	}
+	limitStr, windowStr, ok := strings.Cut(quota, "/")
+	if !ok {
+		return Rule{}, errors.Errorf("invalid rate limit rule %q: expected <key>:<limit>/<window>", spec)
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		return Rule{}, errors.Errorf("invalid rate limit rule %q: limit must be a positive integer", spec)
+	}
+	window, err := time.ParseDuration(windowStr)
+	if err != nil || window < time.Second {
+		return Rule{}, errors.Errorf("invalid rate limit rule %q: window must be a duration of at least 1s", spec)
+	}
+	return Rule{Key: key, Limit: limit, Window: window}, nil
+}
+
+// Sessions resolves the accounts of session tokens.
+type Sessions interface {
+	Account(ctx context.Context, token string) (int, error)
+}
+
+// Limiter limits the requests of route groups with fixed windows counted in Redis, so that the limits are shared
+// by every instance. A request is rejected once any rule of its group is exceeded. Requests are let through
+// whenever Redis fails, as rate limiting shall never take the API down.
+type Limiter struct {
+	Redis    *redis.Client
+	Sessions Sessions
+
+	enabled  bool
+	policies map[string][]Rule
+}
+
+func New(redisClient *redis.Client, sessions Sessions, conf *appconfig.Config) (*Limiter, error) {
+	l := &Limiter{
+		Redis:    redisClient,
+		Sessions: sessions,
+		enabled:  conf.RateLimitEnabled,
+		policies: make(map[string][]Rule),
+	}
+	for group, specs := range map[string][]string{
+		GroupReport:          conf.RateLimitReport,
+		GroupQuery:           conf.RateLimitQuery,
+		GroupQueryV3:         conf.RateLimitQueryV3,
+		GroupRecognitionInit: conf.RateLimitRecognitionInit,
+		GroupAuth:            conf.RateLimitAuth,
+	} {
+		for _, spec := range specs {
+			if strings.TrimSpace(spec) == "" {
+				continue
+			}
+			rule, err := ParseRule(spec)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rate limit policy of %s", group)
+			}
+			l.policies[group] = append(l.policies[group], rule)
+		}
+	}
+	return l, nil
+}
+
+// Middleware returns a middleware limiting the requests by the policy of group. The RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers describe the rule closest to being exceeded, and
+// RateLimit-Policy lists every rule of the group.
+func (l *Limiter) Middleware(group string) fiber.Handler {
+	rules := l.policies[group]
+	if !l.enabled || len(rules) == 0 {
+		return func(ctx *fiber.Ctx) error {
+			return ctx.Next()
+		}
+	}
+
+	policies := make([]string, len(rules))
+	for i, rule := range rules {
+		policies[i] = strconv.Itoa(rule.Limit) + ";w=" + strconv.Itoa(int(rule.Window.Seconds()))
+	}
+	policy := strings.Join(policies, ", ")
+
+	return func(ctx *fiber.Ctx) error {
+		var (
+			tightest *Rule
+			exceeded *Rule
+			used     int
+			reset    time.Duration
+		)
+		for i := range rules {
+			rule := &rules[i]
+			value := l.keyValue(ctx, rule.Key)
+			if value == "" {
+				continue
+			}
+
+			count, ttl, err := l.incr(ctx.UserContext(), group, rule, value)
+			if err != nil {
+				observability.RateLimitErrors.WithLabelValues(group).Inc()
+				log.Warn().
+					Str("evt.name", "ratelimit.failed").
+					Str("group", group).
+					Err(err).
+					Msg("failed to count request: letting it through")
+				return ctx.Next()
+			}
+
+			// the rule with the least remaining requests, relative to its limit, is the one described
+			if tightest == nil || rule.Limit-count < tightest.Limit-used {
+				tightest, used, reset = rule, count, ttl
+			}
+			if count > rule.Limit && exceeded == nil {
+				exceeded = rule
+			}
+		}
+		if tightest == nil {
+			return ctx.Next()
+		}
+
+		remaining := tightest.Limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		resetSeconds := strconv.Itoa(int((reset + time.Second - 1) / time.Second))
+		ctx.Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+		ctx.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		ctx.Set("RateLimit-Reset", resetSeconds)
+		ctx.Set("RateLimit-Policy", policy)
+
+		if exceeded != nil {
+			observability.RateLimitRejections.WithLabelValues(group, exceeded.Key).Inc()
+			ctx.Set(fiber.HeaderRetryAfter, resetSeconds)
+			return ErrTooManyRequests
+		}
+		return ctx.Next()
+	}
+}
+
+func (l *Limiter) incr(ctx context.Context, group string, rule *Rule, value string) (count int, ttl time.Duration, err error) {
+	// values are hashed so that PenguinIDs are never kept in Redis
+	h := sha256.Sum256([]byte(value))
+	key := RedisPrefix + group + ":" + rule.Key + ":" + strconv.Itoa(int(rule.Window.Seconds())) + ":" + hex.EncodeToString(h[:12])
+
+	res, err := incrScript.Run(ctx, l.Redis, []string{key}, rule.Window.Milliseconds()).Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(res) != 2 {
+		return 0, 0, errors.New("unexpected result of rate limit script")
+	}
+	n, _ := res[0].(int64)
+	pttl, _ := res[1].(int64)
+	if pttl < 0 {
+		pttl = rule.Window.Milliseconds()
+	}
+	return int(n), time.Duration(pttl) * time.Millisecond, nil
+}
+
+func (l *Limiter) keyValue(ctx *fiber.Ctx, key string) string {
+	switch key {
+	case KeyPenguinID:
+		if token := pgid.ExtractToken(ctx); token != "" {
+			if accountId, err := l.Sessions.Account(ctx.UserContext(), token); err == nil {
+				return "account:" + strconv.Itoa(accountId)
+			}
+			return "ip:" + util.ExtractIP(ctx)
+		}
+		if penguinId := pgid.Extract(ctx); penguinId != "" {
+			return "pgid:" + penguinId
+		}
+		return "ip:" + util.ExtractIP(ctx)
+	case KeyIP:
+		return util.ExtractIP(ctx)
+	case KeySource:
+		return gjson.GetBytes(ctx.Body(), "source").String()
+	}
+	return ""
+}
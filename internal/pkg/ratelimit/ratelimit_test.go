@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"exusiai.dev/gommon/constant"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/pkg/pgid"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Rule
+		err  bool
+	}{
+		{spec: "ip:300/5m", want: Rule{Key: KeyIP, Limit: 300, Window: 5 * time.Minute}},
+		{spec: " penguinid:60/1m ", want: Rule{Key: KeyPenguinID, Limit: 60, Window: time.Minute}},
+		{spec: "source:10/1s", want: Rule{Key: KeySource, Limit: 10, Window: time.Second}},
+		{spec: "ip", err: true},
+		{spec: "ip:300", err: true},
+		{spec: "account:300/5m", err: true},
+		{spec: "ip:0/5m", err: true},
+		{spec: "ip:-1/5m", err: true},
+		{spec: "ip:many/5m", err: true},
+		{spec: "ip:300/500ms", err: true},
+		{spec: "ip:300/forever", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			rule, err := ParseRule(tt.spec)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rule)
+		})
+	}
+}
+
+func setupLimiter(t *testing.T, configure func(conf *appconfig.ConfigSpec)) (*fiber.App, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	conf := appconfig.ConfigSpec{RateLimitEnabled: true}
+	configure(&conf)
+	l, err := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), sessions{"token-1": 1, "token-2": 1}, &appconfig.Config{ConfigSpec: conf})
+	require.NoError(t, err)
+
+	app := fiber.New(fiber.Config{ErrorHandler: func(ctx *fiber.Ctx, err error) error {
+		if pe, ok := err.(*pgerr.PenguinError); ok {
+			return ctx.SendStatus(pe.StatusCode)
+		}
+		return ctx.SendStatus(fiber.StatusInternalServerError)
+	}})
+	app.Get("/", l.Middleware(GroupQuery), func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusNoContent)
+	})
+	return app, mr
+}
+
+// sessions maps session tokens to their accounts.
+type sessions map[string]int
+
+func (s sessions) Account(ctx context.Context, token string) (int, error) {
+	if accountId, ok := s[token]; ok {
+		return accountId, nil
+	}
+	return 0, errors.New("invalid token")
+}
+
+func request(t *testing.T, app *fiber.App, penguinId string) *http.Response {
+	t.Helper()
+
+	if penguinId == "" {
+		return requestWithAuthorization(t, app, "")
+	}
+	return requestWithAuthorization(t, app, constant.PenguinIDAuthorizationRealm+penguinId)
+}
+
+func requestWithAuthorization(t *testing.T, app *fiber.App, authorization string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if authorization != "" {
+		req.Header.Set(fiber.HeaderAuthorization, authorization)
+	}
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	app, mr := setupLimiter(t, func(conf *appconfig.ConfigSpec) {
+		conf.RateLimitQuery = []string{"ip:3/1m", "penguinid:2/10s"}
+	})
+
+	resp := request(t, app, "1")
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "3;w=60, 2;w=10", resp.Header.Get("RateLimit-Policy"))
+	// the penguinid rule has the least remaining requests
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "10", resp.Header.Get("RateLimit-Reset"))
+
+	resp = request(t, app, "1")
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+	mr.FastForward(4 * time.Second)
+	resp = request(t, app, "1")
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "6", resp.Header.Get("RateLimit-Reset"))
+	assert.Equal(t, "6", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	// a new PenguinID is not limited by the penguinid rule, but still is by the ip one
+	resp = request(t, app, "2")
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "3", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "56", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	for _, key := range mr.Keys() {
+		assert.True(t, strings.HasPrefix(key, RedisPrefix+GroupQuery+":"))
+		assert.NotContains(t, key, "pgid")
+	}
+}
+
+func TestMiddlewareSessionTokens(t *testing.T) {
+	app, _ := setupLimiter(t, func(conf *appconfig.ConfigSpec) {
+		conf.RateLimitQuery = []string{"penguinid:2/1m"}
+	})
+
+	// both tokens are of the same account, which is counted once
+	resp := requestWithAuthorization(t, app, pgid.SessionTokenAuthorizationRealm+"token-1")
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	resp = requestWithAuthorization(t, app, pgid.SessionTokenAuthorizationRealm+"token-2")
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	resp = requestWithAuthorization(t, app, pgid.SessionTokenAuthorizationRealm+"token-1")
+	assert.Equal(t, fiber.StatusTooManyRequests, resp.StatusCode)
+
+	// other clients behind the same IP are not
+	resp = request(t, app, "1")
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	resp = requestWithAuthorization(t, app, pgid.SessionTokenAuthorizationRealm+"invalid")
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+}
+
+func TestMiddlewareRedisDown(t *testing.T) {
+	app, mr := setupLimiter(t, func(conf *appconfig.ConfigSpec) {
+		conf.RateLimitQuery = []string{"ip:1/1m"}
+	})
+	mr.Close()
+
+	for i := 0; i < 3; i++ {
+		resp := request(t, app, "")
+		assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+	}
+}
+
+func TestMiddlewareDisabled(t *testing.T) {
+	app, mr := setupLimiter(t, func(conf *appconfig.ConfigSpec) {
+		conf.RateLimitEnabled = false
+		conf.RateLimitQuery = []string{"ip:1/1m"}
+	})
+
+	for i := 0; i < 3; i++ {
+		resp := request(t, app, "")
+		assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	}
+	assert.Empty(t, mr.Keys())
+}
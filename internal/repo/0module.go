@@ -19,6 +19,7 @@ func Module() fx.Option {
 		NewTimeRange,
 		NewDropReport,
 		NewRejectRule,
+		NewProbeEvent,
 		NewDropPattern,
 		NewTrendElement,
 		NewDropReportExtra,
@@ -10,6 +10,7 @@ import (
 	"github.com/uptrace/bun"
 
 	"exusiai.dev/backend-next/internal/model"
+	modelv3 "exusiai.dev/backend-next/internal/model/v3"
 	"exusiai.dev/backend-next/internal/pkg/pgerr"
 	"exusiai.dev/backend-next/internal/pkg/pgid"
 )
@@ -63,7 +64,6 @@ func (c *Account) GetAccountById(ctx context.Context, accountId string) (*model.
 
 	err := c.db.NewSelect().
 		Model(&account).
-		Column("account_id").
 		Where("account_id = ?", accountId).
 		Scan(ctx)
 
@@ -107,3 +107,232 @@ func (c *Account) IsAccountExistWithId(ctx context.Context, accountId int) bool
 
 	return account.AccountID > 0
 }
+
+// MergeAccount re-points the drop reports and recognition defects of the source account of merge to its target
+// account, deletes the source account, saves the moderation state of target and saves merge as the audit record,
+// in a single transaction.
+func (c *Account) MergeAccount(ctx context.Context, merge *model.AccountMerge, target *model.Account) error {
+	return c.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().
+			Model((*model.DropReport)(nil)).
+			Set("account_id = ?", merge.TargetAccountID).
+			Where("account_id = ?", merge.SourceAccountID).
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to re-point drop reports")
+		}
+		reports, _ := res.RowsAffected()
+
+		res, err = tx.NewUpdate().
+			Model((*model.RecognitionDefect)(nil)).
+			Set("account_id = ?", merge.TargetAccountID).
+			Where("account_id = ?", merge.SourceAccountID).
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to re-point recognition defects")
+		}
+		defects, _ := res.RowsAffected()
+
+		_, err = tx.NewDelete().
+			Model((*model.Account)(nil)).
+			Where("account_id = ?", merge.SourceAccountID).
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete source account")
+		}
+
+		_, err = tx.NewUpdate().
+			Model(target).
+			Column("status", "moderation_reason", "moderated_at").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to save target account moderation")
+		}
+
+		merge.Reports = int(reports)
+		merge.Defects = int(defects)
+		_, err = tx.NewInsert().
+			Model(merge).
+			Returning("merge_id").
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to save account merge")
+		}
+		return nil
+	})
+}
+
+// AnonymizeAccount replaces the PenguinID of the account with penguinId, which shall be one that can never be
+// presented, detaches its recognition defects, redacts the PenguinIDs of its merges and purges the IPs of its
+// reports. The reports themselves are
+// kept with the account, as it can no longer be related to anyone.
+func (c *Account) AnonymizeAccount(ctx context.Context, accountId int, penguinId string) (*modelv3.AccountDeletionResult, error) {
+	result := &modelv3.AccountDeletionResult{}
+	err := c.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		purged, err := purgeAccountIPs(ctx, tx, accountId)
+		if err != nil {
+			return err
+		}
+		result.PurgedIPs = purged
+
+		if err := redactAccountMerges(ctx, tx, accountId); err != nil {
+			return err
+		}
+
+		res, err := tx.NewUpdate().
+			Model((*model.RecognitionDefect)(nil)).
+			Set("account_id = NULL").
+			Where("account_id = ?", accountId).
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to detach recognition defects")
+		}
+		defects, _ := res.RowsAffected()
+		result.Defects = int(defects)
+
+		reports, err := tx.NewSelect().
+			Model((*model.DropReport)(nil)).
+			Where("account_id = ?", accountId).
+			Count(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to count drop reports")
+		}
+		result.Reports = reports
+
+		_, err = tx.NewUpdate().
+			Model((*model.Account)(nil)).
+			Set("penguin_id = ?", penguinId).
+			Where("account_id = ?", accountId).
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to anonymize account")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteAccount deletes the account along with its reports, report extras and recognition defects, and redacts the
+// PenguinIDs of its merges.
+func (c *Account) DeleteAccount(ctx context.Context, accountId int) (*modelv3.AccountDeletionResult, error) {
+	result := &modelv3.AccountDeletionResult{}
+	err := c.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*model.DropReportExtra)(nil)).
+			Where("report_id IN (?)", accountReportIds(tx, accountId)).
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete drop report extras")
+		}
+		purged, _ := res.RowsAffected()
+		result.PurgedIPs = int(purged)
+
+		res, err = tx.NewDelete().
+			Model((*model.DropReport)(nil)).
+			Where("account_id = ?", accountId).
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete drop reports")
+		}
+		reports, _ := res.RowsAffected()
+		result.Reports = int(reports)
+
+		res, err = tx.NewDelete().
+			Model((*model.RecognitionDefect)(nil)).
+			Where("account_id = ?", accountId).
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete recognition defects")
+		}
+		defects, _ := res.RowsAffected()
+		result.Defects = int(defects)
+
+		if err := redactAccountMerges(ctx, tx, accountId); err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().
+			Model((*model.Account)(nil)).
+			Where("account_id = ?", accountId).
+			Exec(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete account")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func accountReportIds(tx bun.Tx, accountId int) *bun.SelectQuery {
+	return tx.NewSelect().
+		Model((*model.DropReport)(nil)).
+		Column("report_id").
+		Where("account_id = ?", accountId)
+}
+
+// redactAccountMerges blanks the PenguinIDs kept by the audit records of the merges into the account, including
+// those into the accounts merged into it, as every one of them has been the account's. The records themselves are
+// kept along with their account ids.
+func redactAccountMerges(ctx context.Context, tx bun.Tx, accountId int) error {
+	accountIds := []int{accountId}
+	for frontier := accountIds; len(frontier) > 0; {
+		var sourceIds []int
+		err := tx.NewSelect().
+			Model((*model.AccountMerge)(nil)).
+			Column("source_account_id").
+			Where("target_account_id IN (?)", bun.In(frontier)).
+			Where("source_account_id NOT IN (?)", bun.In(accountIds)).
+			Scan(ctx, &sourceIds)
+		if err != nil {
+			return errors.Wrap(err, "failed to find merged accounts")
+		}
+		accountIds = append(accountIds, sourceIds...)
+		frontier = sourceIds
+	}
+
+	_, err := tx.NewUpdate().
+		Model((*model.AccountMerge)(nil)).
+		Set("source_penguin_id = ''").
+		Set("target_penguin_id = ''").
+		WhereGroup(" AND ", func(q *bun.UpdateQuery) *bun.UpdateQuery {
+			return q.
+				Where("source_account_id IN (?)", bun.In(accountIds)).
+				WhereOr("target_account_id IN (?)", bun.In(accountIds))
+		}).
+		Exec(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to redact account merges")
+	}
+	return nil
+}
+
+func purgeAccountIPs(ctx context.Context, tx bun.Tx, accountId int) (int, error) {
+	res, err := tx.NewUpdate().
+		Model((*model.DropReportExtra)(nil)).
+		Set("ip = NULL").
+		Where("report_id IN (?)", accountReportIds(tx, accountId)).
+		Where("ip IS NOT NULL").
+		Exec(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge report IPs")
+	}
+	purged, _ := res.RowsAffected()
+	return int(purged), nil
+}
+
+// UpdateAccountModeration saves the status, trust score, moderation reason and moderation time of the account.
+func (c *Account) UpdateAccountModeration(ctx context.Context, account *model.Account) error {
+	_, err := c.db.NewUpdate().
+		Model(account).
+		Column("status", "trust_score", "moderation_reason", "moderated_at").
+		WherePK().
+		Exec(ctx)
+	return err
+}
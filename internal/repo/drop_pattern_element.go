@@ -107,3 +107,19 @@ func (r *DropPatternElement) GetDropPatternElementsByPatternId(ctx context.Conte
 	}
 	return elements, nil
 }
+
+func (r *DropPatternElement) GetDropPatternElementsByPatternIds(ctx context.Context, patternIds []int) ([]*model.DropPatternElement, error) {
+	elements := make([]*model.DropPatternElement, 0)
+	if len(patternIds) == 0 {
+		return elements, nil
+	}
+
+	err := r.DB.NewSelect().
+		Model(&elements).
+		Where("drop_pattern_id IN (?)", bun.In(patternIds)).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return elements, nil
+}
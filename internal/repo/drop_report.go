@@ -43,6 +43,23 @@ func (s *DropReport) DeleteDropReport(ctx context.Context, reportId int) error {
 	return err
 }
 
+// GetDropReportsByAccountId returns up to limit drop reports of the account, in the order of their ids, starting
+// after afterReportId.
+func (s *DropReport) GetDropReportsByAccountId(ctx context.Context, accountId int, afterReportId int, limit int) ([]*model.DropReport, error) {
+	var reports []*model.DropReport
+	err := s.DB.NewSelect().
+		Model(&reports).
+		Where("account_id = ?", accountId).
+		Where("report_id > ?", afterReportId).
+		Order("report_id").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
 func (s *DropReport) UpdateDropReportReliability(ctx context.Context, tx bun.Tx, reportId int, reliability int) error {
 	_, err := tx.NewUpdate().
 		Model((*model.DropReport)(nil)).
@@ -331,6 +348,49 @@ func (s *DropReport) CalcRecentUniqueUserCountBySource(ctx context.Context, dura
 	return results, nil
 }
 
+// CalcTotalTimesBySource counts single-run reports per stage, source name and version within the time window.
+func (s *DropReport) CalcTotalTimesBySource(ctx context.Context, server string, start time.Time, end time.Time) ([]*model.TotalTimesResultBySource, error) {
+	results := make([]*model.TotalTimesResultBySource, 0)
+	query := s.DB.NewSelect().
+		TableExpr("drop_reports AS dr").
+		Column("dr.stage_id", "dr.source_name", "dr.version").
+		ColumnExpr("COUNT(*) AS total_times")
+	s.handleAccountAndReliability(query, null.NewInt(0, false))
+	s.handleCreatedAtWithTime(query, start, end)
+	s.handleServer(query, server)
+	s.handleTimes(query, 1)
+
+	if err := query.
+		Group("dr.stage_id", "dr.source_name", "dr.version").
+		Scan(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// CalcTotalQuantityBySource sums up the quantity and squared quantity of single-run reports per stage, item,
+// source name and version within the time window.
+func (s *DropReport) CalcTotalQuantityBySource(ctx context.Context, server string, start time.Time, end time.Time) ([]*model.TotalQuantityResultBySource, error) {
+	results := make([]*model.TotalQuantityResultBySource, 0)
+	query := s.DB.NewSelect().
+		TableExpr("drop_reports AS dr").
+		Column("dr.stage_id", "dpe.item_id", "dr.source_name", "dr.version").
+		ColumnExpr("SUM(dpe.quantity) AS total_quantity").
+		ColumnExpr("SUM(dpe.quantity * dpe.quantity) AS total_squared_quantity").
+		Join("JOIN drop_pattern_elements AS dpe ON dpe.drop_pattern_id = dr.pattern_id")
+	s.handleAccountAndReliability(query, null.NewInt(0, false))
+	s.handleCreatedAtWithTime(query, start, end)
+	s.handleServer(query, server)
+	s.handleTimes(query, 1)
+
+	if err := query.
+		Group("dr.stage_id", "dpe.item_id", "dr.source_name", "dr.version").
+		Scan(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
 func (s *DropReport) handleStagesAndItems(query *bun.SelectQuery, stageIdItemIdMap map[int][]int) {
 	stageConditions := make([]string, 0)
 	for stageId, itemIds := range stageIdItemIdMap {
@@ -36,6 +36,23 @@ func (c *DropReportExtra) GetDropReportExtraById(ctx context.Context, id int) (*
 	return &dropReportExtra, nil
 }
 
+func (c *DropReportExtra) GetDropReportExtrasByIds(ctx context.Context, ids []int) ([]*model.DropReportExtra, error) {
+	var dropReportExtras []*model.DropReportExtra
+	if len(ids) == 0 {
+		return dropReportExtras, nil
+	}
+
+	err := c.DB.NewSelect().
+		Model(&dropReportExtras).
+		Where("report_id IN (?)", bun.In(ids)).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return dropReportExtras, nil
+}
+
 func (c *DropReportExtra) IsDropReportExtraMD5Exist(ctx context.Context, md5 string) bool {
 	var dropReportExtra model.DropReportExtra
 
@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"exusiai.dev/backend-next/internal/model"
+)
+
+type ProbeEvent struct {
+	DB *bun.DB
+}
+
+func NewProbeEvent(db *bun.DB) *ProbeEvent {
+	return &ProbeEvent{DB: db}
+}
+
+func (s *ProbeEvent) CreateProbeEvents(ctx context.Context, events []*model.ProbeEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	_, err := s.DB.NewInsert().
+		Model(&events).
+		Exec(ctx)
+	return err
+}
+
+// CountProbeEventsBy counts the probe events of the given types created since start by the non-empty values of
+// column, and returns the most frequent ones first.
+func (s *ProbeEvent) CountProbeEventsBy(ctx context.Context, column string, types []string, start time.Time, limit int) ([]*model.ProbeCount, error) {
+	results := make([]*model.ProbeCount, 0)
+	err := s.DB.NewSelect().
+		Model((*model.ProbeEvent)(nil)).
+		ColumnExpr("? AS key", bun.Ident(column)).
+		ColumnExpr("COUNT(*) AS count").
+		Where("type IN (?)", bun.In(types)).
+		Where("created_at >= ?", start).
+		Where("? <> ''", bun.Ident(column)).
+		GroupExpr("?", bun.Ident(column)).
+		OrderExpr("count DESC").
+		Limit(limit).
+		Scan(ctx, &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// DeleteProbeEventsBefore deletes up to limit probe events created before before, and returns the number of
+// events deleted.
+func (s *ProbeEvent) DeleteProbeEventsBefore(ctx context.Context, before time.Time, limit int) (int64, error) {
+	res, err := s.DB.NewDelete().
+		Model((*model.ProbeEvent)(nil)).
+		Where("event_id IN (?)", s.DB.NewSelect().
+			Model((*model.ProbeEvent)(nil)).
+			Column("event_id").
+			Where("created_at < ?", before).
+			Limit(limit)).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -65,6 +65,21 @@ func (s *RecognitionDefect) GetDefectReports(ctx context.Context, limit int, pag
 	return defectReports, nil
 }
 
+func (s *RecognitionDefect) GetDefectReportsByAccountId(ctx context.Context, accountId int) ([]*model.RecognitionDefect, error) {
+	var defectReports []*model.RecognitionDefect
+
+	err := s.DB.NewSelect().
+		Model(&defectReports).
+		Where("account_id = ?", accountId).
+		Order("created_at").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return defectReports, nil
+}
+
 func (s *RecognitionDefect) GetDefectReport(ctx context.Context, defectId string) (*model.RecognitionDefect, error) {
 	var defectReport model.RecognitionDefect
 
@@ -2,6 +2,7 @@ package repo
 
 import (
 	"context"
+	"time"
 
 	"github.com/uptrace/bun"
 
@@ -40,6 +41,20 @@ func (s *Snapshot) GetLatestSnapshotByKey(ctx context.Context, key string) (*mod
 	})
 }
 
+// GetLatestSnapshotsByKey returns the latest limit snapshots of key, latest first.
+func (s *Snapshot) GetLatestSnapshotsByKey(ctx context.Context, key string, limit int) ([]*model.Snapshot, error) {
+	return s.sel.SelectMany(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("key = ?", key).OrderExpr("snapshot_id DESC").Limit(limit)
+	})
+}
+
+// GetSnapshotVersionsByKey returns every snapshot of key without its content, earliest first.
+func (s *Snapshot) GetSnapshotVersionsByKey(ctx context.Context, key string) ([]*model.Snapshot, error) {
+	return s.sel.SelectMany(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Column("snapshot_id", "created_at", "key", "version").Where("key = ?", key).OrderExpr("snapshot_id ASC")
+	})
+}
+
 func (s *Snapshot) GetSnapshotsByVersions(ctx context.Context, key string, versions []string) ([]*model.Snapshot, error) {
 	return s.sel.SelectMany(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
 		return q.Where("key = ?", key).Where("version IN (?)", bun.In(versions))
@@ -56,3 +71,25 @@ func (s *Snapshot) SaveSnapshot(ctx context.Context, snapshot *model.Snapshot) (
 
 	return snapshot, err
 }
+
+// DeleteSnapshotsBeyondRetention deletes the snapshots of key created before the given time, except for the
+// latest keep snapshots. It returns the number of snapshots deleted.
+func (s *Snapshot) DeleteSnapshotsBeyondRetention(ctx context.Context, key string, keep int, before time.Time) (int64, error) {
+	latest := s.DB.NewSelect().
+		Model((*model.Snapshot)(nil)).
+		Column("snapshot_id").
+		Where("key = ?", key).
+		OrderExpr("snapshot_id DESC").
+		Limit(keep)
+
+	result, err := s.DB.NewDelete().
+		Model((*model.Snapshot)(nil)).
+		Where("key = ?", key).
+		Where("created_at < ?", before).
+		Where("snapshot_id NOT IN (?)", latest).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
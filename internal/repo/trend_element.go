@@ -3,6 +3,7 @@ package repo
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/uptrace/bun"
@@ -18,9 +19,10 @@ func NewTrendElement(db *bun.DB) *TrendElement {
 	return &TrendElement{db: db}
 }
 
+// BatchSaveElements replaces all elements calculated by the trend worker for the server. Backfilled elements are kept.
 func (s *TrendElement) BatchSaveElements(ctx context.Context, elements []*model.TrendElement, server string) error {
 	err := s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
-		_, err := tx.NewDelete().Model((*model.TrendElement)(nil)).Where("server = ?", server).Exec(ctx)
+		_, err := tx.NewDelete().Model((*model.TrendElement)(nil)).Where("server = ?", server).Where("backfilled = FALSE").Exec(ctx)
 		if err != nil {
 			return err
 		}
@@ -33,14 +35,63 @@ func (s *TrendElement) BatchSaveElements(ctx context.Context, elements []*model.
 	return nil
 }
 
+// BatchSaveBackfilledElements replaces the backfilled elements of the granularity starting within [startTime, endTime).
+func (s *TrendElement) BatchSaveBackfilledElements(
+	ctx context.Context, elements []*model.TrendElement, server string, granularity string, sourceCategories []string, startTime, endTime time.Time,
+) error {
+	return s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().
+			Model((*model.TrendElement)(nil)).
+			Where("server = ?", server).
+			Where("granularity = ?", granularity).
+			Where("source_category IN (?)", bun.In(sourceCategories)).
+			Where("backfilled = TRUE").
+			Where("start_time >= ?", startTime).
+			Where("start_time < ?", endTime).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if len(elements) == 0 {
+			return nil
+		}
+		_, err = tx.NewInsert().Model(&elements).Exec(ctx)
+		return err
+	})
+}
+
 func (s *TrendElement) DeleteByServer(ctx context.Context, server string) error {
 	_, err := s.db.NewDelete().Model((*model.TrendElement)(nil)).Where("server = ?", server).Exec(ctx)
 	return err
 }
 
+// GetElementsByServerAndSourceCategory returns the daily elements calculated by the trend worker.
 func (s *TrendElement) GetElementsByServerAndSourceCategory(ctx context.Context, server string, sourceCategory string) ([]*model.TrendElement, error) {
 	var elements []*model.TrendElement
-	err := s.db.NewSelect().Model(&elements).Where("server = ?", server).Where("source_category = ?", sourceCategory).Scan(ctx)
+	err := s.db.NewSelect().
+		Model(&elements).
+		Where("server = ?", server).
+		Where("source_category = ?", sourceCategory).
+		Where("granularity = ?", model.TrendGranularityDay).
+		Where("backfilled = FALSE").
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return elements, nil
+}
+
+// GetElementsByGranularity returns both the elements calculated by the trend worker and the backfilled elements of the granularity.
+func (s *TrendElement) GetElementsByGranularity(ctx context.Context, server string, sourceCategory string, granularity string) ([]*model.TrendElement, error) {
+	var elements []*model.TrendElement
+	err := s.db.NewSelect().
+		Model(&elements).
+		Where("server = ?", server).
+		Where("source_category = ?", sourceCategory).
+		Where("granularity = ?", granularity).
+		Scan(ctx)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	} else if err != nil {
@@ -18,6 +18,48 @@ type V2 struct {
 
 type V3 struct {
 	fiber.Router
+
+	acceptOptOuts []acceptOptOut
+}
+
+// acceptOptOut is a route of the v3 API exempted from the Accept header opt-in.
+type acceptOptOut struct {
+	method   string
+	segments []string
+}
+
+// AcceptOptOut registers a route exempted from the v3 Accept header opt-in, for clients that are unable to set
+// the Accept header, such as the WebSockets, EventSources and beacons of browsers.
+func (v *V3) AcceptOptOut(method, path string, handlers ...fiber.Handler) fiber.Router {
+	v.acceptOptOuts = append(v.acceptOptOuts, acceptOptOut{method: method, segments: routeSegments(path)})
+	return v.Add(method, path, handlers...)
+}
+
+func (v *V3) optedOut(c *fiber.Ctx) bool {
+	segments := routeSegments(strings.TrimPrefix(c.Path(), v3Prefix))
+	for _, route := range v.acceptOptOuts {
+		if route.matches(c.Method(), segments) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r acceptOptOut) matches(method string, segments []string) bool {
+	if r.method != method || len(r.segments) != len(segments) {
+		return false
+	}
+	for i, segment := range r.segments {
+		// parameters match any segment
+		if !strings.HasPrefix(segment, ":") && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func routeSegments(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
 }
 
 type Admin struct {
@@ -28,6 +70,11 @@ type Meta struct {
 	fiber.Router
 }
 
+const v3Prefix = "/api/v3alpha"
+
+// MIMEV3 is the media type clients opt in to the v3 API with in the Accept header.
+const MIMEV3 = "application/vnd.penguin.v3+json"
+
 func CreateEndpointGroups(app *fiber.App, conf *appconfig.Config) (*V2, *V3, *Admin, *Meta) {
 	v2 := app.Group("/PenguinStats/api/v2", func(c *fiber.Ctx) error {
 		// add compatibility versioning header for v2 shims
@@ -35,13 +82,18 @@ func CreateEndpointGroups(app *fiber.App, conf *appconfig.Config) (*V2, *V3, *Ad
 		return c.Next()
 	})
 
-	v3 := app.Group("/api/v3alpha", func(c *fiber.Ctx) error {
+	v3 := &V3{}
+	v3.Router = app.Group(v3Prefix, func(c *fiber.Ctx) error {
 		msg := "The v3 API is in alpha and may change in the future. Please report any issues and/or suggestions to https://github.com/penguin-statistics/backend-next/issues."
 		c.Set("X-Penguin-Notes", msg)
 
+		if v3.optedOut(c) {
+			return c.Next()
+		}
+
 		accepts := c.Get(fiber.HeaderAccept)
-		if !strings.Contains(accepts, "application/vnd.penguin.v3+json") {
-			return pgerr.ErrInvalidReq.Msg(msg + " To use the v3 API, please use the application/vnd.penguin.v3+json Accept header to explicitly opt-in to the alpha version of API.")
+		if !strings.Contains(accepts, MIMEV3) {
+			return pgerr.ErrInvalidReq.Msg(msg + " To use the v3 API, please use the " + MIMEV3 + " Accept header to explicitly opt-in to the alpha version of API.")
 		}
 
 		return c.Next()
@@ -63,5 +115,5 @@ func CreateEndpointGroups(app *fiber.App, conf *appconfig.Config) (*V2, *V3, *Ad
 
 	meta := app.Group("/api/_")
 
-	return &V2{Router: v2}, &V3{Router: v3}, &Admin{Router: admin}, &Meta{Router: meta}
+	return &V2{Router: v2}, v3, &Admin{Router: admin}, &Meta{Router: meta}
 }
@@ -8,17 +8,23 @@ func Module() fx.Option {
 	return fx.Module("service", fx.Provide(
 		NewItem,
 		NewInit,
+		NewQuery,
 		NewZone,
 		NewStage,
 		NewGeoIP,
 		NewTrend,
 		NewAdmin,
 		NewUpyun,
+		NewProbe,
 		NewHealth,
 		NewNotice,
 		NewReport,
+		NewWarmUp,
 		NewAccount,
 		NewFormula,
+		NewLiveHub,
+		NewSession,
+		NewQueryJob,
 		NewActivity,
 		NewDropInfo,
 		NewShortURL,
@@ -27,11 +33,16 @@ func Module() fx.Option {
 		NewLiveHouse,
 		NewSiteStats,
 		NewTimeRange,
+		NewAccountJob,
 		NewDropMatrix,
 		NewDropReport,
 		NewTrendElement,
+		NewBiasDetection,
 		NewPatternMatrix,
 		NewFrontendConfig,
+		NewSnapshotManifest,
+		NewLiveHouseEmbedded,
+		NewCacheInvalidation,
 		NewDropMatrixElement,
 		NewDropPatternElement,
 		NewPatternMatrixElement,
@@ -2,25 +2,36 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
 
 	"exusiai.dev/backend-next/internal/model"
 	"exusiai.dev/backend-next/internal/model/cache"
+	"exusiai.dev/backend-next/internal/model/types"
 	"exusiai.dev/backend-next/internal/pkg/flog"
 	"exusiai.dev/backend-next/internal/pkg/pgerr"
 	"exusiai.dev/backend-next/internal/pkg/pgid"
 	"exusiai.dev/backend-next/internal/repo"
 )
 
+var ErrAccountBanned = pgerr.New(http.StatusForbidden, "ACCOUNT_BANNED", "this account has been banned from submitting reports")
+
 type Account struct {
-	AccountRepo *repo.Account
+	AccountRepo              *repo.Account
+	SessionService           *Session
+	CacheInvalidationService *CacheInvalidation
 }
 
-func NewAccount(accountRepo *repo.Account) *Account {
+func NewAccount(accountRepo *repo.Account, sessionService *Session, cacheInvalidationService *CacheInvalidation) *Account {
 	return &Account{
-		AccountRepo: accountRepo,
+		AccountRepo:              accountRepo,
+		SessionService:           sessionService,
+		CacheInvalidationService: cacheInvalidationService,
 	}
 }
 
@@ -64,7 +75,26 @@ func (s *Account) IsAccountExistWithId(ctx context.Context, accountId int) bool
 	return s.AccountRepo.IsAccountExistWithId(ctx, accountId)
 }
 
-func (s *Account) GetAccountFromRequest(ctx *fiber.Ctx) (*model.Account, error) {
+// GetAccountFromRequest returns the account authenticated by the request, either with a session token granting
+// scope or with a PenguinID, which grants every scope.
+func (s *Account) GetAccountFromRequest(ctx *fiber.Ctx, scope string) (*model.Account, error) {
+	// get session token from HTTP header in form of Authorization: Bearer pgt1.xxxxxxxx
+	if token := pgid.ExtractToken(ctx); token != "" {
+		accountId, err := s.SessionService.Verify(ctx.UserContext(), token, scope)
+		if err != nil {
+			flog.WarnFrom(ctx, "account.invalid.token").
+				Err(err).
+				Str("scope", scope).
+				Msg("failed to get account from request")
+			return nil, err
+		}
+		account, err := s.GetAccountById(ctx.UserContext(), strconv.Itoa(accountId))
+		if err != nil {
+			return nil, ErrSessionTokenInvalid
+		}
+		return account, nil
+	}
+
 	// get PenguinID from HTTP header in form of Authorization: PenguinID ########
 	penguinId := pgid.Extract(ctx)
 	if penguinId == "" {
@@ -82,3 +112,136 @@ func (s *Account) GetAccountFromRequest(ctx *fiber.Ctx) (*model.Account, error)
 	}
 	return account, nil
 }
+
+// CheckReportable returns ErrAccountBanned if the account is banned from submitting reports.
+func (s *Account) CheckReportable(account *model.Account) error {
+	if account.Status == model.AccountStatusBanned {
+		return ErrAccountBanned
+	}
+	return nil
+}
+
+// MergeAccounts merges the account of sourcePenguinId into the account of targetPenguinId: the drop reports and
+// recognition defects of the source account are transferred to the target account, and the source account is
+// deleted. Personal matrices are calculated from the drop reports on every request, so only the cached accounts
+// need to be evicted, on every instance. The target account takes the stricter status of both, so that a banned or
+// shadowbanned account cannot be laundered by merging it into a clean one.
+func (s *Account) MergeAccounts(ctx context.Context, sourcePenguinId, targetPenguinId, initiator string) (*model.AccountMerge, error) {
+	if sourcePenguinId == targetPenguinId {
+		return nil, pgerr.ErrInvalidReq.Msg("cannot merge an account into itself")
+	}
+	source, err := s.AccountRepo.GetAccountByPenguinId(ctx, sourcePenguinId)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.AccountRepo.GetAccountByPenguinId(ctx, targetPenguinId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if accountStatusSeverity(source.Status) > accountStatusSeverity(target.Status) {
+		target.Status = source.Status
+		target.ModerationReason = fmt.Sprintf("carried over from merged account %d", source.AccountID)
+		if source.ModerationReason != "" {
+			target.ModerationReason += ": " + source.ModerationReason
+		}
+		target.ModeratedAt = &now
+	}
+
+	merge := &model.AccountMerge{
+		SourceAccountID: source.AccountID,
+		SourcePenguinID: source.PenguinID,
+		TargetAccountID: target.AccountID,
+		TargetPenguinID: target.PenguinID,
+		Initiator:       initiator,
+		CreatedAt:       now,
+	}
+	if err := s.AccountRepo.MergeAccount(ctx, merge, target); err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("evt.name", "account.merged").
+		Int("sourceAccountId", merge.SourceAccountID).
+		Int("targetAccountId", merge.TargetAccountID).
+		Int("reports", merge.Reports).
+		Int("defects", merge.Defects).
+		Str("initiator", initiator).
+		Msg("account merged")
+
+	s.evictAccounts(ctx, source, target)
+	if _, err := s.SessionService.RevokeAccount(ctx, source.AccountID); err != nil {
+		log.Warn().
+			Str("evt.name", "account.merged.revoke.failed").
+			Err(err).
+			Msg("failed to revoke sessions of merged account")
+	}
+
+	return merge, nil
+}
+
+// accountStatusSeverity orders the account statuses from the least strict to the strictest.
+func accountStatusSeverity(status string) int {
+	switch status {
+	case model.AccountStatusBanned:
+		return 2
+	case model.AccountStatusShadowbanned:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ModerateAccount sets the status and/or the trust score of the account as requested, recording the reason.
+func (s *Account) ModerateAccount(ctx context.Context, accountId int, req *types.ModerateAccountRequest) (*model.Account, error) {
+	if req.Status == nil && req.TrustScore == nil {
+		return nil, pgerr.ErrInvalidReq.Msg("either status or trustScore is required")
+	}
+	account, err := s.AccountRepo.GetAccountById(ctx, strconv.Itoa(accountId))
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Status != nil {
+		account.Status = *req.Status
+	}
+	if req.TrustScore != nil {
+		account.TrustScore = *req.TrustScore
+	}
+	now := time.Now()
+	account.ModerationReason = req.Reason
+	account.ModeratedAt = &now
+	if err := s.AccountRepo.UpdateAccountModeration(ctx, account); err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("evt.name", "account.moderated").
+		Int("accountId", account.AccountID).
+		Str("status", account.Status).
+		Int("trustScore", account.TrustScore).
+		Str("reason", req.Reason).
+		Msg("account moderated")
+
+	s.evictAccounts(ctx, account)
+	return account, nil
+}
+
+// evictAccounts evicts the accounts from the account caches on every instance. Failures are only logged, as the
+// cached accounts expire within an hour anyway.
+func (s *Account) evictAccounts(ctx context.Context, accounts ...*model.Account) {
+	evictions := make([]types.CacheEvictRequest, 0, len(accounts)*2)
+	for _, account := range accounts {
+		evictions = append(evictions,
+			types.CacheEvictRequest{Name: "account#accountId", Key: strconv.Itoa(account.AccountID)},
+			types.CacheEvictRequest{Name: "account#penguinId", Key: account.PenguinID},
+		)
+	}
+	if _, _, err := s.CacheInvalidationService.Evict(ctx, evictions); err != nil {
+		log.Warn().
+			Str("evt.name", "account.evict.failed").
+			Err(err).
+			Msg("failed to evict accounts from cache")
+	}
+}
@@ -0,0 +1,490 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/goccy/go-json"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+	"gopkg.in/guregu/null.v3"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model"
+	modelv3 "exusiai.dev/backend-next/internal/model/v3"
+	"exusiai.dev/backend-next/internal/pkg/jobqueue"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/repo"
+)
+
+const (
+	AccountJobRedisPrefix = "account-job:"
+	// AccountJobQueueName names the queue of account jobs, kept out of AccountJobRedisPrefix so that its keys never
+	// collide with the job records.
+	AccountJobQueueName = "account-job-queue"
+
+	AccountDeletionPolicyAnonymize = "anonymize"
+	AccountDeletionPolicyDelete    = "delete"
+
+	// accountExportPageSize is the number of drop reports read at a time while exporting an account.
+	accountExportPageSize = 1000
+
+	// accountArchiveChunkSize is the size of the chunks an export archive is kept in Redis as.
+	accountArchiveChunkSize = 256 * 1024
+)
+
+var (
+	ErrAccountJobQueueFull   = pgerr.New(http.StatusServiceUnavailable, "ACCOUNT_JOB_QUEUE_FULL", "too many account jobs are waiting to be run at the moment. please try again later")
+	ErrAccountExportNotReady = pgerr.New(http.StatusConflict, "ACCOUNT_EXPORT_NOT_READY", "the export job has not succeeded yet")
+)
+
+// accountJobRecord is the representation of an account job in Redis. AccountID is kept out of the job itself
+// so that it never leaks to the client, and is used to restrict export jobs to their owners.
+type accountJobRecord struct {
+	modelv3.AccountJob
+	AccountID int `json:"accountId"`
+}
+
+// AccountJob runs the export and deletion jobs requested by accounts for their own data, on a worker pool.
+// Job status and export archives are kept in Redis so that they can be polled and downloaded from any instance.
+// Jobs are queued in Redis as well, and jobs interrupted by a shutdown are run again by the next instance to pick
+// them up. Export archives are written to Redis in chunks as they are compressed, and streamed from there.
+type AccountJob struct {
+	Redis                  *redis.Client
+	AccountService         *Account
+	AccountRepo            *repo.Account
+	DropReportRepo         *repo.DropReport
+	DropReportExtraRepo    *repo.DropReportExtra
+	DropPatternElementRepo *repo.DropPatternElement
+	RecognitionDefectRepo  *repo.RecognitionDefect
+
+	policy    string
+	timeout   time.Duration
+	resultTTL time.Duration
+	q         *jobqueue.Queue
+}
+
+func NewAccountJob(
+	redisClient *redis.Client,
+	accountService *Account,
+	accountRepo *repo.Account,
+	dropReportRepo *repo.DropReport,
+	dropReportExtraRepo *repo.DropReportExtra,
+	dropPatternElementRepo *repo.DropPatternElement,
+	recognitionDefectRepo *repo.RecognitionDefect,
+	conf *appconfig.Config,
+	lc fx.Lifecycle,
+) (*AccountJob, error) {
+	if conf.AccountDeletionPolicy != AccountDeletionPolicyAnonymize && conf.AccountDeletionPolicy != AccountDeletionPolicyDelete {
+		return nil, errors.Errorf("service: account job: unknown deletion policy %q", conf.AccountDeletionPolicy)
+	}
+
+	s := &AccountJob{
+		Redis:                  redisClient,
+		AccountService:         accountService,
+		AccountRepo:            accountRepo,
+		DropReportRepo:         dropReportRepo,
+		DropReportExtraRepo:    dropReportExtraRepo,
+		DropPatternElementRepo: dropPatternElementRepo,
+		RecognitionDefectRepo:  recognitionDefectRepo,
+		policy:                 conf.AccountDeletionPolicy,
+		timeout:                conf.AccountJobTimeout,
+		resultTTL:              conf.AccountJobResultTTL,
+	}
+	s.q = jobqueue.New(redisClient, AccountJobQueueName, conf.AccountJobQueueSize, conf.AccountJobWorkers, s.run)
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			s.q.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return s.q.Stop(ctx)
+		},
+	})
+
+	return s, nil
+}
+
+// Submit enqueues a job of kind for the data of account, and returns the created job.
+func (s *AccountJob) Submit(ctx context.Context, account *model.Account, kind string) (*modelv3.AccountJob, error) {
+	record := &accountJobRecord{
+		AccountJob: modelv3.AccountJob{
+			JobID:     strings.ToLower(ulid.Make().String()),
+			Kind:      kind,
+			Status:    modelv3.QueryJobStatusQueued,
+			CreatedAt: time.Now().UnixMilli(),
+		},
+		AccountID: account.AccountID,
+	}
+	if err := s.save(ctx, record); err != nil {
+		return nil, err
+	}
+
+	if err := s.q.Push(ctx, record.JobID); err != nil {
+		s.Redis.Del(ctx, AccountJobRedisPrefix+record.JobID)
+		if errors.Is(err, jobqueue.ErrFull) {
+			return nil, ErrAccountJobQueueFull
+		}
+		return nil, err
+	}
+
+	log.Info().
+		Str("evt.name", "account.job.enqueued").
+		Str("jobId", record.JobID).
+		Str("kind", kind).
+		Int("accountId", account.AccountID).
+		Msg("account job enqueued")
+
+	return &record.AccountJob, nil
+}
+
+// GetJob returns the account job with the given id. Export jobs are only visible to their owners, while deletion
+// jobs are visible to anyone knowing their ids, as their accounts can no longer be presented once they succeed.
+func (s *AccountJob) GetJob(ctx context.Context, jobId string, accountId null.Int) (*modelv3.AccountJob, error) {
+	record, err := s.get(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	if record.Kind == modelv3.AccountJobKindExport && (!accountId.Valid || int64(record.AccountID) != accountId.Int64) {
+		return nil, pgerr.ErrNotFound
+	}
+	return &record.AccountJob, nil
+}
+
+// GetArchive returns a function writing the gzip-compressed JSON archive of a succeeded export job of the account
+// to w, chunk by chunk.
+func (s *AccountJob) GetArchive(ctx context.Context, jobId string, accountId int) (func(w io.Writer) error, error) {
+	record, err := s.get(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	if record.Kind != modelv3.AccountJobKindExport || record.AccountID != accountId {
+		return nil, pgerr.ErrNotFound
+	}
+	if record.Status != modelv3.QueryJobStatusSucceeded {
+		return nil, ErrAccountExportNotReady
+	}
+
+	key := s.archiveKey(jobId)
+	chunks, err := s.Redis.LLen(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if chunks == 0 {
+		return nil, pgerr.ErrNotFound
+	}
+
+	return func(w io.Writer) error {
+		// the request context is done once the handler has returned, so chunks are read with a context of their own
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		for i := int64(0); i < chunks; i++ {
+			chunk, err := s.Redis.LIndex(ctx, key, i).Bytes()
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// run runs the account job with the given id. Jobs interrupted by a shutdown are saved as queued again, as they
+// are put back to the queue.
+func (s *AccountJob) run(parent context.Context, jobId string) {
+	ctx, cancel := context.WithTimeout(parent, s.timeout)
+	defer cancel()
+
+	record, err := s.get(ctx, jobId)
+	if err != nil {
+		if !errors.Is(err, pgerr.ErrNotFound) {
+			log.Error().
+				Str("evt.name", "account.job.failed").
+				Str("jobId", jobId).
+				Err(err).
+				Msg("failed to get account job")
+		}
+		return
+	}
+	if record.Status != modelv3.QueryJobStatusQueued && record.Status != modelv3.QueryJobStatusRunning {
+		return
+	}
+
+	record.Status = modelv3.QueryJobStatusRunning
+	record.StartedAt = null.IntFrom(time.Now().UnixMilli())
+	if err := s.save(ctx, record); err != nil {
+		log.Error().
+			Str("evt.name", "account.job.failed").
+			Str("jobId", record.JobID).
+			Err(err).
+			Msg("failed to update account job status")
+		return
+	}
+
+	switch record.Kind {
+	case modelv3.AccountJobKindExport:
+		err = s.export(ctx, record)
+	case modelv3.AccountJobKindDeletion:
+		record.Deletion, err = s.delete(ctx, record.AccountID)
+	}
+	record.FinishedAt = null.IntFrom(time.Now().UnixMilli())
+	if err != nil && parent.Err() != nil {
+		record.Status = modelv3.QueryJobStatusQueued
+		record.StartedAt = null.Int{}
+		record.FinishedAt = null.Int{}
+	} else if err != nil {
+		log.Error().
+			Str("evt.name", "account.job.failed").
+			Str("jobId", record.JobID).
+			Err(err).
+			Msg("account job failed")
+
+		record.Status = modelv3.QueryJobStatusFailed
+		if errors.Is(err, context.DeadlineExceeded) {
+			record.Error = null.StringFrom("account job timed out after " + s.timeout.String())
+		} else {
+			record.Error = null.StringFrom("an unexpected error occurred while running the account job")
+		}
+	} else {
+		record.Status = modelv3.QueryJobStatusSucceeded
+	}
+
+	// the job context might have been exceeded already, so the final status is saved with a fresh one
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer saveCancel()
+	if record.Kind == modelv3.AccountJobKindExport && record.Status != modelv3.QueryJobStatusSucceeded {
+		s.Redis.Del(saveCtx, s.archiveKey(record.JobID))
+	}
+	if err := s.save(saveCtx, record); err != nil {
+		log.Error().
+			Str("evt.name", "account.job.failed").
+			Str("jobId", record.JobID).
+			Err(err).
+			Msg("failed to save account job status")
+	}
+}
+
+// export writes the account, its drop reports along with their extras, the drop pattern elements of the
+// reports and its recognition defects as a gzip-compressed JSON archive, reading the reports a page at a time.
+// The archive is written to Redis in chunks as it is compressed, replacing the chunks of an interrupted run.
+func (s *AccountJob) export(ctx context.Context, record *accountJobRecord) error {
+	account, err := s.AccountRepo.GetAccountById(ctx, strconv.Itoa(record.AccountID))
+	if err != nil {
+		return err
+	}
+
+	chunks := &archiveChunkWriter{ctx: ctx, redis: s.Redis, key: s.archiveKey(record.JobID), ttl: s.resultTTL}
+	if err := s.Redis.Del(ctx, chunks.key).Err(); err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(chunks)
+	w := &archiveWriter{w: zw}
+
+	w.raw(`{"exportedAt":`)
+	w.value(time.Now().UnixMilli())
+	w.raw(`,"account":`)
+	w.value(account)
+	w.raw(`,"reports":[`)
+
+	patternIds := make([]int, 0)
+	seenPatterns := make(map[int]struct{})
+	afterReportId := 0
+	for first := true; ; {
+		reports, err := s.DropReportRepo.GetDropReportsByAccountId(ctx, account.AccountID, afterReportId, accountExportPageSize)
+		if err != nil {
+			return err
+		}
+		reportIds := make([]int, len(reports))
+		for i, r := range reports {
+			reportIds[i] = r.ReportID
+		}
+		extras, err := s.DropReportExtraRepo.GetDropReportExtrasByIds(ctx, reportIds)
+		if err != nil {
+			return err
+		}
+		extrasById := make(map[int]*model.DropReportExtra, len(extras))
+		for _, e := range extras {
+			extrasById[e.ReportID] = e
+		}
+
+		for _, r := range reports {
+			if !first {
+				w.raw(",")
+			}
+			first = false
+			w.value(&modelv3.AccountExportReport{DropReport: r, Extra: extrasById[r.ReportID]})
+			if _, ok := seenPatterns[r.PatternID]; !ok {
+				seenPatterns[r.PatternID] = struct{}{}
+				patternIds = append(patternIds, r.PatternID)
+			}
+		}
+		if w.err != nil {
+			return w.err
+		}
+		if len(reports) < accountExportPageSize {
+			break
+		}
+		afterReportId = reports[len(reports)-1].ReportID
+	}
+
+	elements, err := s.DropPatternElementRepo.GetDropPatternElementsByPatternIds(ctx, patternIds)
+	if err != nil {
+		return err
+	}
+	defects, err := s.RecognitionDefectRepo.GetDefectReportsByAccountId(ctx, account.AccountID)
+	if err != nil {
+		return err
+	}
+	w.raw(`],"dropPatternElements":`)
+	w.value(elements)
+	w.raw(`,"recognitionDefects":`)
+	w.value(defects)
+	w.raw(`}`)
+	if w.err != nil {
+		return w.err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return chunks.Close()
+}
+
+// delete anonymizes or deletes the account as per the deletion policy, evicts it from the account caches and
+// revokes its sessions. An account already gone has been deleted by an interrupted run of the job.
+func (s *AccountJob) delete(ctx context.Context, accountId int) (*modelv3.AccountDeletionResult, error) {
+	account, err := s.AccountRepo.GetAccountById(ctx, strconv.Itoa(accountId))
+	if errors.Is(err, pgerr.ErrNotFound) {
+		return &modelv3.AccountDeletionResult{Policy: s.policy}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// sessions are revoked first, so that an anonymized account can never be reached by its tokens
+	if _, err := s.AccountService.SessionService.RevokeAccount(ctx, account.AccountID); err != nil {
+		return nil, err
+	}
+
+	var result *modelv3.AccountDeletionResult
+	switch s.policy {
+	case AccountDeletionPolicyDelete:
+		result, err = s.AccountRepo.DeleteAccount(ctx, account.AccountID)
+	default:
+		result, err = s.AccountRepo.AnonymizeAccount(ctx, account.AccountID, "deleted:"+strings.ToLower(ulid.Make().String()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Policy = s.policy
+
+	log.Info().
+		Str("evt.name", "account.deleted").
+		Int("accountId", account.AccountID).
+		Str("policy", s.policy).
+		Int("reports", result.Reports).
+		Int("defects", result.Defects).
+		Int("purgedIps", result.PurgedIPs).
+		Msg("account deleted")
+
+	s.AccountService.evictAccounts(ctx, account)
+	return result, nil
+}
+
+func (s *AccountJob) get(ctx context.Context, jobId string) (*accountJobRecord, error) {
+	b, err := s.Redis.Get(ctx, AccountJobRedisPrefix+jobId).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, pgerr.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var record accountJobRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *AccountJob) save(ctx context.Context, record *accountJobRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.Redis.Set(ctx, AccountJobRedisPrefix+record.JobID, b, s.resultTTL).Err()
+}
+
+func (s *AccountJob) archiveKey(jobId string) string {
+	return AccountJobRedisPrefix + jobId + ":archive"
+}
+
+// archiveChunkWriter appends what is written to a Redis list in chunks of accountArchiveChunkSize.
+type archiveChunkWriter struct {
+	ctx   context.Context
+	redis *redis.Client
+	key   string
+	ttl   time.Duration
+	buf   []byte
+}
+
+func (w *archiveChunkWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= accountArchiveChunkSize {
+		if err := w.push(w.buf[:accountArchiveChunkSize]); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[accountArchiveChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close appends the remaining bytes as the last chunk.
+func (w *archiveChunkWriter) Close() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	return w.push(w.buf)
+}
+
+func (w *archiveChunkWriter) push(chunk []byte) error {
+	// the archive expires along with its job, even if the job is interrupted before finishing it
+	_, err := w.redis.TxPipelined(w.ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(w.ctx, w.key, chunk)
+		pipe.Expire(w.ctx, w.key, w.ttl)
+		return nil
+	})
+	return err
+}
+
+// archiveWriter writes a JSON document piece by piece, keeping the first error occurred.
+type archiveWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *archiveWriter) raw(s string) {
+	if w.err == nil {
+		_, w.err = io.WriteString(w.w, s)
+	}
+}
+
+func (w *archiveWriter) value(v any) {
+	if w.err != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		w.err = err
+		return
+	}
+	_, w.err = w.w.Write(b)
+}
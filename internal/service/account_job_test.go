@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/guregu/null.v3"
+
+	"exusiai.dev/backend-next/internal/model"
+	modelv3 "exusiai.dev/backend-next/internal/model/v3"
+	"exusiai.dev/backend-next/internal/pkg/jobqueue"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+)
+
+func TestAccountJobArchiveChunks(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := &AccountJob{
+		Redis:     redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		timeout:   time.Minute,
+		resultTTL: time.Hour,
+	}
+	ctx := context.Background()
+
+	record := &accountJobRecord{
+		AccountJob: modelv3.AccountJob{JobID: "job", Kind: modelv3.AccountJobKindExport, Status: modelv3.QueryJobStatusRunning},
+		AccountID:  1,
+	}
+	require.NoError(t, s.save(ctx, record))
+
+	archive := make([]byte, accountArchiveChunkSize*2+1000)
+	rand.New(rand.NewSource(1)).Read(archive)
+	w := &archiveChunkWriter{ctx: ctx, redis: s.Redis, key: s.archiveKey(record.JobID), ttl: s.resultTTL}
+	for i := 0; i < len(archive); i += 1000 {
+		end := i + 1000
+		if end > len(archive) {
+			end = len(archive)
+		}
+		_, err := w.Write(archive[i:end])
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	chunks, err := mr.List(s.archiveKey(record.JobID))
+	require.NoError(t, err)
+	assert.Len(t, chunks, 3)
+	assert.Greater(t, mr.TTL(s.archiveKey(record.JobID)), time.Duration(0))
+
+	_, err = s.GetArchive(ctx, record.JobID, record.AccountID)
+	assert.ErrorIs(t, err, ErrAccountExportNotReady)
+
+	record.Status = modelv3.QueryJobStatusSucceeded
+	require.NoError(t, s.save(ctx, record))
+
+	_, err = s.GetArchive(ctx, record.JobID, 2)
+	assert.ErrorIs(t, err, pgerr.ErrNotFound, "archives should only be visible to their owners")
+
+	writeArchive, err := s.GetArchive(ctx, record.JobID, record.AccountID)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, writeArchive(&buf))
+	assert.Equal(t, archive, buf.Bytes())
+}
+
+func TestAccountJobQueueKeysAreNotJobs(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := &AccountJob{
+		Redis:     redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		timeout:   time.Minute,
+		resultTTL: time.Hour,
+	}
+	s.q = jobqueue.New(s.Redis, AccountJobQueueName, 10, 0, s.run)
+	ctx := context.Background()
+
+	job, err := s.Submit(ctx, &model.Account{AccountID: 1}, modelv3.AccountJobKindExport)
+	require.NoError(t, err)
+
+	for _, key := range mr.Keys() {
+		if key != AccountJobRedisPrefix+job.JobID {
+			assert.NotContains(t, key, AccountJobRedisPrefix)
+		}
+	}
+	for _, jobId := range []string{"queue", "running"} {
+		_, err = s.GetJob(ctx, jobId, null.IntFrom(1))
+		assert.ErrorIs(t, err, pgerr.ErrNotFound)
+	}
+}
@@ -10,11 +10,12 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/uptrace/bun"
+	"gopkg.in/guregu/null.v3"
 
 	"exusiai.dev/backend-next/internal/model"
-	"exusiai.dev/backend-next/internal/model/cache"
 	"exusiai.dev/backend-next/internal/model/gamedata"
 	"exusiai.dev/backend-next/internal/model/types"
+	"exusiai.dev/backend-next/internal/pkg/cachectrl"
 	"exusiai.dev/backend-next/internal/repo"
 	"exusiai.dev/backend-next/internal/util/reportverifs"
 )
@@ -24,18 +25,22 @@ type Admin struct {
 	AdminRepo         *repo.Admin
 	DropReportService *DropReport
 	RejectRuleRepo    *repo.RejectRule
+
+	CacheInvalidationService *CacheInvalidation
 }
 
-func NewAdmin(db *bun.DB, adminRepo *repo.Admin, dropReportService *DropReport, rejectRuleRepo *repo.RejectRule) *Admin {
+func NewAdmin(db *bun.DB, adminRepo *repo.Admin, dropReportService *DropReport, rejectRuleRepo *repo.RejectRule, cacheInvalidationService *CacheInvalidation) *Admin {
 	return &Admin{
-		DB:                db,
-		AdminRepo:         adminRepo,
-		DropReportService: dropReportService,
-		RejectRuleRepo:    rejectRuleRepo,
+		DB:                       db,
+		AdminRepo:                adminRepo,
+		DropReportService:        dropReportService,
+		RejectRuleRepo:           rejectRuleRepo,
+		CacheInvalidationService: cacheInvalidationService,
 	}
 }
 
-func (s *Admin) SaveRenderedObjects(ctx context.Context, objects *gamedata.RenderedObjects) error {
+// SaveRenderedObjects saves the rendered objects and invalidates the affected caches on every instance.
+func (s *Admin) SaveRenderedObjects(ctx context.Context, objects *gamedata.RenderedObjects) (*CacheInvalidationResult, error) {
 	var innerErr error
 	err := s.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
 		var zoneId int
@@ -103,42 +108,67 @@ func (s *Admin) SaveRenderedObjects(ctx context.Context, objects *gamedata.Rende
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if innerErr != nil {
+		return nil, innerErr
 	}
 
 	// if no error, purge cache
-	if innerErr == nil {
-		// zone
-		if objects.Zone != nil {
-			cache.Zones.Delete()
-			cache.ShimZones.Delete()
-		}
+	pairs := make([]types.PurgeCachePair, 0)
+
+	// zone
+	if objects.Zone != nil {
+		pairs = append(pairs,
+			types.PurgeCachePair{Name: "zones"},
+			types.PurgeCachePair{Name: "shimZones"},
+		)
+	}
 
-		// activity
-		if objects.Activity != nil {
-			cache.Activities.Delete()
-			cache.ShimActivities.Delete()
-		}
+	// activity
+	if objects.Activity != nil {
+		pairs = append(pairs,
+			types.PurgeCachePair{Name: "activities"},
+			types.PurgeCachePair{Name: "shimActivities"},
+		)
+	}
 
-		// timerange
-		if objects.TimeRange != nil {
-			cache.TimeRanges.Delete(objects.TimeRange.Server)
-			cache.TimeRangesMap.Delete(objects.TimeRange.Server)
-			cache.MaxAccumulableTimeRanges.Delete(objects.TimeRange.Server)
-		}
+	// timerange
+	if objects.TimeRange != nil {
+		server := null.StringFrom(objects.TimeRange.Server)
+		pairs = append(pairs,
+			types.PurgeCachePair{Name: "timeRanges#server", Key: server},
+			types.PurgeCachePair{Name: "timeRangesMap#server", Key: server},
+			types.PurgeCachePair{Name: "maxAccumulableTimeRanges#server", Key: server},
+		)
+	}
 
-		// stage
-		if len(objects.Stages) > 0 {
-			cache.Stages.Delete()
-			cache.StagesMapByID.Delete()
-			cache.StagesMapByArkID.Delete()
-			for _, server := range constant.Servers {
-				cache.ShimStages.Delete(server)
-			}
+	// stage
+	tags := make([]string, 0)
+	if len(objects.Stages) > 0 {
+		for _, stage := range objects.Stages {
+			tags = append(tags, cachectrl.StageTag(stage.ArkStageID))
+		}
+		pairs = append(pairs,
+			types.PurgeCachePair{Name: "stages"},
+			types.PurgeCachePair{Name: "stagesMapById"},
+			types.PurgeCachePair{Name: "stagesMapByArkId"},
+		)
+		for _, server := range constant.Servers {
+			pairs = append(pairs, types.PurgeCachePair{Name: "shimStages#server", Key: null.StringFrom(server)})
 		}
 	}
 
-	return innerErr
+	// the objects have been saved already, so a failed invalidation is reported in the result instead of as an error
+	result, err := s.CacheInvalidationService.Invalidate(ctx, pairs, tags...)
+	if err != nil {
+		log.Error().
+			Str("evt.name", "admin.save_rendered_objects.invalidation_failed").
+			Err(err).
+			Msg("saved rendered objects but failed to invalidate caches")
+		return &CacheInvalidationResult{FlushError: err.Error()}, nil
+	}
+	return result, nil
 }
 
 func (s *Admin) GetRejectRulesReportContext(ctx context.Context, req types.RejectRulesReevaluationPreviewRequest) ([]RejectRulesReevaluationEvaluationContext, error) {
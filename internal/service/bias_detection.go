@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"exusiai.dev/gommon/constant"
+	"github.com/go-redis/redis/v8"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/repo"
+)
+
+const BiasReportRedisPrefix = "bias-report:"
+
+type biasSource struct {
+	name    string
+	version string
+}
+
+type biasAccumulator struct {
+	times           int
+	quantity        int
+	squaredQuantity int
+}
+
+// BiasDetection compares the drop rates of automated and manual reports per stage and item, and flags the
+// statistically significant divergences, which most likely are caused by recognizer bugs or selective reporting.
+// Reports are stored in Redis, as they are calculated by the worker but served by the API instances.
+type BiasDetection struct {
+	Redis          *redis.Client
+	DropReportRepo *repo.DropReport
+	StageService   *Stage
+	ItemService    *Item
+
+	window     time.Duration
+	minTimes   int
+	zThreshold float64
+}
+
+func NewBiasDetection(redisClient *redis.Client, dropReportRepo *repo.DropReport, stageService *Stage, itemService *Item, conf *appconfig.Config) *BiasDetection {
+	return &BiasDetection{
+		Redis:          redisClient,
+		DropReportRepo: dropReportRepo,
+		StageService:   stageService,
+		ItemService:    itemService,
+		window:         conf.BiasDetectionWindow,
+		minTimes:       conf.BiasDetectionMinTimes,
+		zThreshold:     conf.BiasDetectionZThreshold,
+	}
+}
+
+// GetBiasReport returns the latest bias report of the server.
+func (s *BiasDetection) GetBiasReport(ctx context.Context, server string) (*model.BiasReport, error) {
+	b, err := s.Redis.Get(ctx, BiasReportRedisPrefix+server).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, pgerr.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var report model.BiasReport
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// RefreshBiasReport detects the biases of the server over the configured time window, and saves the report as the latest one.
+func (s *BiasDetection) RefreshBiasReport(ctx context.Context, server string) (*model.BiasReport, error) {
+	end := time.Now()
+	start := end.Add(-s.window)
+
+	timesResults, err := s.DropReportRepo.CalcTotalTimesBySource(ctx, server, start, end)
+	if err != nil {
+		return nil, err
+	}
+	quantityResults, err := s.DropReportRepo.CalcTotalQuantityBySource(ctx, server, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := s.detect(ctx, timesResults, quantityResults)
+	if err != nil {
+		return nil, err
+	}
+	report.Server = server
+	report.StartTime = start.UnixMilli()
+	report.EndTime = end.UnixMilli()
+	report.GeneratedAt = time.Now().UnixMilli()
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Redis.Set(ctx, BiasReportRedisPrefix+server, b, 0).Err(); err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("evt.name", "analytics.bias.refreshed").
+		Str("server", server).
+		Int("divergences", len(report.Divergences)).
+		Msg("bias report refreshed")
+
+	return report, nil
+}
+
+func (s *BiasDetection) detect(ctx context.Context, timesResults []*model.TotalTimesResultBySource, quantityResults []*model.TotalQuantityResultBySource) (*model.BiasReport, error) {
+	stagesMapById, err := s.StageService.GetStagesMapById(ctx)
+	if err != nil {
+		return nil, err
+	}
+	itemsMapById, err := s.ItemService.GetItemsMapById(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// times are counted per stage, while quantities are summed per stage and item. the automated side is additionally
+	// kept per source, so that a divergence could be attributed to a specific recognizer version.
+	manualTimes := make(map[int]int)
+	automatedTimes := make(map[int]int)
+	sourceTimes := make(map[int]map[biasSource]int)
+	sourceTotalTimes := make(map[biasSource]int)
+	for _, r := range timesResults {
+		if isManualSource(r.SourceName) {
+			manualTimes[r.StageID] += r.TotalTimes
+			continue
+		}
+		automatedTimes[r.StageID] += r.TotalTimes
+		source := biasSource{name: r.SourceName, version: r.Version}
+		if _, ok := sourceTimes[r.StageID]; !ok {
+			sourceTimes[r.StageID] = make(map[biasSource]int)
+		}
+		sourceTimes[r.StageID][source] += r.TotalTimes
+		sourceTotalTimes[source] += r.TotalTimes
+	}
+
+	manualQuantities := make(map[int]map[int]*biasAccumulator)
+	automatedQuantities := make(map[int]map[int]*biasAccumulator)
+	sourceQuantities := make(map[int]map[int]map[biasSource]*biasAccumulator)
+	for _, r := range quantityResults {
+		if isManualSource(r.SourceName) {
+			accumulateBias(manualQuantities, r.StageID, r.ItemID, r)
+			continue
+		}
+		accumulateBias(automatedQuantities, r.StageID, r.ItemID, r)
+		source := biasSource{name: r.SourceName, version: r.Version}
+		if _, ok := sourceQuantities[r.StageID]; !ok {
+			sourceQuantities[r.StageID] = make(map[int]map[biasSource]*biasAccumulator)
+		}
+		accumulateBias(sourceQuantities[r.StageID], r.ItemID, source, r)
+	}
+
+	divergences := make([]*model.BiasDivergence, 0)
+	sourceDivergences := make(map[biasSource]int)
+	for stageId, mTimes := range manualTimes {
+		aTimes := automatedTimes[stageId]
+		if mTimes < s.minTimes || aTimes < s.minTimes {
+			continue
+		}
+		stage, ok := stagesMapById[stageId]
+		if !ok {
+			continue
+		}
+
+		itemIds := lo.Uniq(append(lo.Keys(manualQuantities[stageId]), lo.Keys(automatedQuantities[stageId])...))
+		for _, itemId := range itemIds {
+			item, ok := itemsMapById[itemId]
+			if !ok {
+				continue
+			}
+			manual := newBiasSample(mTimes, manualQuantities[stageId][itemId])
+			automated := newBiasSample(aTimes, automatedQuantities[stageId][itemId])
+			z := biasZScore(automated, manual)
+			if math.Abs(z) < s.zThreshold {
+				continue
+			}
+
+			divergence := &model.BiasDivergence{
+				StageID:   stage.ArkStageID,
+				ItemID:    item.ArkItemID,
+				Manual:    manual,
+				Automated: automated,
+				ZScore:    z,
+				Sources:   make([]*model.BiasSourceSample, 0),
+			}
+			for source, times := range sourceTimes[stageId] {
+				if times < s.minTimes {
+					continue
+				}
+				sample := newBiasSample(times, sourceQuantities[stageId][itemId][source])
+				sourceZ := biasZScore(sample, manual)
+				significant := math.Abs(sourceZ) >= s.zThreshold
+				if significant {
+					sourceDivergences[source]++
+				}
+				divergence.Sources = append(divergence.Sources, &model.BiasSourceSample{
+					SourceName:  source.name,
+					Version:     source.version,
+					BiasSample:  *sample,
+					ZScore:      sourceZ,
+					Significant: significant,
+				})
+			}
+			sort.Slice(divergence.Sources, func(i, j int) bool {
+				return math.Abs(divergence.Sources[i].ZScore) > math.Abs(divergence.Sources[j].ZScore)
+			})
+			divergences = append(divergences, divergence)
+		}
+	}
+	sort.Slice(divergences, func(i, j int) bool {
+		return math.Abs(divergences[i].ZScore) > math.Abs(divergences[j].ZScore)
+	})
+
+	sources := make([]*model.BiasSourceSummary, 0, len(sourceTotalTimes))
+	for source, times := range sourceTotalTimes {
+		sources = append(sources, &model.BiasSourceSummary{
+			SourceName:  source.name,
+			Version:     source.version,
+			Times:       times,
+			Divergences: sourceDivergences[source],
+		})
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Divergences != sources[j].Divergences {
+			return sources[i].Divergences > sources[j].Divergences
+		}
+		return sources[i].Times > sources[j].Times
+	})
+
+	return &model.BiasReport{
+		Divergences: divergences,
+		Sources:     sources,
+	}, nil
+}
+
+func isManualSource(sourceName string) bool {
+	return lo.Contains(constant.ManualSources, sourceName)
+}
+
+func accumulateBias[K comparable](m map[int]map[K]*biasAccumulator, id int, key K, r *model.TotalQuantityResultBySource) {
+	if _, ok := m[id]; !ok {
+		m[id] = make(map[K]*biasAccumulator)
+	}
+	acc, ok := m[id][key]
+	if !ok {
+		acc = &biasAccumulator{}
+		m[id][key] = acc
+	}
+	acc.quantity += r.TotalQuantity
+	acc.squaredQuantity += r.TotalSquaredQuantity
+}
+
+func newBiasSample(times int, acc *biasAccumulator) *model.BiasSample {
+	sample := &model.BiasSample{
+		Times: times,
+	}
+	if acc == nil || times == 0 {
+		return sample
+	}
+	sample.Quantity = acc.quantity
+	sample.Rate = float64(acc.quantity) / float64(times)
+	variance := float64(acc.squaredQuantity)/float64(times) - sample.Rate*sample.Rate
+	sample.StdDev = math.Sqrt(math.Max(variance, 0))
+	return sample
+}
+
+// biasZScore runs a two-sample z-test (Welch's) on the mean drop quantities per run of a and b. It returns 0 when
+// both samples have no variance, as the test is not applicable.
+func biasZScore(a, b *model.BiasSample) float64 {
+	if a.Times == 0 || b.Times == 0 {
+		return 0
+	}
+	se := math.Sqrt(a.StdDev*a.StdDev/float64(a.Times) + b.StdDev*b.StdDev/float64(b.Times))
+	if se == 0 {
+		return 0
+	}
+	return (a.Rate - b.Rate) / se
+}
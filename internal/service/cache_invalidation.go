@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/goccy/go-json"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"go.uber.org/fx"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model/cache"
+	"exusiai.dev/backend-next/internal/model/types"
+	"exusiai.dev/backend-next/internal/pkg/cachectrl"
+	"exusiai.dev/backend-next/internal/pkg/cdnpurge"
+)
+
+const (
+	CacheInvalidationChannel        = "cache-invalidation"
+	CacheInvalidationAckRedisPrefix = "cache-invalidation-ack:"
+)
+
+type cacheInvalidationMessage struct {
+	ID        string                    `json:"id"`
+	Origin    string                    `json:"origin"`
+	Pairs     []types.PurgeCachePair    `json:"pairs"`
+	Evictions []types.CacheEvictRequest `json:"evictions,omitempty"`
+}
+
+// CacheInvalidationResult describes how many instances received and acknowledged a cluster-wide cache invalidation.
+type CacheInvalidationResult struct {
+	// Receivers is the number of instances subscribed to the invalidation channel when it is published.
+	Receivers int `json:"receivers"`
+	// Acknowledged is the number of instances that have flushed the caches successfully.
+	Acknowledged int `json:"acknowledged"`
+	// Errors are the errors reported by the instances that failed to flush the caches, keyed by instance.
+	Errors map[string]string `json:"errors,omitempty"`
+	// FlushError is the error occurred while flushing the caches of the current instance, if any. It is only
+	// reported by the callers that cannot fail at that point, such as the ones having committed their changes.
+	FlushError string `json:"flushError,omitempty"`
+	// PublishError is the error occurred while broadcasting the invalidation or waiting for its acknowledgements,
+	// if any. The caches of the current instance are flushed regardless.
+	PublishError string `json:"publishError,omitempty"`
+	// PurgedTags are the surrogate keys purged from the CDN after the caches are flushed.
+	PurgedTags []string `json:"purgedTags,omitempty"`
+	// PurgeError is the error occurred while purging the CDN, if any.
+	PurgeError string `json:"purgeError,omitempty"`
+}
+
+// CacheInvalidation broadcasts cache invalidations to every instance over Redis pub/sub, so that the per-process
+// caches in model/cache are flushed cluster-wide. Every instance, including the publishing one, acknowledges an
+// invalidation by adding itself to a short-lived Redis hash.
+type CacheInvalidation struct {
+	Redis  *redis.Client
+	Purger cdnpurge.Purger
+
+	instance   string
+	ackTimeout time.Duration
+	pubsub     *redis.PubSub
+}
+
+func NewCacheInvalidation(redisClient *redis.Client, purger cdnpurge.Purger, conf *appconfig.Config, lc fx.Lifecycle) *CacheInvalidation {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	s := &CacheInvalidation{
+		Redis:      redisClient,
+		Purger:     purger,
+		instance:   hostname + "-" + strings.ToLower(ulid.Make().String()),
+		ackTimeout: conf.CacheInvalidationAckTimeout,
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			s.pubsub = s.Redis.Subscribe(context.Background(), CacheInvalidationChannel)
+			go s.subscribe()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return s.pubsub.Close()
+		},
+	})
+
+	return s
+}
+
+// Invalidate flushes the caches locally, broadcasts the invalidation to every instance and waits for their
+// acknowledgements until every receiver has acknowledged or the acknowledgement timeout is reached.
+// The local flush makes sure that at least the current instance is consistent even if Redis is unavailable.
+// Afterwards, the responses served from the caches are purged from the CDN by their surrogate keys, along with
+// the responses carrying any of tags. A failed broadcast or CDN purge is reported in the result instead of as
+// an error, as the caches have been flushed locally already.
+func (s *CacheInvalidation) Invalidate(ctx context.Context, pairs []types.PurgeCachePair, tags ...string) (*CacheInvalidationResult, error) {
+	message := &cacheInvalidationMessage{
+		Pairs: pairs,
+	}
+	if _, err := s.flush(message); err != nil {
+		return nil, err
+	}
+	result := s.publish(ctx, message)
+
+	result.PurgedTags = lo.Uniq(append(cachectrl.PurgeTags(pairs), tags...))
+	if err := s.Purger.Purge(ctx, result.PurgedTags); err != nil {
+		log.Error().
+			Err(err).
+			Str("evt.name", "cache.invalidation.cdn_purge_failed").
+			Strs("tags", result.PurgedTags).
+			Msg("failed to purge CDN")
+		result.PurgeError = err.Error()
+	}
+	return result, nil
+}
+
+// Evict evicts the entries matching the patterns locally and broadcasts the eviction to every instance the same
+// way as Invalidate. It additionally returns the number of entries evicted on the current instance.
+func (s *CacheInvalidation) Evict(ctx context.Context, evictions []types.CacheEvictRequest) (*CacheInvalidationResult, int, error) {
+	message := &cacheInvalidationMessage{
+		Evictions: evictions,
+	}
+	evicted, err := s.flush(message)
+	if err != nil {
+		return nil, evicted, err
+	}
+	return s.publish(ctx, message), evicted, nil
+}
+
+// publish broadcasts the message and waits for its acknowledgements. Failures are logged and reported in the
+// result, as the caches have been flushed locally already.
+func (s *CacheInvalidation) publish(ctx context.Context, message *cacheInvalidationMessage) *CacheInvalidationResult {
+	result := &CacheInvalidationResult{}
+	if err := s.publishAndWait(ctx, message, result); err != nil {
+		log.Error().
+			Str("evt.name", "cache.invalidation.publish_failed").
+			Str("id", message.ID).
+			Err(err).
+			Msg("failed to broadcast cache invalidation: caches have only been flushed on the current instance")
+		result.PublishError = err.Error()
+		return result
+	}
+
+	log.Info().
+		Str("evt.name", "cache.invalidation.published").
+		Str("id", message.ID).
+		Int("receivers", result.Receivers).
+		Int("acknowledged", result.Acknowledged).
+		Msg("cache invalidation published")
+
+	return result
+}
+
+func (s *CacheInvalidation) publishAndWait(ctx context.Context, message *cacheInvalidationMessage, result *CacheInvalidationResult) error {
+	message.ID = strings.ToLower(ulid.Make().String())
+	message.Origin = s.instance
+	b, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	receivers, err := s.Redis.Publish(ctx, CacheInvalidationChannel, b).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to publish cache invalidation")
+	}
+	result.Receivers = int(receivers)
+
+	ackKey := CacheInvalidationAckRedisPrefix + message.ID
+	deadline := time.NewTimer(s.ackTimeout)
+	defer deadline.Stop()
+	t := time.NewTicker(time.Millisecond * 50)
+	defer t.Stop()
+	for {
+		acks, err := s.Redis.HGetAll(ctx, ackKey).Result()
+		if err != nil {
+			return errors.Wrap(err, "failed to get cache invalidation acknowledgements")
+		}
+		result.Acknowledged = 0
+		result.Errors = nil
+		for instance, ackErr := range acks {
+			if ackErr == "" {
+				result.Acknowledged++
+				continue
+			}
+			if result.Errors == nil {
+				result.Errors = make(map[string]string)
+			}
+			result.Errors[instance] = ackErr
+		}
+		if len(acks) >= result.Receivers {
+			return nil
+		}
+
+		select {
+		case <-t.C:
+		case <-deadline.C:
+			return nil
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "stopped waiting for cache invalidation acknowledgements")
+		}
+	}
+}
+
+func (s *CacheInvalidation) subscribe() {
+	for msg := range s.pubsub.Channel() {
+		var message cacheInvalidationMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+			log.Error().
+				Str("evt.name", "cache.invalidation.failed").
+				Err(err).
+				Msg("failed to unmarshal cache invalidation message")
+			continue
+		}
+
+		// the origin instance has already flushed its caches when publishing
+		ackErr := ""
+		if message.Origin != s.instance {
+			if _, err := s.flush(&message); err != nil {
+				log.Error().
+					Str("evt.name", "cache.invalidation.failed").
+					Str("id", message.ID).
+					Err(err).
+					Msg("failed to flush caches")
+				ackErr = err.Error()
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		ackKey := CacheInvalidationAckRedisPrefix + message.ID
+		_, err := s.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, ackKey, s.instance, ackErr)
+			pipe.Expire(ctx, ackKey, time.Minute)
+			return nil
+		})
+		cancel()
+		if err != nil {
+			log.Error().
+				Str("evt.name", "cache.invalidation.failed").
+				Str("id", message.ID).
+				Err(err).
+				Msg("failed to acknowledge cache invalidation")
+		}
+	}
+}
+
+// flush flushes the caches of the pairs and evicts the entries matching the evictions in the message,
+// and returns the number of entries evicted.
+func (s *CacheInvalidation) flush(message *cacheInvalidationMessage) (int, error) {
+	for _, pair := range message.Pairs {
+		if err := cache.Delete(pair.Name, pair.Key); err != nil {
+			return 0, errors.Wrapf(err, "cache [%s:%s]", pair.Name, pair.Key.String)
+		}
+	}
+
+	evicted := 0
+	for _, eviction := range message.Evictions {
+		n, err := cache.Evict(eviction.Name, eviction.Key)
+		evicted += n
+		if err != nil {
+			return evicted, errors.Wrapf(err, "cache [%s:%s]", eviction.Name, eviction.Key)
+		}
+	}
+	return evicted, nil
+}
@@ -143,7 +143,13 @@ func (s *DropMatrix) GetShimCustomizedDropMatrixResults(
 }
 
 func (s *DropMatrix) RefreshAllDropMatrixElements(ctx context.Context, server string, sourceCategories []string) error {
-	unifiedEndTime := time.Now()
+	return s.RefreshAllDropMatrixElementsUntil(ctx, server, sourceCategories, time.Now())
+}
+
+// RefreshAllDropMatrixElementsUntil refreshes the drop matrix elements of server with the reports created before
+// unifiedEndTime only, so that the reports created afterwards can be told apart from the refreshed matrix.
+// Reports are filtered by whole seconds, so unifiedEndTime should be truncated to a second.
+func (s *DropMatrix) RefreshAllDropMatrixElementsUntil(ctx context.Context, server string, sourceCategories []string, unifiedEndTime time.Time) error {
 
 	allTimeRanges, err := s.TimeRangeService.GetTimeRangesByServer(ctx, server)
 	if err != nil {
@@ -2,34 +2,45 @@ package service
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 	"github.com/uptrace/bun"
+
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
 )
 
 var (
 	ErrDatabaseNotReachable = errors.New("database not reachable")
 	ErrRedisNotReachable    = errors.New("redis not reachable")
 	ErrNATSNotReachable     = errors.New("nats not reachable")
+	ErrWarmingUp            = pgerr.New(http.StatusServiceUnavailable, "WARMING_UP", "instance is warming up result caches")
 )
 
 type Health struct {
 	DB    *bun.DB
 	Redis *redis.Client
 	NATS  *nats.Conn
+
+	WarmUpService *WarmUp
 }
 
-func NewHealth(db *bun.DB, redis *redis.Client, nats *nats.Conn) *Health {
+func NewHealth(db *bun.DB, redis *redis.Client, nats *nats.Conn, warmUpService *WarmUp) *Health {
 	return &Health{
-		DB:    db,
-		Redis: redis,
-		NATS:  nats,
+		DB:            db,
+		Redis:         redis,
+		NATS:          nats,
+		WarmUpService: warmUpService,
 	}
 }
 
 func (s *Health) Ping(ctx context.Context) error {
+	if !s.WarmUpService.Ready() {
+		return ErrWarmingUp
+	}
+
 	if err := s.DB.PingContext(ctx); err != nil {
 		return errors.Wrap(ErrDatabaseNotReachable, err.Error())
 	}
@@ -0,0 +1,454 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+	"google.golang.org/protobuf/proto"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model/pb"
+	"exusiai.dev/backend-next/internal/model/types"
+	"exusiai.dev/gommon/constant"
+)
+
+// LiveMatrixSubject is the NATS subject the accepted drops are published to, so that every instance pushes
+// them to its own /v3/live subscribers.
+const LiveMatrixSubject = "live.matrix"
+
+const (
+	// LiveReplayRedisKey is the Redis stream of the latest updates, for clients to resume from after a brief
+	// disconnect. The IDs of its entries are the IDs of the updates.
+	LiveReplayRedisKey = "live-matrix-replay"
+
+	// liveEventIDHeader is the NATS header carrying the ID of an update.
+	liveEventIDHeader = "Live-Event-Id"
+)
+
+var (
+	ErrLiveSubscriptionLimit   = errors.New("subscription limit reached")
+	ErrLiveSubscriptionInvalid = errors.New("either stage_id or item_id is required")
+	ErrLiveClientClosed        = errors.New("connection closed")
+)
+
+// LiveUpdate is an update of the elements a connection is subscribed to. ID is empty if the update could not be
+// recorded in the replay buffer.
+type LiveUpdate struct {
+	ID       string
+	Elements []*pb.MatrixUpdateMessage_Element
+}
+
+type liveElementKey struct {
+	server  pb.Server
+	stageId uint32
+	itemId  uint32
+}
+
+// LiveHub aggregates the drops accepted by the workers and fans them out as MatrixUpdateMessage deltas to the
+// /v3/live connections subscribed to their stage or item. Deltas are flushed to NATS periodically and
+// dispatched to the local connections by every instance on receipt, so a connection receives the drops
+// accepted by any worker regardless of the instance it is connected to.
+//
+// An element carries the quantity of an item dropped from a stage and the times of the reports dropping it.
+// An element with item_id 0 carries the times of every report of a stage, regardless of its drops.
+//
+// Every update is recorded in a short replay buffer in Redis before being published, for connections to resume
+// from the last update they have received.
+type LiveHub struct {
+	NatsConn *nats.Conn
+	Redis    *redis.Client
+
+	flushInterval    time.Duration
+	maxSubscriptions int
+	sendBuffer       int
+	replayBuffer     int64
+
+	pendingMu sync.Mutex
+	pending   map[liveElementKey]*pb.MatrixUpdateMessage_Element
+
+	clientsMu sync.RWMutex
+	clients   map[string]map[*LiveClient]struct{}
+
+	sub  *nats.Subscription
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewLiveHub(conf *appconfig.Config, natsConn *nats.Conn, redisClient *redis.Client, lc fx.Lifecycle) *LiveHub {
+	h := &LiveHub{
+		NatsConn:         natsConn,
+		Redis:            redisClient,
+		flushInterval:    conf.LiveFlushInterval,
+		maxSubscriptions: conf.LiveMaxSubscriptions,
+		sendBuffer:       conf.LiveSendBuffer,
+		replayBuffer:     int64(conf.LiveReplayBufferSize),
+		pending:          make(map[liveElementKey]*pb.MatrixUpdateMessage_Element),
+		clients:          make(map[string]map[*LiveClient]struct{}),
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			sub, err := h.NatsConn.Subscribe(LiveMatrixSubject, h.dispatch)
+			if err != nil {
+				return errors.Wrap(err, "service: live hub: failed to subscribe")
+			}
+			h.sub = sub
+			go h.worker()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(h.stop)
+			<-h.done
+			return h.sub.Unsubscribe()
+		},
+	})
+
+	return h
+}
+
+// PushReport records the drops of an accepted report, to be published on the next flush.
+func (h *LiveHub) PushReport(r *types.ReportTaskSingleReport, stageId uint32, server string) error {
+	m, ok := constant.ServerIDMapping[server]
+	if !ok {
+		return errors.New("service: live hub: unknown server")
+	}
+	pbserv := pb.Server(m)
+
+	// a report counts once for the times of an item even if its drops are not merged by item
+	quantities := make(map[uint32]uint64, len(r.Drops))
+	for _, d := range r.Drops {
+		quantities[uint32(d.ItemID)] += uint64(d.Quantity)
+	}
+
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+
+	h.add(liveElementKey{server: pbserv, stageId: stageId}, 0, uint64(r.Times))
+	for itemId, quantity := range quantities {
+		h.add(liveElementKey{server: pbserv, stageId: stageId, itemId: itemId}, quantity, uint64(r.Times))
+	}
+
+	return nil
+}
+
+func (h *LiveHub) add(key liveElementKey, quantity, times uint64) {
+	el, ok := h.pending[key]
+	if !ok {
+		el = &pb.MatrixUpdateMessage_Element{
+			Server:  key.server,
+			StageId: key.stageId,
+			ItemId:  key.itemId,
+		}
+		h.pending[key] = el
+	}
+	el.Quantity += quantity
+	el.Times += times
+}
+
+func (h *LiveHub) worker() {
+	defer close(h.done)
+
+	t := time.NewTicker(h.flushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			h.flush()
+		case <-h.stop:
+			h.flush()
+			return
+		}
+	}
+}
+
+func (h *LiveHub) flush() {
+	h.pendingMu.Lock()
+	pending := h.pending
+	h.pending = make(map[liveElementKey]*pb.MatrixUpdateMessage_Element)
+	h.pendingMu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	msg := &pb.MatrixUpdateMessage{
+		Header:   &pb.Header{Type: pb.MessageType_MATRIX_UPDATE_MESSAGE},
+		Segments: make([]*pb.MatrixUpdateMessage_Element, 0, len(pending)),
+	}
+	for _, el := range pending {
+		msg.Segments = append(msg.Segments, el)
+	}
+
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		log.Error().
+			Str("evt.name", "live.publish.failed").
+			Err(err).
+			Msg("failed to marshal matrix update message")
+		return
+	}
+
+	m := nats.NewMsg(LiveMatrixSubject)
+	m.Data = b
+	// the update is still published without an ID if Redis is unavailable, but cannot be resumed from
+	id, err := h.Redis.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: LiveReplayRedisKey,
+		MaxLen: h.replayBuffer,
+		Approx: true,
+		Values: map[string]any{"data": b},
+	}).Result()
+	if err != nil {
+		log.Warn().
+			Str("evt.name", "live.replay.failed").
+			Err(err).
+			Msg("failed to record matrix update message in replay buffer")
+	} else {
+		m.Header.Set(liveEventIDHeader, id)
+	}
+
+	if err := h.NatsConn.PublishMsg(m); err != nil {
+		log.Error().
+			Str("evt.name", "live.publish.failed").
+			Err(err).
+			Int("count", len(msg.Segments)).
+			Msg("failed to publish matrix update message")
+	}
+}
+
+// dispatch pushes the elements of a MatrixUpdateMessage received from NATS to the local connections
+// subscribed to them, sending every connection a single message of the elements it is subscribed to.
+func (h *LiveHub) dispatch(m *nats.Msg) {
+	var msg pb.MatrixUpdateMessage
+	if err := proto.Unmarshal(m.Data, &msg); err != nil {
+		log.Warn().
+			Str("evt.name", "live.dispatch.failed").
+			Err(err).
+			Msg("failed to unmarshal matrix update message")
+		return
+	}
+
+	updates := make(map[*LiveClient][]*pb.MatrixUpdateMessage_Element)
+	h.clientsMu.RLock()
+	for _, el := range msg.Segments {
+		for c := range h.clients[liveStageTopic(el.Server, el.StageId)] {
+			updates[c] = append(updates[c], el)
+		}
+		if el.ItemId == 0 {
+			continue
+		}
+		for c := range h.clients[liveItemTopic(el.Server, el.ItemId)] {
+			// a connection subscribed to both the stage and the item receives the element once
+			if _, ok := c.topics[liveStageTopic(el.Server, el.StageId)]; ok {
+				continue
+			}
+			updates[c] = append(updates[c], el)
+		}
+	}
+	h.clientsMu.RUnlock()
+
+	id := m.Header.Get(liveEventIDHeader)
+	for c, elements := range updates {
+		c.push(&LiveUpdate{ID: id, Elements: elements})
+	}
+}
+
+// Replay returns the updates after lastEventID in the replay buffer, filtered by the subscriptions of c.
+// complete is false if lastEventID is no longer, or has never been, in the buffer, in which case updates may
+// have been lost and the client shall fetch the matrix again.
+func (h *LiveHub) Replay(ctx context.Context, c *LiveClient, lastEventID string) (updates []*LiveUpdate, complete bool, err error) {
+	if _, ok := parseLiveEventID(lastEventID); !ok {
+		return nil, false, nil
+	}
+
+	entries, err := h.Redis.XRange(ctx, LiveReplayRedisKey, lastEventID, "+").Result()
+	if err != nil {
+		return nil, false, err
+	}
+	complete = len(entries) > 0 && entries[0].ID == lastEventID
+
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+	for _, entry := range entries {
+		if entry.ID == lastEventID {
+			continue
+		}
+		data, ok := entry.Values["data"].(string)
+		if !ok {
+			continue
+		}
+		var msg pb.MatrixUpdateMessage
+		if err := proto.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, false, err
+		}
+		if elements := c.filter(msg.Segments); len(elements) > 0 {
+			updates = append(updates, &LiveUpdate{ID: entry.ID, Elements: elements})
+		}
+	}
+
+	return updates, complete, nil
+}
+
+// Connect registers a new connection without any subscription.
+func (h *LiveHub) Connect() *LiveClient {
+	return &LiveClient{
+		hub:    h,
+		send:   make(chan *LiveUpdate, h.sendBuffer),
+		topics: make(map[string]struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+// CompareLiveEventIDs compares the IDs of two updates, returning -1, 0 or 1 if a is before, the same as or
+// after b. Empty or malformed IDs are before any valid ID.
+func CompareLiveEventIDs(a, b string) int {
+	ia, _ := parseLiveEventID(a)
+	ib, _ := parseLiveEventID(b)
+	for i := range ia {
+		if ia[i] < ib[i] {
+			return -1
+		}
+		if ia[i] > ib[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseLiveEventID parses a Redis stream entry ID, in the form of <milliseconds>-<sequence>.
+func parseLiveEventID(id string) (parts [2]uint64, ok bool) {
+	ms, seq, found := strings.Cut(id, "-")
+	if !found {
+		return parts, false
+	}
+	var err error
+	if parts[0], err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return [2]uint64{}, false
+	}
+	if parts[1], err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return [2]uint64{}, false
+	}
+	return parts, true
+}
+
+func liveStageTopic(server pb.Server, stageId uint32) string {
+	return strconv.Itoa(int(server)) + "|stage|" + strconv.FormatUint(uint64(stageId), 10)
+}
+
+func liveItemTopic(server pb.Server, itemId uint32) string {
+	return strconv.Itoa(int(server)) + "|item|" + strconv.FormatUint(uint64(itemId), 10)
+}
+
+// LiveClient is a /v3/live connection registered to a LiveHub.
+type LiveClient struct {
+	hub    *LiveHub
+	send   chan *LiveUpdate
+	topics map[string]struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Subscribe subscribes the connection to the elements of a stage or an item of a server.
+func (c *LiveClient) Subscribe(req *pb.MatrixUpdateSubscribeReq) error {
+	var topic string
+	switch id := req.Id.(type) {
+	case *pb.MatrixUpdateSubscribeReq_StageId:
+		topic = liveStageTopic(req.Server, id.StageId)
+	case *pb.MatrixUpdateSubscribeReq_ItemId:
+		topic = liveItemTopic(req.Server, id.ItemId)
+	default:
+		return ErrLiveSubscriptionInvalid
+	}
+
+	c.hub.clientsMu.Lock()
+	defer c.hub.clientsMu.Unlock()
+
+	select {
+	case <-c.closed:
+		return ErrLiveClientClosed
+	default:
+	}
+	if _, ok := c.topics[topic]; ok {
+		return nil
+	}
+	if len(c.topics) >= c.hub.maxSubscriptions {
+		return ErrLiveSubscriptionLimit
+	}
+
+	c.topics[topic] = struct{}{}
+	clients, ok := c.hub.clients[topic]
+	if !ok {
+		clients = make(map[*LiveClient]struct{})
+		c.hub.clients[topic] = clients
+	}
+	clients[c] = struct{}{}
+
+	return nil
+}
+
+// Send returns the channel of the updates to be written to the connection.
+func (c *LiveClient) Send() <-chan *LiveUpdate {
+	return c.send
+}
+
+// Closed returns a channel closed once the connection is unregistered, either by Close or because it is
+// too slow to keep up with the updates.
+func (c *LiveClient) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Close unregisters the connection from all of its subscriptions.
+func (c *LiveClient) Close() {
+	c.closeOnce.Do(func() {
+		c.hub.clientsMu.Lock()
+		for topic := range c.topics {
+			delete(c.hub.clients[topic], c)
+			if len(c.hub.clients[topic]) == 0 {
+				delete(c.hub.clients, topic)
+			}
+		}
+		c.hub.clientsMu.Unlock()
+
+		close(c.closed)
+	})
+}
+
+// filter returns the elements c is subscribed to. The caller must hold the read lock of the hub.
+func (c *LiveClient) filter(elements []*pb.MatrixUpdateMessage_Element) []*pb.MatrixUpdateMessage_Element {
+	var filtered []*pb.MatrixUpdateMessage_Element
+	for _, el := range elements {
+		if _, ok := c.topics[liveStageTopic(el.Server, el.StageId)]; ok {
+			filtered = append(filtered, el)
+			continue
+		}
+		if el.ItemId == 0 {
+			continue
+		}
+		if _, ok := c.topics[liveItemTopic(el.Server, el.ItemId)]; ok {
+			filtered = append(filtered, el)
+		}
+	}
+	return filtered
+}
+
+func (c *LiveClient) push(u *LiveUpdate) {
+	select {
+	case c.send <- u:
+	default:
+		log.Debug().
+			Str("evt.name", "live.client.slow").
+			Msg("closing slow live connection")
+		c.Close()
+	}
+}
@@ -2,55 +2,136 @@ package service
 
 import (
 	"context"
-	"sync/atomic"
 	"time"
 
+	"github.com/avast/retry-go/v4"
+	"github.com/go-redis/redis/v8"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/guregu/null.v3"
 
 	"exusiai.dev/backend-next/internal/app/appconfig"
 	"exusiai.dev/backend-next/internal/model/pb"
 	"exusiai.dev/backend-next/internal/model/types"
 	"exusiai.dev/backend-next/internal/pkg/dstructs"
+	"exusiai.dev/backend-next/internal/pkg/observability"
 	"exusiai.dev/backend-next/internal/repo"
 	"exusiai.dev/gommon/constant"
 )
 
+const (
+	LiveHouseModeGRPC     = "grpc"
+	LiveHouseModeEmbedded = "embedded"
+)
+
+// LiveHouse is the entry point of the accepted reports to the live stats: it feeds them to the /v3/live hub, and
+// reports them to either the external LiveHouse service or the embedded one, along with the matrix of every
+// generation.
+//
+// A generation is the Unix milliseconds of the watermark its matrix is calculated up to, and a report belongs to
+// the generation of the milliseconds it is created at, so that every report is accounted for either in the matrix
+// of a generation or in the reports of the same or a later generation, but never in both. Generations used to be
+// a counter local to each instance; they still increase with every matrix, but are no longer consecutive.
 type LiveHouse struct {
-	Enabled   bool
-	Client    pb.ConnectedLiveServiceClient
-	StageRepo *repo.Stage
+	Enabled           bool
+	Mode              string
+	Client            pb.ConnectedLiveServiceClient
+	Redis             *redis.Client
+	StageRepo         *repo.Stage
+	LiveHubService    *LiveHub
+	DropMatrixService *DropMatrix
+
+	q              *dstructs.BoundedQueue[*pb.Report]
+	batchSize      int
+	retryAttempts  uint
+	retryDelay     time.Duration
+	retryMaxDelay  time.Duration
+	spill          bool
+	spillMax       int
+	overflowPolicy string
 
-	q   *dstructs.FlQueue[*pb.Report]
-	t   *time.Ticker
-	gen uint64
+	stop chan struct{}
+	done chan struct{}
 }
 
-func NewLiveHouse(client pb.ConnectedLiveServiceClient, stageRepo *repo.Stage, conf *appconfig.Config) (*LiveHouse, error) {
+// LiveHouseSpillRedisKey is the Redis list of the report batches failed to be pushed to LiveHouse, waiting to be
+// pushed again.
+const LiveHouseSpillRedisKey = "livehouse:spill"
+
+func NewLiveHouse(client pb.ConnectedLiveServiceClient, embedded *LiveHouseEmbedded, redisClient *redis.Client, stageRepo *repo.Stage, liveHubService *LiveHub, dropMatrixService *DropMatrix, conf *appconfig.Config, lc fx.Lifecycle) (*LiveHouse, error) {
 	l := &LiveHouse{
-		Enabled:   conf.LiveHouseEnabled,
-		Client:    client,
-		StageRepo: stageRepo,
-		q:         dstructs.NewFlQueue[*pb.Report](),
-		t:         time.NewTicker(time.Second * 5),
+		Enabled:           conf.LiveHouseEnabled,
+		Mode:              conf.LiveHouseMode,
+		Client:            client,
+		Redis:             redisClient,
+		StageRepo:         stageRepo,
+		LiveHubService:    liveHubService,
+		DropMatrixService: dropMatrixService,
+		batchSize:         conf.LiveHouseBatchSize,
+		retryAttempts:     conf.LiveHouseRetryAttempts,
+		retryDelay:        conf.LiveHouseRetryDelay,
+		retryMaxDelay:     conf.LiveHouseRetryMaxDelay,
+		spill:             conf.LiveHouseSpillEnabled,
+		spillMax:          conf.LiveHouseSpillMaxBatches,
+		overflowPolicy:    conf.LiveHouseQueueOverflow,
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	if l.Mode == LiveHouseModeEmbedded {
+		l.Client = embedded
 	}
 
-	if l.Enabled {
-		if err := l.checkConfig(); err != nil {
-			return nil, err
-		}
-
-		go l.worker()
-	} else {
+	if !l.Enabled {
 		log.Info().
 			Str("evt.name", "livehouse.disabled").
 			Msg("service: livehouse: disabled")
+		return l, nil
 	}
 
+	if err := l.checkConfig(); err != nil {
+		return nil, err
+	}
+	policy := dstructs.DropOldest
+	if l.overflowPolicy == "drop-newest" {
+		policy = dstructs.DropNewest
+	}
+	l.q = dstructs.NewBoundedQueue[*pb.Report](conf.LiveHouseQueueSize, policy)
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go l.worker()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(l.stop)
+			select {
+			case <-l.done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+
 	return l, nil
 }
 
 func (l *LiveHouse) checkConfig() error {
+	if l.Mode != LiveHouseModeGRPC && l.Mode != LiveHouseModeEmbedded {
+		return errors.New("service: livehouse: unknown mode " + l.Mode)
+	}
+	if l.overflowPolicy != "drop-oldest" && l.overflowPolicy != "drop-newest" {
+		return errors.New("service: livehouse: unknown queue overflow policy " + l.overflowPolicy)
+	}
+	if l.retryAttempts == 0 {
+		// retry-go retries forever with zero attempts
+		return errors.New("service: livehouse: retry attempts must be positive")
+	}
+	if l.spill && l.spillMax <= 0 {
+		return errors.New("service: livehouse: spill max batches must be positive")
+	}
 	if l.Client == nil {
 		return errors.New("service: livehouse: client is nil. is livehouse enabled?")
 	}
@@ -61,31 +142,204 @@ func (l *LiveHouse) checkConfig() error {
 	return nil
 }
 
+// worker pushes the queued reports to LiveHouse every 5 seconds, retrying a failed batch with exponential backoff
+// and spilling it to Redis if it still fails. Spilled batches are pushed again after the queue has been pushed
+// successfully. On shutdown, the queue is drained with a single attempt per batch.
 func (l *LiveHouse) worker() {
-	for range l.t.C {
-		reports := l.q.Flush()
-		if len(reports) == 0 {
-			continue
+	defer close(l.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// abort the retries in progress on shutdown
+		<-l.stop
+		cancel()
+	}()
+
+	t := time.NewTicker(time.Second * 5)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if l.flush(ctx) {
+				l.unspill(ctx)
+			}
+		case <-l.stop:
+			l.drain()
+			return
 		}
+	}
+}
 
-		_, err := l.Client.PushReportBatch(context.Background(), &pb.ReportBatchRequest{
-			Reports: reports,
-		})
-		if err != nil {
+// flush pushes the queued reports in batches, and returns whether all of them have been pushed.
+func (l *LiveHouse) flush(ctx context.Context) bool {
+	defer observability.LiveHouseQueueLength.Set(float64(l.q.Len()))
+
+	for l.q.Len() > 0 {
+		batch := &pb.ReportBatchRequest{Reports: l.q.Flush(l.batchSize)}
+		if err := l.pushWithRetry(ctx, batch); err != nil {
 			log.Error().
 				Str("evt.name", "livehouse.report.failed").
 				Err(err).
+				Int("count", len(batch.Reports)).
 				Msg("failed to push report batch")
-		} else {
-			log.Info().
-				Str("evt.name", "livehouse.report.success").
-				Int("count", len(reports)).
-				Msg("successfully reported reports to livehouse")
+			l.giveUp(batch)
+			return false
+		}
+	}
+	return true
+}
+
+func (l *LiveHouse) pushWithRetry(ctx context.Context, batch *pb.ReportBatchRequest) error {
+	return retry.Do(func() error {
+		return l.push(ctx, batch)
+	},
+		retry.Context(ctx),
+		retry.Attempts(l.retryAttempts),
+		retry.Delay(l.retryDelay),
+		retry.MaxDelay(l.retryMaxDelay),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(n uint, err error) {
+			observability.LiveHouseBatches.WithLabelValues("retried").Inc()
+		}),
+	)
+}
+
+func (l *LiveHouse) push(ctx context.Context, batch *pb.ReportBatchRequest) error {
+	if _, err := l.Client.PushReportBatch(ctx, batch); err != nil {
+		return err
+	}
+	observability.LiveHouseBatches.WithLabelValues("success").Inc()
+	log.Info().
+		Str("evt.name", "livehouse.report.success").
+		Int("count", len(batch.Reports)).
+		Msg("successfully reported reports to livehouse")
+	return nil
+}
+
+// giveUp spills a batch failed to be pushed to Redis if enabled, or drops it otherwise.
+func (l *LiveHouse) giveUp(batch *pb.ReportBatchRequest) {
+	if l.spill {
+		b, err := proto.Marshal(batch)
+		if err == nil {
+			err = l.spillBatch(b)
+		}
+		if err == nil {
+			observability.LiveHouseBatches.WithLabelValues("spilled").Inc()
+			return
+		}
+		log.Error().
+			Str("evt.name", "livehouse.spill.failed").
+			Err(err).
+			Int("count", len(batch.Reports)).
+			Msg("failed to spill report batch")
+	}
+	observability.LiveHouseBatches.WithLabelValues("failed").Inc()
+	observability.LiveHouseReportsDropped.WithLabelValues("failed").Add(float64(len(batch.Reports)))
+}
+
+// spillBatch appends a marshaled batch to the spilled batches, and drops the oldest ones beyond spillMax.
+func (l *LiveHouse) spillBatch(b []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var overflow *redis.StringSliceCmd
+	if _, err := l.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, LiveHouseSpillRedisKey, b)
+		overflow = pipe.LRange(ctx, LiveHouseSpillRedisKey, 0, int64(-l.spillMax-1))
+		pipe.LTrim(ctx, LiveHouseSpillRedisKey, int64(-l.spillMax), -1)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if dropped := overflow.Val(); len(dropped) > 0 {
+		reports := 0
+		for _, data := range dropped {
+			var batch pb.ReportBatchRequest
+			if err := proto.Unmarshal([]byte(data), &batch); err == nil {
+				reports += len(batch.Reports)
+			}
+		}
+		log.Warn().
+			Str("evt.name", "livehouse.spill.overflow").
+			Int("batches", len(dropped)).
+			Int("count", reports).
+			Msg("dropped the oldest spilled report batches beyond the spill limit")
+		observability.LiveHouseBatches.WithLabelValues("failed").Add(float64(len(dropped)))
+		observability.LiveHouseReportsDropped.WithLabelValues("spill_overflow").Add(float64(reports))
+	}
+	return nil
+}
+
+// unspill pushes the spilled batches again, oldest first, until one of them fails.
+func (l *LiveHouse) unspill(ctx context.Context) {
+	if !l.spill {
+		return
+	}
+	for {
+		b, err := l.Redis.LPop(ctx, LiveHouseSpillRedisKey).Bytes()
+		if err != nil {
+			if err != redis.Nil {
+				log.Warn().
+					Str("evt.name", "livehouse.unspill.failed").
+					Err(err).
+					Msg("failed to pop spilled report batch")
+			}
+			return
+		}
+
+		var batch pb.ReportBatchRequest
+		if err := proto.Unmarshal(b, &batch); err != nil {
+			log.Error().
+				Str("evt.name", "livehouse.unspill.failed").
+				Err(err).
+				Msg("discarding malformed spilled report batch")
+			continue
+		}
+		if err := l.push(ctx, &batch); err != nil {
+			// put it back in front, to be pushed again in order
+			if err := l.Redis.LPush(context.Background(), LiveHouseSpillRedisKey, b).Err(); err != nil {
+				log.Error().
+					Str("evt.name", "livehouse.unspill.failed").
+					Err(err).
+					Int("count", len(batch.Reports)).
+					Msg("failed to put back spilled report batch")
+				observability.LiveHouseReportsDropped.WithLabelValues("failed").Add(float64(len(batch.Reports)))
+			}
+			return
+		}
+		observability.LiveHouseBatches.WithLabelValues("unspilled").Inc()
+	}
+}
+
+// drain pushes the reports left in the queue on shutdown, with a single attempt per batch.
+func (l *LiveHouse) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	for l.q.Len() > 0 {
+		batch := &pb.ReportBatchRequest{Reports: l.q.Flush(l.batchSize)}
+		if err := l.push(ctx, batch); err != nil {
+			log.Error().
+				Str("evt.name", "livehouse.drain.failed").
+				Err(err).
+				Int("count", len(batch.Reports)).
+				Msg("failed to push report batch on shutdown")
+			l.giveUp(batch)
 		}
 	}
+	observability.LiveHouseQueueLength.Set(0)
 }
 
-func (l *LiveHouse) PushReport(r *types.ReportTaskSingleReport, stageId uint32, server string) error {
+// PushReport feeds an accepted report to the /v3/live hub, and queues it to be reported to LiveHouse tagged
+// with the generation of createdAt, the time the report is saved as created at.
+func (l *LiveHouse) PushReport(r *types.ReportTaskSingleReport, stageId uint32, server string, createdAt time.Time) error {
+	if err := l.LiveHubService.PushReport(r, stageId, server); err != nil {
+		return err
+	}
 	if !l.Enabled {
 		return nil
 	}
@@ -99,9 +353,10 @@ func (l *LiveHouse) PushReport(r *types.ReportTaskSingleReport, stageId uint32,
 
 	pr := &pb.Report{
 		Server:     pbserv,
-		Generation: atomic.LoadUint64(&l.gen),
+		Generation: l.Generation(createdAt),
 		StageId:    stageId,
 		Drops:      make([]*pb.Drop, 0, len(r.Drops)),
+		Times:      uint64(r.Times),
 	}
 	for _, d := range r.Drops {
 		pr.Drops = append(pr.Drops, &pb.Drop{
@@ -109,14 +364,52 @@ func (l *LiveHouse) PushReport(r *types.ReportTaskSingleReport, stageId uint32,
 			Quantity: uint64(d.Quantity),
 		})
 	}
-	l.q.Push(pr)
+	if l.q.Push(pr) {
+		observability.LiveHouseReportsDropped.WithLabelValues("overflow").Inc()
+	}
+	observability.LiveHouseQueueLength.Set(float64(l.q.Len()))
 
 	return nil
 }
 
-func (l *LiveHouse) PushMatrix() {
+// Generation returns the generation of t, i.e. t in Unix milliseconds.
+func (l *LiveHouse) Generation(t time.Time) uint64 {
+	return uint64(t.UnixMilli())
+}
+
+// PushMatrix pushes the current matrix of server to LiveHouse as the base of generation, which has to be the
+// generation of the watermark the matrix has been refreshed up to.
+func (l *LiveHouse) PushMatrix(ctx context.Context, server string, generation uint64) error {
 	if !l.Enabled {
-		return
+		return nil
 	}
-	atomic.AddUint64(&l.gen, 1)
+
+	m, ok := constant.ServerIDMapping[server]
+	if !ok {
+		return errors.New("service: livehouse: unknown server")
+	}
+
+	results, err := l.DropMatrixService.getMaxAccumulableDropMatrixResults(ctx, server, null.Int{}, constant.SourceCategoryAll)
+	if err != nil {
+		return err
+	}
+
+	req := &pb.MatrixBatchRequest{
+		Server:     pb.Server(m),
+		Generation: generation,
+		Matrix:     make([]*pb.Matrix, 0, len(results.Matrix)),
+	}
+	for _, el := range results.Matrix {
+		req.Matrix = append(req.Matrix, &pb.Matrix{
+			StageId:  uint32(el.StageID),
+			ItemId:   uint32(el.ItemID),
+			Quantity: uint64(el.Quantity),
+			Times:    uint64(el.Times),
+		})
+	}
+
+	if _, err := l.Client.PushMatrixBatch(ctx, req); err != nil {
+		return errors.Wrap(err, "service: livehouse: failed to push matrix batch")
+	}
+	return nil
 }
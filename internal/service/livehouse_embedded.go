@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model/pb"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+)
+
+const LiveHouseRedisPrefix = "livehouse:"
+
+// ErrLiveMatrixUnavailable is returned when the embedded LiveHouse is not in use, or no matrix has been pushed
+// for the server yet.
+var ErrLiveMatrixUnavailable = pgerr.New(http.StatusServiceUnavailable, "LIVE_MATRIX_UNAVAILABLE", "live matrix is not available")
+
+// LiveHouseEmbedded is an in-process implementation of ConnectedLiveService, for deployments without the
+// external LiveHouse service. It keeps the live matrix of every server in Redis, shared by every instance:
+//
+//   - every matrix pushed by PushMatrixBatch is kept as the base of its generation, along with the bases of
+//     the previous generations up to the configured count;
+//   - every report pushed by PushReportBatch is appended to the reports of its server, which are trimmed to
+//     the ones appended since the latest generation whenever a generation starts;
+//   - the live matrix of a generation is its base plus the reports of the same or a later generation, as the
+//     reports of earlier generations are already accounted for in the base.
+//
+// Generations are the milliseconds of the watermarks their matrices are calculated up to, and reports are tagged
+// with the milliseconds at which they are created, so that every instance agrees on them without coordination.
+// Every instance keeps the live matrix of the latest generation it has aggregated, and only reads the reports
+// appended since on every request.
+type LiveHouseEmbedded struct {
+	Redis *redis.Client
+
+	enabled     bool
+	generations int64
+
+	mu         sync.Mutex
+	aggregates map[pb.Server]*liveAggregate
+}
+
+// liveAggregate is the live matrix of a server as of a generation, aggregated from the reports up to lastId.
+type liveAggregate struct {
+	generation uint64
+	lastId     string
+	base       map[string]*pb.Matrix
+	// quantities are the quantities of the reports aggregated, keyed by matrix field
+	quantities map[string]uint64
+	// runs are the times of the reports aggregated, keyed by stage
+	runs map[uint32]uint64
+}
+
+// LiveMatrix is the live matrix of a server as of a generation.
+type LiveMatrix struct {
+	Server     pb.Server
+	Generation uint64
+	Matrix     []*pb.Matrix
+}
+
+func NewLiveHouseEmbedded(redisClient *redis.Client, conf *appconfig.Config) *LiveHouseEmbedded {
+	return &LiveHouseEmbedded{
+		Redis:       redisClient,
+		enabled:     conf.LiveHouseEnabled && conf.LiveHouseMode == LiveHouseModeEmbedded,
+		generations: int64(conf.LiveHouseGenerations),
+		aggregates:  make(map[pb.Server]*liveAggregate),
+	}
+}
+
+var _ pb.ConnectedLiveServiceClient = (*LiveHouseEmbedded)(nil)
+
+func (l *LiveHouseEmbedded) PushReportBatch(ctx context.Context, in *pb.ReportBatchRequest, _ ...grpc.CallOption) (*pb.ReportBatchACK, error) {
+	pipe := l.Redis.Pipeline()
+	for _, r := range in.Reports {
+		b, err := proto.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: l.reportsKey(r.Server),
+			Values: map[string]any{"data": b},
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, errors.Wrap(err, "service: livehouse: failed to append reports")
+	}
+	return &pb.ReportBatchACK{}, nil
+}
+
+// PushMatrixBatch saves the matrix as the base of its generation and drops the generations beyond the
+// configured count, along with the reports accounted for in the new generation.
+func (l *LiveHouseEmbedded) PushMatrixBatch(ctx context.Context, in *pb.MatrixBatchRequest, _ ...grpc.CallOption) (*pb.MatrixBatchACK, error) {
+	matrixKey := l.matrixKey(in.Server, in.Generation)
+	generationsKey := l.generationsKey(in.Server)
+
+	values := make([]any, 0, len(in.Matrix)*2)
+	for _, m := range in.Matrix {
+		b, err := proto.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, matrixField(m.StageId, m.ItemId), b)
+	}
+
+	if _, err := l.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, matrixKey)
+		if len(values) > 0 {
+			pipe.HSet(ctx, matrixKey, values...)
+		}
+		pipe.ZAdd(ctx, generationsKey, &redis.Z{Score: float64(in.Generation), Member: in.Generation})
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "service: livehouse: failed to save matrix")
+	}
+
+	if err := l.trim(ctx, in.Server, in.Generation); err != nil {
+		return nil, err
+	}
+
+	return &pb.MatrixBatchACK{Generation: in.Generation}, nil
+}
+
+func (l *LiveHouseEmbedded) trim(ctx context.Context, server pb.Server, generation uint64) error {
+	generationsKey := l.generationsKey(server)
+	stale, err := l.Redis.ZRange(ctx, generationsKey, 0, -l.generations-1).Result()
+	if err != nil {
+		return errors.Wrap(err, "service: livehouse: failed to list generations")
+	}
+
+	pipe := l.Redis.Pipeline()
+	for _, member := range stale {
+		generation, _ := strconv.ParseUint(member, 10, 64)
+		pipe.Del(ctx, l.matrixKey(server, generation))
+		pipe.ZRem(ctx, generationsKey, member)
+	}
+	// reports are appended after they are created, so the reports appended before the generation are all of
+	// earlier generations, and are accounted for in its base
+	pipe.XTrimMinIDApprox(ctx, l.reportsKey(server), strconv.FormatUint(generation, 10), 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrap(err, "service: livehouse: failed to drop stale generations")
+	}
+	return nil
+}
+
+// LiveMatrix returns the live matrix of server as of its latest generation.
+func (l *LiveHouseEmbedded) LiveMatrix(ctx context.Context, server pb.Server) (*LiveMatrix, error) {
+	if !l.enabled {
+		return nil, ErrLiveMatrixUnavailable
+	}
+
+	latest, err := l.Redis.ZRevRange(ctx, l.generationsKey(server), 0, 0).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "service: livehouse: failed to get latest generation")
+	}
+	if len(latest) == 0 {
+		return nil, ErrLiveMatrixUnavailable
+	}
+	generation, err := strconv.ParseUint(latest[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	aggregate, ok := l.aggregates[server]
+	if !ok || aggregate.generation != generation {
+		if aggregate, err = l.newAggregate(ctx, server, generation); err != nil {
+			return nil, err
+		}
+		l.aggregates[server] = aggregate
+	}
+	if err := l.aggregate(ctx, server, aggregate); err != nil {
+		return nil, err
+	}
+	return aggregate.matrix(server), nil
+}
+
+func (l *LiveHouseEmbedded) newAggregate(ctx context.Context, server pb.Server, generation uint64) (*liveAggregate, error) {
+	base, err := l.Redis.HGetAll(ctx, l.matrixKey(server, generation)).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "service: livehouse: failed to get matrix")
+	}
+	aggregate := &liveAggregate{
+		generation: generation,
+		lastId:     "-",
+		base:       make(map[string]*pb.Matrix, len(base)),
+		quantities: make(map[string]uint64),
+		runs:       make(map[uint32]uint64),
+	}
+	for field, data := range base {
+		m := &pb.Matrix{}
+		if err := proto.Unmarshal([]byte(data), m); err != nil {
+			return nil, err
+		}
+		aggregate.base[field] = m
+	}
+	return aggregate, nil
+}
+
+// aggregate adds the reports appended since the last ones aggregated to aggregate.
+func (l *LiveHouseEmbedded) aggregate(ctx context.Context, server pb.Server, aggregate *liveAggregate) error {
+	start := aggregate.lastId
+	if start != "-" {
+		start = "(" + start
+	}
+	entries, err := l.Redis.XRange(ctx, l.reportsKey(server), start, "+").Result()
+	if err != nil {
+		return errors.Wrap(err, "service: livehouse: failed to get reports")
+	}
+	for _, entry := range entries {
+		aggregate.lastId = entry.ID
+
+		data, ok := entry.Values["data"].(string)
+		if !ok {
+			continue
+		}
+		var r pb.Report
+		if err := proto.Unmarshal([]byte(data), &r); err != nil {
+			return err
+		}
+		if r.Generation < aggregate.generation {
+			continue
+		}
+		// reports pushed before reports carried their times count as a single run
+		times := r.Times
+		if times == 0 {
+			times = 1
+		}
+		// every report counts for the times of every item of its stage
+		aggregate.runs[r.StageId] += times
+		for _, d := range r.Drops {
+			aggregate.quantities[matrixField(r.StageId, d.ItemId)] += d.Quantity
+		}
+	}
+	return nil
+}
+
+func (a *liveAggregate) matrix(server pb.Server) *LiveMatrix {
+	matrix := &LiveMatrix{
+		Server:     server,
+		Generation: a.generation,
+		Matrix:     make([]*pb.Matrix, 0, len(a.base)+len(a.quantities)),
+	}
+	for field, base := range a.base {
+		matrix.Matrix = append(matrix.Matrix, &pb.Matrix{
+			StageId:  base.StageId,
+			ItemId:   base.ItemId,
+			Quantity: base.Quantity + a.quantities[field],
+			Times:    base.Times + a.runs[base.StageId],
+		})
+	}
+	for field, quantity := range a.quantities {
+		if _, ok := a.base[field]; ok {
+			continue
+		}
+		stageId, itemId := parseMatrixField(field)
+		matrix.Matrix = append(matrix.Matrix, &pb.Matrix{
+			StageId:  stageId,
+			ItemId:   itemId,
+			Quantity: quantity,
+			Times:    a.runs[stageId],
+		})
+	}
+	return matrix
+}
+
+func (l *LiveHouseEmbedded) generationsKey(server pb.Server) string {
+	return LiveHouseRedisPrefix + server.String() + ":generations"
+}
+
+func (l *LiveHouseEmbedded) matrixKey(server pb.Server, generation uint64) string {
+	return LiveHouseRedisPrefix + server.String() + ":matrix:" + strconv.FormatUint(generation, 10)
+}
+
+func (l *LiveHouseEmbedded) reportsKey(server pb.Server) string {
+	return LiveHouseRedisPrefix + server.String() + ":reports"
+}
+
+func matrixField(stageId, itemId uint32) string {
+	return strconv.FormatUint(uint64(stageId), 10) + ":" + strconv.FormatUint(uint64(itemId), 10)
+}
+
+func parseMatrixField(field string) (uint32, uint32) {
+	stage, item, _ := strings.Cut(field, ":")
+	stageId, _ := strconv.ParseUint(stage, 10, 32)
+	itemId, _ := strconv.ParseUint(item, 10, 32)
+	return uint32(stageId), uint32(itemId)
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"exusiai.dev/backend-next/internal/model/pb"
+)
+
+func TestLiveHouseEmbeddedLiveMatrix(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l := &LiveHouseEmbedded{
+		Redis:       redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		enabled:     true,
+		generations: 2,
+		aggregates:  make(map[pb.Server]*liveAggregate),
+	}
+	ctx := context.Background()
+	server := pb.Server_CN
+
+	pushMatrix := func(generation uint64) {
+		t.Helper()
+		_, err := l.PushMatrixBatch(ctx, &pb.MatrixBatchRequest{
+			Server:     server,
+			Generation: generation,
+			Matrix:     []*pb.Matrix{{StageId: 1, ItemId: 1, Quantity: 10, Times: 5}},
+		})
+		require.NoError(t, err)
+	}
+	pushReport := func(generation, times uint64, drops ...*pb.Drop) {
+		t.Helper()
+		_, err := l.PushReportBatch(ctx, &pb.ReportBatchRequest{Reports: []*pb.Report{
+			{Server: server, Generation: generation, StageId: 1, Times: times, Drops: drops},
+		}})
+		require.NoError(t, err)
+	}
+	liveMatrix := func() (uint64, []*pb.Matrix) {
+		t.Helper()
+		matrix, err := l.LiveMatrix(ctx, server)
+		require.NoError(t, err)
+		sort.Slice(matrix.Matrix, func(i, j int) bool {
+			return matrix.Matrix[i].ItemId < matrix.Matrix[j].ItemId
+		})
+		return matrix.Generation, matrix.Matrix
+	}
+
+	_, err := l.LiveMatrix(ctx, server)
+	assert.ErrorIs(t, err, ErrLiveMatrixUnavailable)
+
+	generation := uint64(time.Now().Add(-time.Minute).UnixMilli())
+	pushMatrix(generation)
+	// accounted for in the base already
+	pushReport(generation-1, 1, &pb.Drop{ItemId: 1, Quantity: 100})
+	pushReport(generation, 2, &pb.Drop{ItemId: 1, Quantity: 3}, &pb.Drop{ItemId: 2, Quantity: 1})
+
+	g, matrix := liveMatrix()
+	assert.Equal(t, generation, g)
+	assert.Equal(t, []*pb.Matrix{
+		{StageId: 1, ItemId: 1, Quantity: 13, Times: 7},
+		{StageId: 1, ItemId: 2, Quantity: 1, Times: 2},
+	}, matrix)
+
+	t.Run("reports appended since are aggregated incrementally", func(t *testing.T) {
+		// reports without times count as a single run
+		pushReport(generation+1, 0, &pb.Drop{ItemId: 1, Quantity: 1})
+
+		_, matrix := liveMatrix()
+		assert.Equal(t, []*pb.Matrix{
+			{StageId: 1, ItemId: 1, Quantity: 14, Times: 8},
+			{StageId: 1, ItemId: 2, Quantity: 1, Times: 3},
+		}, matrix)
+	})
+
+	t.Run("a new generation trims the reports accounted for in its base", func(t *testing.T) {
+		next := uint64(time.Now().Add(time.Second).UnixMilli())
+		pushMatrix(next)
+
+		entries, err := l.Redis.XLen(ctx, l.reportsKey(server)).Result()
+		require.NoError(t, err)
+		assert.Zero(t, entries)
+
+		pushReport(next, 1, &pb.Drop{ItemId: 1, Quantity: 2})
+		g, matrix := liveMatrix()
+		assert.Equal(t, next, g)
+		assert.Equal(t, []*pb.Matrix{{StageId: 1, ItemId: 1, Quantity: 12, Times: 6}}, matrix)
+	})
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"exusiai.dev/backend-next/internal/model/pb"
+)
+
+func TestLiveHouseSpillLimit(t *testing.T) {
+	mr := miniredis.RunT(t)
+	l := &LiveHouse{
+		Redis:    redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		spill:    true,
+		spillMax: 2,
+	}
+
+	for stageId := uint32(1); stageId <= 3; stageId++ {
+		l.giveUp(&pb.ReportBatchRequest{Reports: []*pb.Report{{StageId: stageId}}})
+	}
+
+	spilled, err := mr.List(LiveHouseSpillRedisKey)
+	require.NoError(t, err)
+	require.Len(t, spilled, 2)
+	for i, stageId := range []uint32{2, 3} {
+		var batch pb.ReportBatchRequest
+		require.NoError(t, proto.Unmarshal([]byte(spilled[i]), &batch))
+		assert.Equal(t, stageId, batch.Reports[0].StageId, "the oldest batches should be dropped")
+	}
+}
+
+func TestLiveHouseCheckConfig(t *testing.T) {
+	l := &LiveHouse{
+		Mode:           LiveHouseModeEmbedded,
+		Client:         &LiveHouseEmbedded{},
+		overflowPolicy: "drop-oldest",
+	}
+	assert.Error(t, l.checkConfig(), "zero retry attempts should be rejected")
+
+	l.retryAttempts = 1
+	l.spill = true
+	assert.Error(t, l.checkConfig(), "spilling without a limit should be rejected")
+}
@@ -2,16 +2,20 @@ package service
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/ahmetb/go-linq/v3"
 	"github.com/pkg/errors"
+	"github.com/samber/lo"
 	"gopkg.in/guregu/null.v3"
 
 	"exusiai.dev/backend-next/internal/model"
 	"exusiai.dev/backend-next/internal/model/cache"
+	"exusiai.dev/backend-next/internal/model/types"
 	modelv2 "exusiai.dev/backend-next/internal/model/v2"
 	"exusiai.dev/backend-next/internal/pkg/async"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
 	"exusiai.dev/backend-next/internal/pkg/wrap"
 	"exusiai.dev/backend-next/internal/util"
 	"exusiai.dev/gommon/constant"
@@ -51,7 +55,7 @@ func NewPatternMatrix(
 func (s *PatternMatrix) GetShimLatestPatternMatrixResults(ctx context.Context, server string, accountId null.Int, sourceCategory string,
 ) (*modelv2.PatternMatrixQueryResult, error) {
 	valueFunc := func() (*modelv2.PatternMatrixQueryResult, error) {
-		queryResult, err := s.getLatestPatternMatrixResults(ctx, server, accountId, sourceCategory)
+		queryResult, err := s.getPatternMatrixResults(ctx, server, false, nil, accountId, sourceCategory)
 		if err != nil {
 			return nil, err
 		}
@@ -64,11 +68,11 @@ func (s *PatternMatrix) GetShimLatestPatternMatrixResults(ctx context.Context, s
 
 	var results modelv2.PatternMatrixQueryResult
 	if !accountId.Valid {
-		calculated, err := cache.ShimLatestPatternMatrixResults.MutexGetSet(server, &results, valueFunc, 24*time.Hour)
+		key := server + constant.CacheSep + sourceCategory
+		calculated, err := cache.ShimLatestPatternMatrixResults.MutexGetSet(key, &results, valueFunc, 24*time.Hour)
 		if err != nil {
 			return nil, err
 		} else if calculated {
-			key := server + constant.CacheSep + sourceCategory
 			cache.LastModifiedTime.Set("[shimLatestPatternMatrixResults#server|sourceCategory:"+key+"]", time.Now(), 0)
 		}
 		return &results, nil
@@ -77,35 +81,119 @@ func (s *PatternMatrix) GetShimLatestPatternMatrixResults(ctx context.Context, s
 	}
 }
 
-func (s *PatternMatrix) RefreshAllPatternMatrixElements(ctx context.Context, server string, sourceCategories []string) error {
-	timeRangesMap, err := s.TimeRangeService.GetTimeRangesMap(ctx, server)
+// Cache: shimMaxAccumulablePatternMatrixResults#server|sourceCategory:{server}|{sourceCategory}, 24hrs, records last modified time
+func (s *PatternMatrix) GetShimMaxAccumulablePatternMatrixResults(ctx context.Context, server string, accountId null.Int, sourceCategory string,
+) (*modelv2.PatternMatrixQueryResult, error) {
+	valueFunc := func() (*modelv2.PatternMatrixQueryResult, error) {
+		queryResult, err := s.getPatternMatrixResults(ctx, server, true, nil, accountId, sourceCategory)
+		if err != nil {
+			return nil, err
+		}
+		slowResults, err := s.applyShimForPatternMatrixQuery(ctx, queryResult)
+		if err != nil {
+			return nil, err
+		}
+		return slowResults, nil
+	}
+
+	var results modelv2.PatternMatrixQueryResult
+	if !accountId.Valid {
+		key := server + constant.CacheSep + sourceCategory
+		calculated, err := cache.ShimMaxAccumulablePatternMatrixResults.MutexGetSet(key, &results, valueFunc, 24*time.Hour)
+		if err != nil {
+			return nil, err
+		} else if calculated {
+			cache.LastModifiedTime.Set("[shimMaxAccumulablePatternMatrixResults#server|sourceCategory:"+key+"]", time.Now(), 0)
+		}
+		return &results, nil
+	} else {
+		return valueFunc()
+	}
+}
+
+// GetShimPatternMatrixResults returns the pattern matrix over either the latest or the max-accumulable time range of every stage,
+// narrowed down by the filters in query. Only results without any filters are cached.
+func (s *PatternMatrix) GetShimPatternMatrixResults(ctx context.Context, server string, query *types.PatternMatrixQuery, accountId null.Int, sourceCategory string,
+) (*modelv2.PatternMatrixQueryResult, error) {
+	if !query.HasFilters() {
+		if query.Accumulable {
+			return s.GetShimMaxAccumulablePatternMatrixResults(ctx, server, accountId, sourceCategory)
+		}
+		return s.GetShimLatestPatternMatrixResults(ctx, server, accountId, sourceCategory)
+	}
+
+	queryResult, err := s.getPatternMatrixResults(ctx, server, query.Accumulable, query, accountId, sourceCategory)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	allTimeRanges, err := s.TimeRangeService.GetLatestTimeRangesByServer(ctx, server)
+	return s.applyShimForPatternMatrixQuery(ctx, queryResult)
+}
+
+func (s *PatternMatrix) GetShimCustomizedPatternMatrixResults(
+	ctx context.Context, server string, timeRange *model.TimeRange, stageIds []int, accountId null.Int, sourceCategory string,
+) (*modelv2.PatternMatrixQueryResult, error) {
+	customizedPatternMatrixQueryResult, err := s.QueryPatternMatrix(ctx, server, []*model.TimeRange{timeRange}, stageIds, accountId, sourceCategory)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return s.applyShimForPatternMatrixQuery(ctx, customizedPatternMatrixQueryResult)
+}
+
+// calc PatternMatrixQueryResult for customized conditions
+func (s *PatternMatrix) QueryPatternMatrix(
+	ctx context.Context, server string, timeRanges []*model.TimeRange, stageIdFilter []int, accountId null.Int, sourceCategory string,
+) (*model.PatternMatrixQueryResult, error) {
 	excludeStageIdsSet, err := s.getExcludeStageIdsSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	linq.From(stageIdFilter).WhereT(func(stageId int) bool {
+		_, ok := excludeStageIdsSet[stageId]
+		return !ok
+	}).ToSlice(&stageIdFilter)
+
+	result := &model.PatternMatrixQueryResult{
+		PatternMatrix: make([]*model.OnePatternMatrixElement, 0),
+	}
+	if len(stageIdFilter) == 0 {
+		return result, nil
+	}
+
+	// customized time ranges have no range id, so we calc them one by one and attach the time range directly
+	for _, timeRange := range timeRanges {
+		elements, err := s.calcPatternMatrixForTimeRanges(ctx, server, []*model.TimeRange{timeRange}, stageIdFilter, accountId, sourceCategory)
+		if err != nil {
+			return nil, err
+		}
+		for _, element := range elements {
+			result.PatternMatrix = append(result.PatternMatrix, &model.OnePatternMatrixElement{
+				StageID:   element.StageID,
+				PatternID: element.PatternID,
+				Quantity:  element.Quantity,
+				Times:     element.Times,
+				TimeRange: timeRange,
+			})
+		}
+	}
+	return result, nil
+}
+
+func (s *PatternMatrix) RefreshAllPatternMatrixElements(ctx context.Context, server string, sourceCategories []string) error {
+	timeRangesMap, err := s.TimeRangeService.GetTimeRangesMap(ctx, server)
+	if err != nil {
+		return err
+	}
+	stageTimeRanges, err := s.getRefreshPatternMatrixTimeRanges(ctx, server)
 	if err != nil {
 		return err
 	}
-	stageIdsTuples := wrap.TuplePtrsFromMap(s.getStageIdsMapByTimeRange(allTimeRanges))
+	stageIdsTuples := wrap.TuplePtrsFromMap(s.getStageIdsMapByTimeRange(stageTimeRanges))
 
 	elements, err := async.FlatMap(stageIdsTuples, constant.WorkerParallelism, func(tuple *wrap.Tuple[int, []int]) ([]*model.PatternMatrixElement, error) {
 		timeRanges := []*model.TimeRange{timeRangesMap[tuple.Key]}
 		currentBatch := make([]*model.PatternMatrixElement, 0)
 		for _, sourceCategory := range sourceCategories {
-			stageIds := tuple.Val
-			// exclude some stages (gachabox, recruit) before calc
-			linq.From(stageIds).WhereT(func(stageId int) bool {
-				_, ok := excludeStageIdsSet[stageId]
-				return !ok
-			}).ToSlice(&stageIds)
-			if len(stageIds) == 0 {
-				continue
-			}
-			results, err := s.calcPatternMatrixForTimeRanges(ctx, server, timeRanges, stageIds, null.NewInt(0, false), sourceCategory)
+			results, err := s.calcPatternMatrixForTimeRanges(ctx, server, timeRanges, tuple.Val, null.NewInt(0, false), sourceCategory)
 			if err != nil {
 				return nil, err
 			}
@@ -120,24 +208,178 @@ func (s *PatternMatrix) RefreshAllPatternMatrixElements(ctx context.Context, ser
 	if err := s.PatternMatrixElementService.BatchSaveElements(ctx, elements, server); err != nil {
 		return err
 	}
-	return cache.ShimLatestPatternMatrixResults.Delete(server)
+	for _, sourceCategory := range sourceCategories {
+		if err := cache.ShimMaxAccumulablePatternMatrixResults.Delete(server + constant.CacheSep + sourceCategory); err != nil {
+			return err
+		}
+		if err := cache.ShimLatestPatternMatrixResults.Delete(server + constant.CacheSep + sourceCategory); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s *PatternMatrix) getLatestPatternMatrixResults(ctx context.Context, server string, accountId null.Int, sourceCategory string) (*model.PatternMatrixQueryResult, error) {
-	patternMatrixElements, err := s.getLatestPatternMatrixElements(ctx, server, accountId, sourceCategory)
+// getRefreshPatternMatrixTimeRanges returns the time ranges to calculate pattern matrix elements over for every stage
+// not excluded from the pattern matrix. Every stage has its latest time range, and only the stages accumulable over
+// more than one time range have their other max-accumulable time ranges, as each distinct time range costs the
+// worker another set of queries.
+func (s *PatternMatrix) getRefreshPatternMatrixTimeRanges(ctx context.Context, server string) (map[int][]*model.TimeRange, error) {
+	stageTimeRanges, err := s.getPatternMatrixTimeRanges(ctx, server, false)
+	if err != nil {
+		return nil, err
+	}
+	accumulableTimeRanges, err := s.getPatternMatrixTimeRanges(ctx, server, true)
 	if err != nil {
 		return nil, err
 	}
-	return s.convertPatternMatrixElementsToDropPatternQueryResult(ctx, server, patternMatrixElements)
+	excludeStageIdsSet, err := s.getExcludeStageIdsSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for stageId := range stageTimeRanges {
+		// exclude some stages (gachabox, recruit) before calc
+		if _, ok := excludeStageIdsSet[stageId]; ok {
+			delete(stageTimeRanges, stageId)
+			continue
+		}
+		if timeRanges := accumulableTimeRanges[stageId]; len(timeRanges) > 1 {
+			stageTimeRanges[stageId] = timeRanges
+		}
+	}
+	return stageTimeRanges, nil
 }
 
-func (s *PatternMatrix) getLatestPatternMatrixElements(ctx context.Context, server string, accountId null.Int, sourceCategory string) ([]*model.PatternMatrixElement, error) {
-	if accountId.Valid {
-		timeRangesMap, err := s.TimeRangeService.GetTimeRangesMap(ctx, server)
+func (s *PatternMatrix) getPatternMatrixResults(
+	ctx context.Context, server string, accumulable bool, query *types.PatternMatrixQuery, accountId null.Int, sourceCategory string,
+) (*model.PatternMatrixQueryResult, error) {
+	stageTimeRanges, err := s.getPatternMatrixTimeRanges(ctx, server, accumulable)
+	if err != nil {
+		return nil, err
+	}
+	if query != nil {
+		stageTimeRanges, err = s.filterPatternMatrixTimeRanges(ctx, stageTimeRanges, query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	patternMatrixElements, err := s.getPatternMatrixElements(ctx, server, stageTimeRanges, accountId, sourceCategory)
+	if err != nil {
+		return nil, err
+	}
+	minTimes := 0
+	if query != nil {
+		minTimes = query.MinTimes
+	}
+	return s.convertPatternMatrixElementsToCombinedQueryResult(patternMatrixElements, stageTimeRanges, minTimes), nil
+}
+
+// getPatternMatrixTimeRanges returns the time ranges to combine pattern matrix elements over for every stage.
+// If accumulable is false, only the latest time range of each stage is returned. Otherwise, the time ranges are
+// the ones in which every item dropped in the latest time range is accumulable, i.e. the intersection of their
+// max-accumulable time ranges.
+func (s *PatternMatrix) getPatternMatrixTimeRanges(ctx context.Context, server string, accumulable bool) (map[int][]*model.TimeRange, error) {
+	latestTimeRanges, err := s.TimeRangeService.GetLatestTimeRangesByServer(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[int][]*model.TimeRange, len(latestTimeRanges))
+	if !accumulable {
+		for stageId, timeRange := range latestTimeRanges {
+			results[stageId] = []*model.TimeRange{timeRange}
+		}
+		return results, nil
+	}
+
+	maxAccumulableTimeRanges, err := s.TimeRangeService.GetMaxAccumulableTimeRangesByServer(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	for stageId, latestTimeRange := range latestTimeRanges {
+		var intersection map[int]*model.TimeRange
+		for _, timeRanges := range maxAccumulableTimeRanges[stageId] {
+			current := lo.KeyBy(timeRanges, func(timeRange *model.TimeRange) int { return timeRange.RangeID })
+			// items no longer dropped in the latest time range don't affect the pattern
+			if _, ok := current[latestTimeRange.RangeID]; !ok {
+				continue
+			}
+			if intersection == nil {
+				intersection = current
+				continue
+			}
+			for rangeId := range intersection {
+				if _, ok := current[rangeId]; !ok {
+					delete(intersection, rangeId)
+				}
+			}
+		}
+		if len(intersection) == 0 {
+			results[stageId] = []*model.TimeRange{latestTimeRange}
+		} else {
+			results[stageId] = lo.Values(intersection)
+		}
+	}
+	return results, nil
+}
+
+func (s *PatternMatrix) filterPatternMatrixTimeRanges(
+	ctx context.Context, stageTimeRanges map[int][]*model.TimeRange, query *types.PatternMatrixQuery,
+) (map[int][]*model.TimeRange, error) {
+	var stageIdsSet map[int]struct{}
+	if query.StageFilter != "" {
+		stagesMapByArkId, err := s.StageService.GetStagesMapByArkId(ctx)
 		if err != nil {
 			return nil, err
 		}
-		allTimeRanges, err := s.TimeRangeService.GetLatestTimeRangesByServer(ctx, server)
+		stageIdsSet = make(map[int]struct{})
+		for _, arkStageId := range strings.Split(query.StageFilter, ",") {
+			stage, ok := stagesMapByArkId[arkStageId]
+			if !ok {
+				return nil, pgerr.ErrInvalidReq.Msg("unknown stage id in stageFilter: %s", arkStageId)
+			}
+			stageIdsSet[stage.StageID] = struct{}{}
+		}
+	}
+
+	results := make(map[int][]*model.TimeRange)
+	for stageId, timeRanges := range stageTimeRanges {
+		if stageIdsSet != nil {
+			if _, ok := stageIdsSet[stageId]; !ok {
+				continue
+			}
+		}
+		filtered := lo.Filter(timeRanges, func(timeRange *model.TimeRange, _ int) bool {
+			return timeRangeMatchesPatternMatrixQuery(timeRange, query)
+		})
+		if len(filtered) > 0 {
+			results[stageId] = filtered
+		}
+	}
+	return results, nil
+}
+
+// timeRangeMatchesPatternMatrixQuery returns whether timeRange is within the start and end time filters of query.
+// Time ranges still open, whose end time is FakeEndTimeMilli, have not ended yet, so they are kept by the end time
+// filter as long as they start before it.
+func timeRangeMatchesPatternMatrixQuery(timeRange *model.TimeRange, query *types.PatternMatrixQuery) bool {
+	if query.StartTime > 0 && timeRange.StartTime.UnixMilli() < query.StartTime {
+		return false
+	}
+	if query.EndTime > 0 {
+		if timeRange.EndTime.UnixMilli() == constant.FakeEndTimeMilli {
+			return timeRange.StartTime.UnixMilli() < query.EndTime
+		}
+		return timeRange.EndTime.UnixMilli() <= query.EndTime
+	}
+	return true
+}
+
+// For global, get elements from DB; For personal, calc elements
+func (s *PatternMatrix) getPatternMatrixElements(
+	ctx context.Context, server string, stageTimeRanges map[int][]*model.TimeRange, accountId null.Int, sourceCategory string,
+) ([]*model.PatternMatrixElement, error) {
+	if accountId.Valid {
+		timeRangesMap, err := s.TimeRangeService.GetTimeRangesMap(ctx, server)
 		if err != nil {
 			return nil, err
 		}
@@ -146,7 +388,7 @@ func (s *PatternMatrix) getLatestPatternMatrixElements(ctx context.Context, serv
 			return nil, err
 		}
 
-		stageIdsMap := s.getStageIdsMapByTimeRange(allTimeRanges)
+		stageIdsMap := s.getStageIdsMapByTimeRange(stageTimeRanges)
 		elements := make([]*model.PatternMatrixElement, 0)
 		for rangeId, stageIds := range stageIdsMap {
 			// exclude some stages (gachabox, recruit) before calc
@@ -263,13 +505,12 @@ func (s *PatternMatrix) combineQuantityAndTimesResults(
 	return combinedResults
 }
 
-func (s *PatternMatrix) getStageIdsMapByTimeRange(timeRangesMap map[int]*model.TimeRange) map[int][]int {
+func (s *PatternMatrix) getStageIdsMapByTimeRange(stageTimeRanges map[int][]*model.TimeRange) map[int][]int {
 	results := make(map[int][]int)
-	for stageId, timeRange := range timeRangesMap {
-		if _, ok := results[timeRange.RangeID]; !ok {
-			results[timeRange.RangeID] = make([]int, 0)
+	for stageId, timeRanges := range stageTimeRanges {
+		for _, timeRange := range timeRanges {
+			results[timeRange.RangeID] = append(results[timeRange.RangeID], stageId)
 		}
-		results[timeRange.RangeID] = append(results[timeRange.RangeID], stageId)
 	}
 	return results
 }
@@ -295,28 +536,51 @@ func (s *PatternMatrix) getExcludeStageIdsSet(ctx context.Context) (map[int]stru
 	return excludeStageIdsSet, nil
 }
 
-func (s *PatternMatrix) convertPatternMatrixElementsToDropPatternQueryResult(
-	ctx context.Context, server string, patternMatrixElements []*model.PatternMatrixElement,
-) (*model.PatternMatrixQueryResult, error) {
-	timeRangesMap, err := s.TimeRangeService.GetTimeRangesMap(ctx, server)
-	if err != nil {
-		return nil, err
+// convertPatternMatrixElementsToCombinedQueryResult combines the elements of every stage over its time ranges in stageTimeRanges.
+// Elements of other time ranges are ignored, and stages with total times less than minTimes are left out.
+func (s *PatternMatrix) convertPatternMatrixElementsToCombinedQueryResult(
+	patternMatrixElements []*model.PatternMatrixElement, stageTimeRanges map[int][]*model.TimeRange, minTimes int,
+) *model.PatternMatrixQueryResult {
+	timesMap := make(map[int]map[int]int)
+	quantityMap := make(map[int]map[int]int)
+	for _, el := range patternMatrixElements {
+		timeRanges, ok := stageTimeRanges[el.StageID]
+		if !ok || !lo.ContainsBy(timeRanges, func(timeRange *model.TimeRange) bool { return timeRange.RangeID == el.RangeID }) {
+			continue
+		}
+		if _, ok := timesMap[el.StageID]; !ok {
+			timesMap[el.StageID] = make(map[int]int)
+			quantityMap[el.StageID] = make(map[int]int)
+		}
+		// times is the same for every pattern of a stage in one time range
+		timesMap[el.StageID][el.RangeID] = el.Times
+		quantityMap[el.StageID][el.PatternID] += el.Quantity
 	}
 
 	result := &model.PatternMatrixQueryResult{
 		PatternMatrix: make([]*model.OnePatternMatrixElement, 0),
 	}
-	for _, patternMatrixElement := range patternMatrixElements {
-		timeRange := timeRangesMap[patternMatrixElement.RangeID]
-		result.PatternMatrix = append(result.PatternMatrix, &model.OnePatternMatrixElement{
-			StageID:   patternMatrixElement.StageID,
-			PatternID: patternMatrixElement.PatternID,
-			Quantity:  patternMatrixElement.Quantity,
-			Times:     patternMatrixElement.Times,
-			TimeRange: timeRange,
-		})
+	for stageId, quantities := range quantityMap {
+		times := lo.Sum(lo.Values(timesMap[stageId]))
+		if times < minTimes {
+			continue
+		}
+		timeRanges := stageTimeRanges[stageId]
+		combinedTimeRange := &model.TimeRange{
+			StartTime: lo.MinBy(timeRanges, func(a, b *model.TimeRange) bool { return a.StartTime.Before(*b.StartTime) }).StartTime,
+			EndTime:   lo.MaxBy(timeRanges, func(a, b *model.TimeRange) bool { return a.EndTime.After(*b.EndTime) }).EndTime,
+		}
+		for patternId, quantity := range quantities {
+			result.PatternMatrix = append(result.PatternMatrix, &model.OnePatternMatrixElement{
+				StageID:   stageId,
+				PatternID: patternId,
+				Quantity:  quantity,
+				Times:     times,
+				TimeRange: combinedTimeRange,
+			})
+		}
 	}
-	return result, nil
+	return result
 }
 
 func (s *PatternMatrix) applyShimForPatternMatrixQuery(ctx context.Context, queryResult *model.PatternMatrixQueryResult) (*modelv2.PatternMatrixQueryResult, error) {
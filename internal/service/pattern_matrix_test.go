@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"exusiai.dev/gommon/constant"
+	"github.com/stretchr/testify/assert"
+
+	"exusiai.dev/backend-next/internal/model"
+	"exusiai.dev/backend-next/internal/model/types"
+)
+
+func TestTimeRangeMatchesPatternMatrixQuery(t *testing.T) {
+	at := func(ms int64) *time.Time {
+		v := time.UnixMilli(ms)
+		return &v
+	}
+	closed := &model.TimeRange{StartTime: at(1000), EndTime: at(2000)}
+	open := &model.TimeRange{StartTime: at(3000), EndTime: at(constant.FakeEndTimeMilli)}
+
+	tests := []struct {
+		name      string
+		query     types.PatternMatrixQuery
+		timeRange *model.TimeRange
+		want      bool
+	}{
+		{"no filters", types.PatternMatrixQuery{}, closed, true},
+		{"starts after start", types.PatternMatrixQuery{StartTime: 1000}, closed, true},
+		{"starts before start", types.PatternMatrixQuery{StartTime: 1001}, closed, false},
+		{"ends at end", types.PatternMatrixQuery{EndTime: 2000}, closed, true},
+		{"ends after end", types.PatternMatrixQuery{EndTime: 1999}, closed, false},
+		{"open range starting before end", types.PatternMatrixQuery{EndTime: 4000}, open, true},
+		{"open range starting after end", types.PatternMatrixQuery{EndTime: 2500}, open, false},
+		{"open range within start and end", types.PatternMatrixQuery{StartTime: 3000, EndTime: time.Now().UnixMilli()}, open, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, timeRangeMatchesPatternMatrixQuery(tt.timeRange, &tt.query))
+		})
+	}
+}
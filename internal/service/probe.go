@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model"
+	"exusiai.dev/backend-next/internal/model/pb"
+	"exusiai.dev/backend-next/internal/model/types"
+	"exusiai.dev/backend-next/internal/pkg/dstructs"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/repo"
+)
+
+const (
+	// probeBatchSize is the maximum number of probe events saved in a single insert, or purged in a single delete.
+	probeBatchSize = 1000
+
+	// probePurgeInterval is the interval at which the probe events beyond the retention are purged.
+	probePurgeInterval = time.Hour
+
+	// ProbeMaxRecentDuration is the maximum time window of the probe aggregates.
+	ProbeMaxRecentDuration = 30 * 24 * time.Hour
+)
+
+// Probe ingests frontend telemetry events. Events are queued and saved in batches, with the language of the
+// frontend and the country of the client instead of its IP.
+type Probe struct {
+	ProbeEventRepo *repo.ProbeEvent
+	GeoIPService   *GeoIP
+
+	q             *dstructs.BoundedQueue[*model.ProbeEvent]
+	flushInterval time.Duration
+	retention     time.Duration
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+func NewProbe(probeEventRepo *repo.ProbeEvent, geoIPService *GeoIP, conf *appconfig.Config, lc fx.Lifecycle) *Probe {
+	s := &Probe{
+		ProbeEventRepo: probeEventRepo,
+		GeoIPService:   geoIPService,
+		q:              dstructs.NewBoundedQueue[*model.ProbeEvent](conf.ProbeQueueSize, dstructs.DropNewest),
+		flushInterval:  conf.ProbeFlushInterval,
+		retention:      conf.ProbeRetention,
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go s.worker()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(s.stop)
+			select {
+			case <-s.done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+
+	return s
+}
+
+// Ingest queues the events sent by a client at ip, whose Accept-Language header is acceptLanguage.
+func (s *Probe) Ingest(ip, acceptLanguage string, events []*types.ProbeEvent) {
+	var country string
+	if c, err := s.GeoIPService.Country(ip); err == nil && c != nil {
+		country = c.Country.IsoCode
+	}
+	now := time.Now()
+
+	for _, e := range events {
+		language := e.Language
+		if language == "" {
+			language = probeLanguage(acceptLanguage).String()
+		}
+		event := &model.ProbeEvent{
+			Type:      pb.MessageType(e.Type).String(),
+			Language:  language,
+			Country:   country,
+			Server:    e.Server,
+			Path:      e.Path,
+			StageID:   e.StageID,
+			ItemID:    e.ItemID,
+			Query:     strings.ToLower(strings.TrimSpace(e.Query)),
+			CreatedAt: now,
+		}
+		if e.Shape != nil {
+			event.Shape = probeQueryShape(e.Shape)
+		}
+		if s.q.Push(event) {
+			log.Debug().
+				Str("evt.name", "probe.dropped").
+				Msg("probe queue is full: dropping event")
+		}
+	}
+}
+
+func (s *Probe) worker() {
+	defer close(s.done)
+
+	t := time.NewTicker(s.flushInterval)
+	defer t.Stop()
+	purge := time.NewTicker(probePurgeInterval)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.flush()
+		case <-purge.C:
+			s.purge()
+		case <-s.stop:
+			s.flush()
+			return
+		}
+	}
+}
+
+func (s *Probe) flush() {
+	for s.q.Len() > 0 {
+		events := s.q.Flush(probeBatchSize)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		err := s.ProbeEventRepo.CreateProbeEvents(ctx, events)
+		cancel()
+		if err != nil {
+			log.Error().
+				Str("evt.name", "probe.save.failed").
+				Err(err).
+				Int("count", len(events)).
+				Msg("failed to save probe events")
+			return
+		}
+	}
+}
+
+// purge deletes the probe events created before the retention in batches. Every instance purges, as deleting the
+// same events again is harmless.
+func (s *Probe) purge() {
+	if s.retention <= 0 {
+		return
+	}
+	before := time.Now().Add(-s.retention)
+
+	var total int64
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		deleted, err := s.ProbeEventRepo.DeleteProbeEventsBefore(ctx, before, probeBatchSize)
+		cancel()
+		if err != nil {
+			log.Error().
+				Str("evt.name", "probe.purge.failed").
+				Err(err).
+				Int64("count", total).
+				Msg("failed to purge probe events beyond retention")
+			return
+		}
+		total += deleted
+		if deleted < probeBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		log.Info().
+			Str("evt.name", "probe.purge.success").
+			Int64("count", total).
+			Msg("purged probe events beyond retention")
+	}
+}
+
+// GetProbeAggregates returns the most frequent stages, items, search terms and advanced query shapes of the probe
+// events within the recent duration.
+func (s *Probe) GetProbeAggregates(ctx context.Context, recent string, limit int) (*model.ProbeAggregates, error) {
+	duration, err := time.ParseDuration(recent)
+	if err != nil {
+		return nil, pgerr.ErrInvalidReq.Msg("invalid duration: %s", err)
+	}
+	if duration > ProbeMaxRecentDuration || (s.retention > 0 && duration > s.retention) {
+		return nil, pgerr.ErrInvalidReq.Msg("duration is too long")
+	}
+	end := time.Now()
+	start := end.Add(-duration)
+
+	navigatedOrSearched := []string{pb.MessageType_PROBE_NAVIGATED.String(), pb.MessageType_PROBE_ENTERED_SEARCH_RESULT.String()}
+	stages, err := s.ProbeEventRepo.CountProbeEventsBy(ctx, "stage_id", navigatedOrSearched, start, limit)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.ProbeEventRepo.CountProbeEventsBy(ctx, "item_id", navigatedOrSearched, start, limit)
+	if err != nil {
+		return nil, err
+	}
+	searchTerms, err := s.ProbeEventRepo.CountProbeEventsBy(ctx, "query", []string{pb.MessageType_PROBE_ENTERED_SEARCH_RESULT.String()}, start, limit)
+	if err != nil {
+		return nil, err
+	}
+	queryShapes, err := s.ProbeEventRepo.CountProbeEventsBy(ctx, "shape", []string{pb.MessageType_PROBE_EXECUTED_ADVANCED_QUERY.String()}, start, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.ProbeAggregates{
+		StartTime:   start.UnixMilli(),
+		EndTime:     end.UnixMilli(),
+		Stages:      stages,
+		Items:       items,
+		SearchTerms: searchTerms,
+		QueryShapes: queryShapes,
+	}, nil
+}
+
+// probeLanguage maps the primary language of an Accept-Language header to a Language.
+func probeLanguage(acceptLanguage string) pb.Language {
+	tag, _, _ := strings.Cut(acceptLanguage, ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+	switch strings.ToLower(primary) {
+	case "zh":
+		return pb.Language_ZH_CN
+	case "en":
+		return pb.Language_EN_US
+	case "ja":
+		return pb.Language_JA_JP
+	case "ko":
+		return pb.Language_KO_KR
+	default:
+		return pb.Language_OTHER
+	}
+}
+
+// probeQueryShape renders a query shape, with the number of items bucketed to keep the shapes few.
+func probeQueryShape(shape *types.ProbeQueryShape) string {
+	var items string
+	switch {
+	case shape.Items <= 2:
+		items = fmt.Sprint(shape.Items)
+	case shape.Items <= 5:
+		items = "3-5"
+	default:
+		items = "6+"
+	}
+	category := shape.SourceCategory
+	if category == "" {
+		category = "all"
+	}
+	return fmt.Sprintf("queries=%d,items=%s,personal=%t,category=%s,timeRange=%t,interval=%t",
+		shape.Queries, items, shape.Personal, category, shape.TimeRange, shape.Interval)
+}
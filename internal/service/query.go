@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"math"
+	"sort"
+	"time"
+
+	"exusiai.dev/gommon/constant"
+	"github.com/jinzhu/copier"
+	"github.com/samber/lo"
+	"gopkg.in/guregu/null.v3"
+
+	"exusiai.dev/backend-next/internal/model"
+	"exusiai.dev/backend-next/internal/model/types"
+	modelv2 "exusiai.dev/backend-next/internal/model/v2"
+	modelv3 "exusiai.dev/backend-next/internal/model/v3"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/util"
+)
+
+const QueryDefaultPageSize = 20
+
+var (
+	ErrQueryInvalidTimeWindow  = pgerr.ErrInvalidReq.Msg("invalid time window: start must be earlier than end")
+	ErrQueryInvalidCursor      = pgerr.ErrInvalidReq.Msg("invalid cursor: the cursor must be the nextCursor value returned by the previous page")
+	ErrQueryIntervalTooSmall   = pgerr.ErrInvalidReq.Msg("interval length must be greater than 1 hour")
+	ErrQueryIntervalTooLarge   = pgerr.ErrInvalidReq.Msg("interval length is too large")
+	ErrQueryUnknownStageOrItem = pgerr.ErrInvalidReq.Msg("unknown stage or item id in filters")
+)
+
+// Query serves arbitrary time-window queries which return drop matrix, pattern matrix and trend results
+// for one page of stages at a time.
+type Query struct {
+	DropMatrixService    *DropMatrix
+	PatternMatrixService *PatternMatrix
+	TrendService         *Trend
+	DropInfoService      *DropInfo
+	StageService         *Stage
+	ItemService          *Item
+}
+
+func NewQuery(
+	dropMatrixService *DropMatrix,
+	patternMatrixService *PatternMatrix,
+	trendService *Trend,
+	dropInfoService *DropInfo,
+	stageService *Stage,
+	itemService *Item,
+) *Query {
+	return &Query{
+		DropMatrixService:    dropMatrixService,
+		PatternMatrixService: patternMatrixService,
+		TrendService:         trendService,
+		DropInfoService:      dropInfoService,
+		StageService:         stageService,
+		ItemService:          itemService,
+	}
+}
+
+// queryPlan is a request resolved against the database ids, narrowed down to the stages of the current page.
+type queryPlan struct {
+	server         string
+	startTime      time.Time
+	endTime        time.Time
+	stageIds       []int
+	itemIds        []int
+	accountId      null.Int
+	sourceCategory string
+	include        map[string]bool
+	intervalLength time.Duration
+	intervalNum    int
+	nextCursor     null.String
+}
+
+func (s *Query) Execute(ctx context.Context, req *types.ArbitraryQueryRequest, accountId null.Int) (*modelv3.ArbitraryQueryResult, error) {
+	plan, err := s.plan(ctx, req, accountId)
+	if err != nil {
+		return nil, err
+	}
+	return s.execute(ctx, plan)
+}
+
+func (s *Query) plan(ctx context.Context, req *types.ArbitraryQueryRequest, accountId null.Int) (*queryPlan, error) {
+	plan := &queryPlan{
+		server:         req.Server,
+		startTime:      time.UnixMilli(req.StartTime),
+		endTime:        time.Now(),
+		accountId:      accountId,
+		sourceCategory: req.SourceCategory,
+		include:        make(map[string]bool),
+	}
+	if req.EndTime.Valid && req.EndTime.Int64 < plan.endTime.UnixMilli() {
+		plan.endTime = time.UnixMilli(req.EndTime.Int64)
+	}
+	if !plan.startTime.Before(plan.endTime) {
+		return nil, ErrQueryInvalidTimeWindow
+	}
+	if plan.sourceCategory == "" {
+		plan.sourceCategory = constant.SourceCategoryAll
+	}
+
+	include := req.Include
+	if len(include) == 0 {
+		include = []string{types.QueryResultMatrix, types.QueryResultPattern, types.QueryResultTrend}
+	}
+	for _, kind := range include {
+		plan.include[kind] = true
+	}
+
+	if plan.include[types.QueryResultTrend] {
+		plan.intervalLength = time.Hour * 24
+		if req.Interval.Valid {
+			// larger intervals overflow when converted to a time.Duration
+			if req.Interval.Int64 > math.MaxInt64/int64(time.Millisecond) {
+				return nil, ErrQueryIntervalTooLarge
+			}
+			// interval originally is in milliseconds, so we need to convert it to nanoseconds
+			plan.intervalLength = time.Duration(req.Interval.Int64 * 1e6).Round(time.Hour)
+		}
+		if plan.intervalLength.Hours() < 1 {
+			return nil, ErrQueryIntervalTooSmall
+		}
+		plan.intervalNum = int(plan.endTime.Sub(plan.startTime).Hours()) / int(plan.intervalLength.Hours())
+		if plan.intervalNum < 1 {
+			plan.intervalNum = 1
+		}
+		if plan.intervalNum > constant.MaxIntervalNum {
+			return nil, pgerr.ErrInvalidReq.Msg("too many sections: interval number is %d sections, which is larger than %d sections", plan.intervalNum, constant.MaxIntervalNum)
+		}
+	}
+
+	itemsMapByArkId, err := s.ItemService.GetItemsMapByArkId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, arkItemId := range req.ItemIDs {
+		item, ok := itemsMapByArkId[arkItemId]
+		if !ok {
+			return nil, ErrQueryUnknownStageOrItem
+		}
+		plan.itemIds = append(plan.itemIds, item.ItemID)
+	}
+
+	stages, err := s.candidateStages(ctx, plan, req.StageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	after := ""
+	if req.Cursor != "" {
+		after, err = decodeQueryCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = QueryDefaultPageSize
+	}
+	page := lo.Filter(stages, func(stage *model.Stage, _ int) bool {
+		return stage.ArkStageID > after
+	})
+	if len(page) > limit {
+		page = page[:limit]
+		plan.nextCursor = null.StringFrom(encodeQueryCursor(page[len(page)-1].ArkStageID))
+	}
+	plan.stageIds = lo.Map(page, func(stage *model.Stage, _ int) int {
+		return stage.StageID
+	})
+	return plan, nil
+}
+
+// candidateStages returns the stages the query covers, sorted by ark stage id so that the cursor is stable across pages.
+func (s *Query) candidateStages(ctx context.Context, plan *queryPlan, arkStageIds []string) ([]*model.Stage, error) {
+	stages := make([]*model.Stage, 0)
+	if len(arkStageIds) > 0 {
+		stagesMapByArkId, err := s.StageService.GetStagesMapByArkId(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, arkStageId := range lo.Uniq(arkStageIds) {
+			stage, ok := stagesMapByArkId[arkStageId]
+			if !ok {
+				return nil, ErrQueryUnknownStageOrItem
+			}
+			stages = append(stages, stage)
+		}
+	} else {
+		dropInfos, err := s.DropInfoService.GetDropInfosWithFilters(ctx, plan.server, []*model.TimeRange{plan.timeRange()}, nil, plan.itemIds)
+		if err != nil {
+			return nil, err
+		}
+		stagesMapById, err := s.StageService.GetStagesMapById(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, stageId := range util.GetStageIdsFromDropInfos(dropInfos) {
+			if stage, ok := stagesMapById[stageId]; ok {
+				stages = append(stages, stage)
+			}
+		}
+	}
+	sort.Slice(stages, func(i, j int) bool {
+		return stages[i].ArkStageID < stages[j].ArkStageID
+	})
+	return stages, nil
+}
+
+func (s *Query) execute(ctx context.Context, plan *queryPlan) (*modelv3.ArbitraryQueryResult, error) {
+	result := &modelv3.ArbitraryQueryResult{
+		NextCursor: plan.nextCursor,
+	}
+	if plan.include[types.QueryResultMatrix] {
+		result.Matrix = make([]*modelv2.OneDropMatrixElement, 0)
+	}
+	if plan.include[types.QueryResultPattern] {
+		result.Patterns = make([]*modelv3.OnePatternMatrixElement, 0)
+	}
+	if plan.include[types.QueryResultTrend] {
+		result.Trends = make(map[string]*modelv2.StageTrend)
+	}
+	// an empty stage id filter means "all stages" for the underlying queries, so we must return early here
+	if len(plan.stageIds) == 0 {
+		return result, nil
+	}
+
+	if plan.include[types.QueryResultMatrix] {
+		matrix, err := s.DropMatrixService.GetShimCustomizedDropMatrixResults(ctx, plan.server, plan.timeRange(), plan.stageIds, plan.itemIds, plan.accountId, plan.sourceCategory)
+		if err != nil {
+			return nil, err
+		}
+		result.Matrix = matrix.Matrix
+	}
+
+	if plan.include[types.QueryResultPattern] {
+		pattern, err := s.PatternMatrixService.GetShimCustomizedPatternMatrixResults(ctx, plan.server, plan.timeRange(), plan.stageIds, plan.accountId, plan.sourceCategory)
+		if err != nil {
+			return nil, err
+		}
+		if err := copier.Copy(&result.Patterns, pattern.PatternMatrix); err != nil {
+			return nil, err
+		}
+	}
+
+	if plan.include[types.QueryResultTrend] {
+		startTime := plan.startTime
+		trend, err := s.TrendService.GetShimCustomizedTrendResults(ctx, plan.server, &startTime, plan.intervalLength, plan.intervalNum, plan.stageIds, plan.itemIds, plan.accountId, plan.sourceCategory)
+		if err != nil {
+			return nil, err
+		}
+		result.Trends = trend.Trend
+	}
+
+	return result, nil
+}
+
+// estimatedCost estimates the cost of a planned query in "stage-days": the number of stages on the page multiplied
+// by the number of days covered by the time window, summed over every requested result kind. Trend results are
+// charged for every interval in addition, as each interval is aggregated separately.
+func (p *queryPlan) estimatedCost() int {
+	days := int(math.Ceil(p.endTime.Sub(p.startTime).Hours() / 24))
+	stages := len(p.stageIds)
+	cost := 0
+	if p.include[types.QueryResultMatrix] {
+		cost += stages * days
+	}
+	if p.include[types.QueryResultPattern] {
+		cost += stages * days
+	}
+	if p.include[types.QueryResultTrend] {
+		cost += stages * (days + p.intervalNum)
+	}
+	return cost
+}
+
+// timeRange returns a fresh time range for every call, as the underlying queries may modify its end time.
+func (p *queryPlan) timeRange() *model.TimeRange {
+	startTime := p.startTime
+	endTime := p.endTime
+	return &model.TimeRange{
+		StartTime: &startTime,
+		EndTime:   &endTime,
+	}
+}
+
+func encodeQueryCursor(arkStageId string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(arkStageId))
+}
+
+func decodeQueryCursor(cursor string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(decoded) == 0 {
+		return "", ErrQueryInvalidCursor
+	}
+	return string(decoded), nil
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/goccy/go-json"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+	"gopkg.in/guregu/null.v3"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model/types"
+	modelv3 "exusiai.dev/backend-next/internal/model/v3"
+	"exusiai.dev/backend-next/internal/pkg/jobqueue"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+)
+
+const (
+	QueryJobRedisPrefix = "query-job:"
+	// QueryJobQueueName names the queue of query jobs, kept out of QueryJobRedisPrefix so that its keys never
+	// collide with the job records.
+	QueryJobQueueName = "query-job-queue"
+)
+
+var ErrQueryJobQueueFull = pgerr.New(http.StatusServiceUnavailable, "QUERY_JOB_QUEUE_FULL", "too many query jobs are waiting to be run at the moment. please try again later")
+
+// queryJobRecord is the representation of a query job in Redis. AccountID is kept out of the job itself
+// so that it never leaks to the client, and is used to restrict personal query jobs to their owners. Request
+// is kept so that the job can be planned again by whichever instance runs it.
+type queryJobRecord struct {
+	modelv3.QueryJob
+	AccountID null.Int                     `json:"accountId"`
+	Request   *types.ArbitraryQueryRequest `json:"request"`
+}
+
+// QueryJob decides whether a v3 query runs inline or as an asynchronous job, and runs the jobs on a worker pool.
+// Job status and results are kept in Redis so that they can be polled from any instance. Jobs are queued in
+// Redis as well, and jobs interrupted by a shutdown are run again by the next instance to pick them up.
+type QueryJob struct {
+	Redis        *redis.Client
+	QueryService *Query
+
+	inlineMaxCost int
+	maxCost       int
+	timeout       time.Duration
+	resultTTL     time.Duration
+	q             *jobqueue.Queue
+}
+
+func NewQueryJob(redisClient *redis.Client, queryService *Query, conf *appconfig.Config, lc fx.Lifecycle) *QueryJob {
+	s := &QueryJob{
+		Redis:         redisClient,
+		QueryService:  queryService,
+		inlineMaxCost: conf.QueryInlineMaxCost,
+		maxCost:       conf.QueryMaxCost,
+		timeout:       conf.QueryJobTimeout,
+		resultTTL:     conf.QueryJobResultTTL,
+	}
+	s.q = jobqueue.New(redisClient, QueryJobQueueName, conf.QueryJobQueueSize, conf.QueryJobWorkers, s.run)
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			s.q.Start()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return s.q.Stop(ctx)
+		},
+	})
+
+	return s
+}
+
+// Submit plans the query and estimates its cost. Queries cheap enough are run inline and their result is returned,
+// more expensive ones are enqueued and the created job is returned instead. Queries exceeding the maximum cost are
+// rejected with an explanation.
+func (s *QueryJob) Submit(ctx context.Context, req *types.ArbitraryQueryRequest, accountId null.Int) (*modelv3.ArbitraryQueryResult, *modelv3.QueryJob, error) {
+	plan, err := s.QueryService.plan(ctx, req, accountId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cost := plan.estimatedCost()
+	if cost > s.maxCost {
+		return nil, nil, s.tooExpensiveError(plan, cost)
+	}
+	if cost <= s.inlineMaxCost {
+		result, err := s.QueryService.execute(ctx, plan)
+		if err != nil {
+			return nil, nil, err
+		}
+		return result, nil, nil
+	}
+
+	record := &queryJobRecord{
+		QueryJob: modelv3.QueryJob{
+			JobID:         strings.ToLower(ulid.Make().String()),
+			Status:        modelv3.QueryJobStatusQueued,
+			EstimatedCost: cost,
+			CreatedAt:     time.Now().UnixMilli(),
+		},
+		AccountID: accountId,
+		Request:   req,
+	}
+	if err := s.save(ctx, record); err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.q.Push(ctx, record.JobID); err != nil {
+		s.Redis.Del(ctx, QueryJobRedisPrefix+record.JobID)
+		if errors.Is(err, jobqueue.ErrFull) {
+			return nil, nil, ErrQueryJobQueueFull
+		}
+		return nil, nil, err
+	}
+
+	log.Info().
+		Str("evt.name", "query.job.enqueued").
+		Str("jobId", record.JobID).
+		Int("cost", cost).
+		Msg("query job enqueued")
+
+	return nil, &record.QueryJob, nil
+}
+
+// GetJob returns the query job with the given id. Personal query jobs are only visible to their owners.
+func (s *QueryJob) GetJob(ctx context.Context, jobId string, accountId null.Int) (*modelv3.QueryJob, error) {
+	record, err := s.get(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	if record.AccountID.Valid && (!accountId.Valid || record.AccountID.Int64 != accountId.Int64) {
+		return nil, pgerr.ErrNotFound
+	}
+	return &record.QueryJob, nil
+}
+
+func (s *QueryJob) tooExpensiveError(plan *queryPlan, cost int) error {
+	return pgerr.New(http.StatusUnprocessableEntity, "QUERY_TOO_EXPENSIVE",
+		"the query is too expensive to run: narrow down the time window, request fewer stages per page with `limit`, or request fewer result kinds with `include`").
+		WithExtras(pgerr.Extras{
+			"estimatedCost": cost,
+			"maxCost":       s.maxCost,
+			"stages":        len(plan.stageIds),
+			"days":          int(plan.endTime.Sub(plan.startTime).Hours() / 24),
+		})
+}
+
+// run runs the query job with the given id. Jobs interrupted by a shutdown are saved as queued again, as they
+// are put back to the queue.
+func (s *QueryJob) run(parent context.Context, jobId string) {
+	ctx, cancel := context.WithTimeout(parent, s.timeout)
+	defer cancel()
+
+	record, err := s.get(ctx, jobId)
+	if err != nil {
+		if !errors.Is(err, pgerr.ErrNotFound) {
+			log.Error().
+				Str("evt.name", "query.job.failed").
+				Str("jobId", jobId).
+				Err(err).
+				Msg("failed to get query job")
+		}
+		return
+	}
+	if record.Status != modelv3.QueryJobStatusQueued && record.Status != modelv3.QueryJobStatusRunning {
+		return
+	}
+
+	record.Status = modelv3.QueryJobStatusRunning
+	record.StartedAt = null.IntFrom(time.Now().UnixMilli())
+	if err := s.save(ctx, record); err != nil {
+		log.Error().
+			Str("evt.name", "query.job.failed").
+			Str("jobId", record.JobID).
+			Err(err).
+			Msg("failed to update query job status")
+		return
+	}
+
+	var result *modelv3.ArbitraryQueryResult
+	plan, err := s.QueryService.plan(ctx, record.Request, record.AccountID)
+	if err == nil {
+		result, err = s.QueryService.execute(ctx, plan)
+	}
+	record.FinishedAt = null.IntFrom(time.Now().UnixMilli())
+	if err != nil && parent.Err() != nil {
+		record.Status = modelv3.QueryJobStatusQueued
+		record.StartedAt = null.Int{}
+		record.FinishedAt = null.Int{}
+	} else if err != nil {
+		log.Error().
+			Str("evt.name", "query.job.failed").
+			Str("jobId", record.JobID).
+			Err(err).
+			Msg("query job failed")
+
+		record.Status = modelv3.QueryJobStatusFailed
+		if errors.Is(err, context.DeadlineExceeded) {
+			record.Error = null.StringFrom("query job timed out after " + s.timeout.String())
+		} else if pgErr, ok := err.(*pgerr.PenguinError); ok {
+			record.Error = null.StringFrom(pgErr.Message)
+		} else {
+			record.Error = null.StringFrom("an unexpected error occurred while running the query")
+		}
+	} else {
+		record.Status = modelv3.QueryJobStatusSucceeded
+		record.Result = result
+	}
+
+	// the job context might have been exceeded already, so the final status is saved with a fresh one
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer saveCancel()
+	if err := s.save(saveCtx, record); err != nil {
+		log.Error().
+			Str("evt.name", "query.job.failed").
+			Str("jobId", record.JobID).
+			Err(err).
+			Msg("failed to save query job result")
+	}
+}
+
+func (s *QueryJob) get(ctx context.Context, jobId string) (*queryJobRecord, error) {
+	b, err := s.Redis.Get(ctx, QueryJobRedisPrefix+jobId).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, pgerr.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var record queryJobRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *QueryJob) save(ctx context.Context, record *queryJobRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.Redis.Set(ctx, QueryJobRedisPrefix+record.JobID, b, s.resultTTL).Err()
+}
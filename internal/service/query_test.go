@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"exusiai.dev/backend-next/internal/model/types"
+)
+
+func TestQueryPlanInterval(t *testing.T) {
+	s := &Query{}
+	startTime := time.Now().Add(-time.Hour * 24 * 7).UnixMilli()
+
+	for _, interval := range []int64{math.MaxInt64, math.MaxInt64/int64(time.Millisecond) + 1} {
+		_, err := s.plan(context.Background(), &types.ArbitraryQueryRequest{
+			Server:    "CN",
+			StartTime: startTime,
+			Include:   []string{types.QueryResultTrend},
+			Interval:  null.IntFrom(interval),
+		}, null.Int{})
+		assert.ErrorIs(t, err, ErrQueryIntervalTooLarge)
+	}
+
+	for _, interval := range []int64{-1, int64(time.Minute / time.Millisecond)} {
+		_, err := s.plan(context.Background(), &types.ArbitraryQueryRequest{
+			Server:    "CN",
+			StartTime: startTime,
+			Include:   []string{types.QueryResultTrend},
+			Interval:  null.IntFrom(interval),
+		}, null.Int{})
+		assert.ErrorIs(t, err, ErrQueryIntervalTooSmall)
+	}
+}
@@ -64,8 +64,12 @@ func NewReport(db *bun.DB, redisClient *redis.Client, natsJs nats.JetStreamConte
 }
 
 func (s *Report) PipelineAccount(ctx *fiber.Ctx) (accountId int, err error) {
-	account, err := s.AccountService.GetAccountFromRequest(ctx)
+	account, err := s.AccountService.GetAccountFromRequest(ctx, SessionScopeReport)
 	if err != nil {
+		// requests presenting session tokens are never given new accounts, as their reports belong to the accounts of the tokens
+		if pgid.ExtractToken(ctx) != "" {
+			return 0, err
+		}
 		createdAccount, err := s.AccountService.CreateAccountWithRandomPenguinId(ctx.UserContext())
 		if err != nil {
 			return 0, err
@@ -73,6 +77,9 @@ func (s *Report) PipelineAccount(ctx *fiber.Ctx) (accountId int, err error) {
 		accountId = createdAccount.AccountID
 		pgid.Inject(ctx, createdAccount.PenguinID)
 	} else {
+		if err := s.AccountService.CheckReportable(account); err != nil {
+			return 0, err
+		}
 		accountId = account.AccountID
 	}
 
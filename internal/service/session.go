@@ -0,0 +1,363 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/goccy/go-json"
+	"github.com/oklog/ulid/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model"
+	modelv3 "exusiai.dev/backend-next/internal/model/v3"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+)
+
+const (
+	SessionRedisPrefix        = "session:"
+	SessionAccountRedisPrefix = "session-account:"
+
+	// SessionTokenPrefix tells session tokens, and the version of their format, apart from PenguinIDs.
+	SessionTokenPrefix = "pgt1."
+
+	// SessionScopeReport allows submitting reports.
+	SessionScopeReport = "report"
+	// SessionScopeReadPersonal allows reading personal matrices and queries.
+	SessionScopeReadPersonal = "read-personal"
+	// SessionScopeAccount allows managing the account, e.g. merging, exporting and deleting it.
+	SessionScopeAccount = "account"
+)
+
+// SessionScopes are all the scopes a session may be granted. A PenguinID grants all of them.
+var SessionScopes = []string{SessionScopeReport, SessionScopeReadPersonal, SessionScopeAccount}
+
+var (
+	ErrSessionTokensUnavailable = pgerr.New(http.StatusServiceUnavailable, "SESSION_TOKENS_UNAVAILABLE", "session tokens are not configured")
+	ErrSessionTokenInvalid      = pgerr.New(http.StatusUnauthorized, "INVALID_SESSION_TOKEN", "session token is invalid or has been revoked")
+	ErrSessionTokenExpired      = pgerr.New(http.StatusUnauthorized, "SESSION_TOKEN_EXPIRED", "session token has expired. please refresh it with the refresh token")
+	ErrSessionScopeInsufficient = pgerr.New(http.StatusForbidden, "INSUFFICIENT_SCOPE", "session token does not grant the scope required")
+)
+
+// sessionClaims are the claims signed in a session token.
+type sessionClaims struct {
+	SessionID string   `json:"sid"`
+	AccountID int      `json:"sub"`
+	Scopes    []string `json:"scp"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// sessionRecord is the representation of a session in Redis. Only the hash of the current refresh token is kept.
+type sessionRecord struct {
+	AccountID   int      `json:"accountId"`
+	Scopes      []string `json:"scopes"`
+	RefreshHash string   `json:"refreshHash"`
+	CreatedAt   int64    `json:"createdAt"`
+}
+
+// Session issues short-lived session tokens in exchange for PenguinIDs, so that the PenguinID itself does not
+// have to be sent on every request nor stored by third-party tools.
+//
+// A session token is `pgt1.<claims>.<signature>`, with the base64url-encoded JSON claims signed with
+// HMAC-SHA256. Sessions are kept in Redis until their refresh tokens expire, and a session token is only
+// accepted while its session exists, so that revoking the session revokes its tokens immediately. Refresh
+// tokens are `<session id>.<secret>` and are rotated by every refresh; presenting a refresh token that has
+// already been rotated revokes the session, as it is likely to have been leaked.
+type Session struct {
+	Redis *redis.Client
+
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewSession(redisClient *redis.Client, conf *appconfig.Config) (*Session, error) {
+	s := &Session{
+		Redis:      redisClient,
+		accessTTL:  conf.SessionAccessTokenTTL,
+		refreshTTL: conf.SessionRefreshTokenTTL,
+	}
+	if conf.SessionTokenSecret == "" {
+		return s, nil
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(conf.SessionTokenSecret)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode session token secret")
+	}
+	if len(secret) < 32 {
+		return nil, errors.Errorf("invalid session token secret: expected at least 32 bytes, got %d", len(secret))
+	}
+	s.secret = secret
+	return s, nil
+}
+
+// Issue creates a session of account granted scopes, or all of them if none is given, and returns its tokens.
+func (s *Session) Issue(ctx context.Context, account *model.Account, scopes []string) (*modelv3.SessionTokens, error) {
+	if s.secret == nil {
+		return nil, ErrSessionTokensUnavailable
+	}
+	if len(scopes) == 0 {
+		scopes = SessionScopes
+	}
+	scopes = lo.Uniq(scopes)
+	for _, scope := range scopes {
+		if !lo.Contains(SessionScopes, scope) {
+			return nil, pgerr.ErrInvalidReq.Msg("unknown scope: %s", scope)
+		}
+	}
+
+	sessionId := strings.ToLower(ulid.Make().String())
+	record := &sessionRecord{
+		AccountID: account.AccountID,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	tokens, err := s.rotate(ctx, sessionId, record)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("evt.name", "session.issued").
+		Str("sessionId", sessionId).
+		Int("accountId", account.AccountID).
+		Strs("scopes", scopes).
+		Msg("session issued")
+
+	return tokens, nil
+}
+
+// Refresh exchanges a refresh token for new tokens of its session.
+func (s *Session) Refresh(ctx context.Context, refreshToken string) (*modelv3.SessionTokens, error) {
+	if s.secret == nil {
+		return nil, ErrSessionTokensUnavailable
+	}
+	sessionId, record, err := s.redeem(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return s.rotate(ctx, sessionId, record)
+}
+
+// Revoke revokes the session of a refresh token, along with its session tokens.
+func (s *Session) Revoke(ctx context.Context, refreshToken string) error {
+	sessionId, record, err := s.redeem(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+	return s.revoke(ctx, sessionId, record.AccountID)
+}
+
+// RevokeAccount revokes every session of the account, and returns the number of sessions revoked.
+func (s *Session) RevokeAccount(ctx context.Context, accountId int) (int, error) {
+	accountKey := SessionAccountRedisPrefix + strconv.Itoa(accountId)
+	sessionIds, err := s.Redis.SMembers(ctx, accountKey).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "service: session: failed to list sessions")
+	}
+
+	keys := make([]string, 0, len(sessionIds)+1)
+	for _, sessionId := range sessionIds {
+		keys = append(keys, SessionRedisPrefix+sessionId)
+	}
+	keys = append(keys, accountKey)
+	if err := s.Redis.Del(ctx, keys...).Err(); err != nil {
+		return 0, errors.Wrap(err, "service: session: failed to revoke sessions")
+	}
+
+	log.Info().
+		Str("evt.name", "session.revoked").
+		Int("accountId", accountId).
+		Int("sessions", len(sessionIds)).
+		Msg("sessions of account revoked")
+
+	return len(sessionIds), nil
+}
+
+// Verify verifies a session token granting scope, and returns the id of its account.
+func (s *Session) Verify(ctx context.Context, token string, scope string) (int, error) {
+	claims, err := s.verify(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+	if !lo.Contains(claims.Scopes, scope) {
+		return 0, ErrSessionScopeInsufficient
+	}
+	return claims.AccountID, nil
+}
+
+// Account verifies a session token regardless of its scopes, and returns the id of its account.
+func (s *Session) Account(ctx context.Context, token string) (int, error) {
+	claims, err := s.verify(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+	return claims.AccountID, nil
+}
+
+func (s *Session) verify(ctx context.Context, token string) (*sessionClaims, error) {
+	if s.secret == nil {
+		return nil, ErrSessionTokensUnavailable
+	}
+
+	claims, err := s.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrSessionTokenExpired
+	}
+
+	exists, err := s.Redis.Exists(ctx, SessionRedisPrefix+claims.SessionID).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "service: session: failed to get session")
+	}
+	if exists == 0 {
+		return nil, ErrSessionTokenInvalid
+	}
+	return claims, nil
+}
+
+// rotate saves the session with a new refresh token, and returns the new tokens of the session. The session is
+// indexed by its account along with it, and the index is kept for as long as the session, so that revoking the
+// sessions of the account always finds it.
+func (s *Session) rotate(ctx context.Context, sessionId string, record *sessionRecord) (*modelv3.SessionTokens, error) {
+	refreshSecret := make([]byte, 32)
+	if _, err := rand.Read(refreshSecret); err != nil {
+		return nil, err
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(refreshSecret)
+	record.RefreshHash = hashRefreshSecret(encodedSecret)
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	accountKey := SessionAccountRedisPrefix + strconv.Itoa(record.AccountID)
+	if _, err := s.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, SessionRedisPrefix+sessionId, b, s.refreshTTL)
+		pipe.SAdd(ctx, accountKey, sessionId)
+		pipe.Expire(ctx, accountKey, s.refreshTTL)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "service: session: failed to save session")
+	}
+
+	now := time.Now()
+	accessToken, err := s.sign(&sessionClaims{
+		SessionID: sessionId,
+		AccountID: record.AccountID,
+		Scopes:    record.Scopes,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.accessTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &modelv3.SessionTokens{
+		AccessToken:  accessToken,
+		RefreshToken: sessionId + "." + encodedSecret,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.accessTTL.Seconds()),
+		Scopes:       record.Scopes,
+	}, nil
+}
+
+// redeem returns the session of a refresh token. A refresh token that does not match the current one of its
+// session revokes the session.
+func (s *Session) redeem(ctx context.Context, refreshToken string) (string, *sessionRecord, error) {
+	sessionId, encodedSecret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionId == "" || encodedSecret == "" {
+		return "", nil, ErrSessionTokenInvalid
+	}
+
+	b, err := s.Redis.Get(ctx, SessionRedisPrefix+sessionId).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return "", nil, ErrSessionTokenInvalid
+	} else if err != nil {
+		return "", nil, errors.Wrap(err, "service: session: failed to get session")
+	}
+	var record sessionRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return "", nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashRefreshSecret(encodedSecret)), []byte(record.RefreshHash)) != 1 {
+		log.Warn().
+			Str("evt.name", "session.refresh.reused").
+			Str("sessionId", sessionId).
+			Int("accountId", record.AccountID).
+			Msg("outdated refresh token presented: revoking session")
+		if err := s.revoke(ctx, sessionId, record.AccountID); err != nil {
+			return "", nil, err
+		}
+		return "", nil, ErrSessionTokenInvalid
+	}
+	return sessionId, &record, nil
+}
+
+func (s *Session) revoke(ctx context.Context, sessionId string, accountId int) error {
+	pipe := s.Redis.Pipeline()
+	pipe.Del(ctx, SessionRedisPrefix+sessionId)
+	pipe.SRem(ctx, SessionAccountRedisPrefix+strconv.Itoa(accountId), sessionId)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrap(err, "service: session: failed to revoke session")
+	}
+	return nil
+}
+
+func (s *Session) sign(claims *sessionClaims) (string, error) {
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return SessionTokenPrefix + payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload)), nil
+}
+
+func (s *Session) parse(token string) (*sessionClaims, error) {
+	payload, signature, ok := strings.Cut(strings.TrimPrefix(token, SessionTokenPrefix), ".")
+	if !ok || !strings.HasPrefix(token, SessionTokenPrefix) {
+		return nil, ErrSessionTokenInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.mac(payload)) {
+		return nil, ErrSessionTokenInvalid
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrSessionTokenInvalid
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, ErrSessionTokenInvalid
+	}
+	return &claims, nil
+}
+
+func (s *Session) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+func hashRefreshSecret(encodedSecret string) string {
+	sum := sha256.Sum256([]byte(encodedSecret))
+	return hex.EncodeToString(sum[:])
+}
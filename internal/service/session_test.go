@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"exusiai.dev/backend-next/internal/model"
+)
+
+func setupSession(t *testing.T) (*Session, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	return &Session{
+		Redis:      redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		secret:     []byte(strings.Repeat("s", 32)),
+		accessTTL:  time.Minute,
+		refreshTTL: time.Hour,
+	}, mr
+}
+
+func TestSessionVerify(t *testing.T) {
+	s, _ := setupSession(t)
+	ctx := context.Background()
+	account := &model.Account{AccountID: 1}
+
+	tokens, err := s.Issue(ctx, account, []string{SessionScopeReport})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(tokens.AccessToken, SessionTokenPrefix))
+
+	accountId, err := s.Verify(ctx, tokens.AccessToken, SessionScopeReport)
+	require.NoError(t, err)
+	assert.Equal(t, account.AccountID, accountId)
+
+	_, err = s.Verify(ctx, tokens.AccessToken, SessionScopeAccount)
+	assert.ErrorIs(t, err, ErrSessionScopeInsufficient)
+
+	claims, err := s.parse(tokens.AccessToken)
+	require.NoError(t, err)
+
+	t.Run("tampered claims", func(t *testing.T) {
+		claims := *claims
+		claims.AccountID = 2
+		forged, err := (&Session{secret: []byte(strings.Repeat("x", 32))}).sign(&claims)
+		require.NoError(t, err)
+		_, err = s.Verify(ctx, forged, SessionScopeReport)
+		assert.ErrorIs(t, err, ErrSessionTokenInvalid)
+
+		_, err = s.Verify(ctx, strings.TrimPrefix(tokens.AccessToken, SessionTokenPrefix), SessionScopeReport)
+		assert.ErrorIs(t, err, ErrSessionTokenInvalid)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		claims := *claims
+		claims.ExpiresAt = time.Now().Add(-time.Second).Unix()
+		expired, err := s.sign(&claims)
+		require.NoError(t, err)
+		_, err = s.Verify(ctx, expired, SessionScopeReport)
+		assert.ErrorIs(t, err, ErrSessionTokenExpired)
+	})
+
+	t.Run("unknown scope", func(t *testing.T) {
+		_, err := s.Issue(ctx, account, []string{"unknown"})
+		assert.Error(t, err)
+	})
+
+	t.Run("unconfigured", func(t *testing.T) {
+		_, err := (&Session{Redis: s.Redis}).Verify(ctx, tokens.AccessToken, SessionScopeReport)
+		assert.ErrorIs(t, err, ErrSessionTokensUnavailable)
+	})
+}
+
+func TestSessionRefresh(t *testing.T) {
+	s, mr := setupSession(t)
+	ctx := context.Background()
+	account := &model.Account{AccountID: 1}
+	accountKey := SessionAccountRedisPrefix + strconv.Itoa(account.AccountID)
+
+	tokens, err := s.Issue(ctx, account, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, SessionScopes, tokens.Scopes)
+
+	// the account index outlives the session after every rotation
+	mr.FastForward(time.Minute * 30)
+	refreshed, err := s.Refresh(ctx, tokens.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, mr.TTL(accountKey))
+
+	_, err = s.Verify(ctx, refreshed.AccessToken, SessionScopeAccount)
+	require.NoError(t, err)
+
+	// presenting a rotated refresh token revokes the session
+	_, err = s.Refresh(ctx, tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrSessionTokenInvalid)
+	_, err = s.Verify(ctx, refreshed.AccessToken, SessionScopeAccount)
+	assert.ErrorIs(t, err, ErrSessionTokenInvalid)
+	_, err = s.Refresh(ctx, refreshed.RefreshToken)
+	assert.ErrorIs(t, err, ErrSessionTokenInvalid)
+}
+
+func TestSessionRevoke(t *testing.T) {
+	s, mr := setupSession(t)
+	ctx := context.Background()
+	account := &model.Account{AccountID: 1}
+
+	first, err := s.Issue(ctx, account, nil)
+	require.NoError(t, err)
+	second, err := s.Issue(ctx, account, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Revoke(ctx, first.RefreshToken))
+	_, err = s.Verify(ctx, first.AccessToken, SessionScopeReport)
+	assert.ErrorIs(t, err, ErrSessionTokenInvalid)
+	_, err = s.Verify(ctx, second.AccessToken, SessionScopeReport)
+	require.NoError(t, err)
+
+	revoked, err := s.RevokeAccount(ctx, account.AccountID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	_, err = s.Verify(ctx, second.AccessToken, SessionScopeReport)
+	assert.ErrorIs(t, err, ErrSessionTokenInvalid)
+	assert.False(t, mr.Exists(SessionAccountRedisPrefix+strconv.Itoa(account.AccountID)))
+}
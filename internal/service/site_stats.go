@@ -19,45 +19,25 @@ func NewSiteStats(dropReportRepo *repo.DropReport) *SiteStats {
 	}
 }
 
-// Cache: shimSiteStats#server:{server}, 24hrs
+// Cache: shimSiteStats#server:{server}, 24hrs, records last modified time
 func (s *SiteStats) GetShimSiteStats(ctx context.Context, server string) (*modelv2.SiteStats, error) {
-	var results modelv2.SiteStats
-	err := cache.ShimSiteStats.Get(server, &results)
-	if err == nil {
-		return &results, nil
+	valueFunc := func() (*modelv2.SiteStats, error) {
+		return s.calcShimSiteStats(ctx, server)
 	}
 
-	return s.RefreshShimSiteStats(ctx, server)
+	var results modelv2.SiteStats
+	calculated, err := cache.ShimSiteStats.MutexGetSet(server, &results, valueFunc, 24*time.Hour)
+	if err != nil {
+		return nil, err
+	} else if calculated {
+		cache.LastModifiedTime.Set("[shimSiteStats#server:"+server+"]", time.Now(), 0)
+	}
+	return &results, nil
 }
 
 func (s *SiteStats) RefreshShimSiteStats(ctx context.Context, server string) (*modelv2.SiteStats, error) {
 	valueFunc := func() (*modelv2.SiteStats, error) {
-		stageTimes, err := s.DropReportRepo.CalcTotalStageQuantityForShimSiteStats(ctx, server, false)
-		if err != nil {
-			return nil, err
-		}
-
-		stageTimes24h, err := s.DropReportRepo.CalcTotalStageQuantityForShimSiteStats(ctx, server, true)
-		if err != nil {
-			return nil, err
-		}
-
-		itemQuantity, err := s.DropReportRepo.CalcTotalItemQuantityForShimSiteStats(ctx, server)
-		if err != nil {
-			return nil, err
-		}
-
-		sanity, err := s.DropReportRepo.CalcTotalSanityCostForShimSiteStats(ctx, server)
-		if err != nil {
-			return nil, err
-		}
-
-		return &modelv2.SiteStats{
-			TotalStageTimes:     stageTimes,
-			TotalStageTimes24H:  stageTimes24h,
-			TotalItemQuantities: itemQuantity,
-			TotalSanityCost:     sanity,
-		}, nil
+		return s.calcShimSiteStats(ctx, server)
 	}
 
 	var results modelv2.SiteStats
@@ -69,3 +49,32 @@ func (s *SiteStats) RefreshShimSiteStats(ctx context.Context, server string) (*m
 	cache.LastModifiedTime.Set("[shimSiteStats#server:"+server+"]", time.Now(), 0)
 	return &results, nil
 }
+
+func (s *SiteStats) calcShimSiteStats(ctx context.Context, server string) (*modelv2.SiteStats, error) {
+	stageTimes, err := s.DropReportRepo.CalcTotalStageQuantityForShimSiteStats(ctx, server, false)
+	if err != nil {
+		return nil, err
+	}
+
+	stageTimes24h, err := s.DropReportRepo.CalcTotalStageQuantityForShimSiteStats(ctx, server, true)
+	if err != nil {
+		return nil, err
+	}
+
+	itemQuantity, err := s.DropReportRepo.CalcTotalItemQuantityForShimSiteStats(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+
+	sanity, err := s.DropReportRepo.CalcTotalSanityCostForShimSiteStats(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+
+	return &modelv2.SiteStats{
+		TotalStageTimes:     stageTimes,
+		TotalStageTimes24H:  stageTimes24h,
+		TotalItemQuantities: itemQuantity,
+		TotalSanityCost:     sanity,
+	}, nil
+}
@@ -4,12 +4,23 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"sort"
+	"time"
 
+	"exusiai.dev/gommon/constant"
 	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/go-redis/redis/v8"
+	"github.com/goccy/go-json"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"golang.org/x/sync/singleflight"
 	"gopkg.in/guregu/null.v3"
 
+	"exusiai.dev/backend-next/internal/app/appconfig"
 	"exusiai.dev/backend-next/internal/model"
+	modelv2 "exusiai.dev/backend-next/internal/model/v2"
+	"exusiai.dev/backend-next/internal/pkg/jsondiff"
 	"exusiai.dev/backend-next/internal/pkg/pgerr"
 	"exusiai.dev/backend-next/internal/repo"
 )
@@ -21,13 +32,50 @@ var (
 	ErrSnapshotToVersionNotFound   = pgerr.ErrInvalidReq.Msg("snapshot matching `to` version not found")
 )
 
+// Realms of the snapshots created automatically by the worker. The snapshot key of a realm is {server}|{realm}.
+const (
+	SnapshotRealmMatrix  = "matrix"
+	SnapshotRealmPattern = "pattern"
+	SnapshotRealmTrend   = "trend"
+)
+
+// Formats of the diffs between snapshots, named after their media types.
+const (
+	SnapshotDiffFormatBsdiff     = "application/x-bsdiff"
+	SnapshotDiffFormatJSONPatch  = "application/json-patch+json"
+	SnapshotDiffFormatMergePatch = "application/merge-patch+json"
+)
+
+// SnapshotDiffRedisPrefix is the prefix of precomputed diffs, keyed by {key}:{from}...{to}:{format}.
+const SnapshotDiffRedisPrefix = "snapshot-diff:"
+
 type Snapshot struct {
-	SnapshotRepo *repo.Snapshot
+	SnapshotRepo         *repo.Snapshot
+	DropMatrixService    *DropMatrix
+	PatternMatrixService *PatternMatrix
+	TrendService         *Trend
+	Redis                *redis.Client
+
+	retentionCount   int
+	retentionPeriod  time.Duration
+	precomputedDiffs int
+	diffTTL          time.Duration
+
+	// diffs deduplicates the concurrent on-demand computations of the same diff
+	diffs singleflight.Group
 }
 
-func NewSnapshot(snapshotRepo *repo.Snapshot) *Snapshot {
+func NewSnapshot(snapshotRepo *repo.Snapshot, dropMatrixService *DropMatrix, patternMatrixService *PatternMatrix, trendService *Trend, redisClient *redis.Client, conf *appconfig.Config) *Snapshot {
 	return &Snapshot{
-		SnapshotRepo: snapshotRepo,
+		SnapshotRepo:         snapshotRepo,
+		DropMatrixService:    dropMatrixService,
+		PatternMatrixService: patternMatrixService,
+		TrendService:         trendService,
+		Redis:                redisClient,
+		retentionCount:       conf.SnapshotRetentionCount,
+		retentionPeriod:      conf.SnapshotRetentionPeriod,
+		precomputedDiffs:     conf.SnapshotPrecomputedDiffs,
+		diffTTL:              conf.SnapshotDiffTTL,
 	}
 }
 
@@ -36,39 +84,352 @@ func (s *Snapshot) SaveSnapshot(ctx context.Context, key string, content string)
 		return nil, ErrSnapshotNonNullable
 	}
 	version := s.CalculateVersion(content)
+	now := time.Now()
 	entity := &model.Snapshot{
-		Key:     key,
-		Version: version,
-		Content: content,
+		CreatedAt: &now,
+		Key:       key,
+		Version:   version,
+		Content:   content,
+	}
+	snapshot, err := s.SnapshotRepo.SaveSnapshot(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.precomputeDiffs(ctx, key); err != nil {
+		// clients fall back to chained diffs or a full download
+		log.Error().
+			Err(err).
+			Str("evt.name", "snapshot.diff.precompute_failed").
+			Str("key", key).
+			Str("version", version).
+			Msg("failed to precompute diffs to the saved snapshot")
 	}
-	return s.SnapshotRepo.SaveSnapshot(ctx, entity)
+	return snapshot, nil
 }
 
-func (s *Snapshot) GetDiffBetweenVersions(ctx context.Context, key, fromVersion, toVersion string) ([]byte, error) {
-	snapshots, err := s.SnapshotRepo.GetSnapshotsByVersions(ctx, key, []string{fromVersion, toVersion})
+// SaveSnapshotIfChanged saves content as a snapshot of key, unless its version equals the version of the latest
+// snapshot of key. The second return value means whether a snapshot is saved.
+func (s *Snapshot) SaveSnapshotIfChanged(ctx context.Context, key string, content string) (*model.Snapshot, bool, error) {
+	latest, err := s.SnapshotRepo.GetLatestSnapshotByKey(ctx, key)
+	if err != nil && !errors.Is(err, pgerr.ErrNotFound) {
+		return nil, false, err
+	}
+	if latest != nil && latest.Version == s.CalculateVersion(content) {
+		return latest, false, nil
+	}
+	snapshot, err := s.SaveSnapshot(ctx, key, content)
+	if err != nil {
+		return nil, false, err
+	}
+	return snapshot, true, nil
+}
+
+func (s *Snapshot) GetLatestSnapshotByKey(ctx context.Context, key string) (*model.Snapshot, error) {
+	return s.SnapshotRepo.GetLatestSnapshotByKey(ctx, key)
+}
+
+// GetSnapshotByVersion returns the snapshot of key at version, or pgerr.ErrNotFound if there is none.
+func (s *Snapshot) GetSnapshotByVersion(ctx context.Context, key, version string) (*model.Snapshot, error) {
+	snapshots, err := s.SnapshotRepo.GetSnapshotsByVersions(ctx, key, []string{version})
 	if err != nil {
 		return nil, err
 	}
+	if len(snapshots) == 0 {
+		return nil, pgerr.ErrNotFound
+	}
+	return snapshots[0], nil
+}
+
+// SnapshotRealms renders the payloads of realms for server, saves those changed since their latest snapshots,
+// and deletes the snapshots beyond the retention policy.
+func (s *Snapshot) SnapshotRealms(ctx context.Context, server string, realms []string) error {
+	for _, realm := range realms {
+		key := server + constant.CacheSep + realm
+		content, err := s.renderRealm(ctx, server, realm)
+		if err != nil {
+			return errors.Wrapf(err, "failed to render realm %s", key)
+		}
+
+		snapshot, saved, err := s.SaveSnapshotIfChanged(ctx, key, content)
+		if err != nil {
+			return errors.Wrapf(err, "failed to save snapshot of realm %s", key)
+		}
+		if !saved {
+			log.Debug().
+				Str("evt.name", "snapshot.realm.unchanged").
+				Str("key", key).
+				Str("version", snapshot.Version).
+				Msg("realm has not changed since its latest snapshot, skipping")
+			continue
+		}
+
+		deleted, err := s.CollectGarbage(ctx, key)
+		if err != nil {
+			return errors.Wrapf(err, "failed to collect garbage of realm %s", key)
+		}
+		log.Info().
+			Str("evt.name", "snapshot.realm.saved").
+			Str("key", key).
+			Str("version", snapshot.Version).
+			Int64("deleted", deleted).
+			Msg("saved snapshot of realm")
+	}
+	return nil
+}
+
+// CollectGarbage deletes the snapshots of key which are neither among the latest SnapshotRetentionCount
+// snapshots nor created within SnapshotRetentionPeriod. The latest snapshot is always kept.
+func (s *Snapshot) CollectGarbage(ctx context.Context, key string) (int64, error) {
+	if s.retentionCount <= 0 && s.retentionPeriod <= 0 {
+		return 0, nil
+	}
+	return s.SnapshotRepo.DeleteSnapshotsBeyondRetention(ctx, key, lo.Max([]int{s.retentionCount, 1}), time.Now().Add(-s.retentionPeriod))
+}
 
-	var fromContent, toContent null.String
-	for _, snapshot := range snapshots {
-		if snapshot.Version == fromVersion {
-			fromContent = null.NewString(snapshot.Content, true)
-		} else if snapshot.Version == toVersion {
-			toContent = null.NewString(snapshot.Content, true)
+// renderRealm renders the global payload of realm for server as served by the v2 result endpoints. Elements are
+// sorted so that the version only changes when the content does.
+func (s *Snapshot) renderRealm(ctx context.Context, server, realm string) (string, error) {
+	var payload any
+	switch realm {
+	case SnapshotRealmMatrix:
+		result, err := s.DropMatrixService.GetShimMaxAccumulableDropMatrixResults(ctx, server, false, "", "", null.NewInt(0, false), constant.SourceCategoryAll)
+		if err != nil {
+			return "", err
+		}
+		matrix := make([]*modelv2.OneDropMatrixElement, len(result.Matrix))
+		copy(matrix, result.Matrix)
+		sort.SliceStable(matrix, func(i, j int) bool {
+			a, b := matrix[i], matrix[j]
+			if a.StageID != b.StageID {
+				return a.StageID < b.StageID
+			}
+			if a.ItemID != b.ItemID {
+				return a.ItemID < b.ItemID
+			}
+			return a.StartTime < b.StartTime
+		})
+		payload = &modelv2.DropMatrixQueryResult{Matrix: matrix}
+	case SnapshotRealmPattern:
+		result, err := s.PatternMatrixService.GetShimLatestPatternMatrixResults(ctx, server, null.NewInt(0, false), constant.SourceCategoryAll)
+		if err != nil {
+			return "", err
+		}
+		patternMatrix := make([]*modelv2.OnePatternMatrixElement, len(result.PatternMatrix))
+		copy(patternMatrix, result.PatternMatrix)
+		sort.SliceStable(patternMatrix, func(i, j int) bool {
+			a, b := patternMatrix[i], patternMatrix[j]
+			if a.StageID != b.StageID {
+				return a.StageID < b.StageID
+			}
+			if a.StartTime != b.StartTime {
+				return a.StartTime < b.StartTime
+			}
+			return a.Pattern.PatternID < b.Pattern.PatternID
+		})
+		payload = &modelv2.PatternMatrixQueryResult{PatternMatrix: patternMatrix}
+	case SnapshotRealmTrend:
+		result, err := s.TrendService.GetShimSavedTrendResults(ctx, server)
+		if err != nil {
+			return "", err
 		}
+		// maps are marshaled with sorted keys
+		payload = result
+	default:
+		return "", errors.Errorf("unknown realm %q", realm)
 	}
 
-	if !fromContent.Valid {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// SnapshotDiffResult is the diff between two versions, or the plan to reach the to version by the diffs
+// precomputed in-between.
+type SnapshotDiffResult struct {
+	// Diff is the diff from the from version to the to version, if any.
+	Diff []byte
+	// Chain is the versions to apply precomputed diffs in-between one after another, from the from version to
+	// the to version, when Diff is nil.
+	Chain []string
+}
+
+// GetDiffBetweenVersions returns the diff in format transforming the snapshot of key at fromVersion into the
+// snapshot at toVersion. When no diff between the versions is precomputed, the result holds a chain of
+// precomputed diffs to apply instead, as those are shared by every client; if the chain is broken, the diff is
+// computed on demand and cached as if it was precomputed.
+func (s *Snapshot) GetDiffBetweenVersions(ctx context.Context, key, fromVersion, toVersion, format string) (*SnapshotDiffResult, error) {
+	snapshots, err := s.SnapshotRepo.GetSnapshotVersionsByKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	versions := lo.Map(snapshots, func(snapshot *model.Snapshot, _ int) string {
+		return snapshot.Version
+	})
+	// the same content may have been snapshotted more than once; diffs start from its latest occurrence
+	fromIndex := lo.LastIndexOf(versions, fromVersion)
+	if fromIndex == -1 {
 		return nil, ErrSnapshotFromVersionNotFound
-	} else if !toContent.Valid {
+	}
+	toIndex := lo.LastIndexOf(versions, toVersion)
+	if toIndex == -1 {
 		return nil, ErrSnapshotToVersionNotFound
 	}
 
-	fromBytes := []byte(fromContent.String)
-	toBytes := []byte(toContent.String)
+	if fromVersion == toVersion {
+		return &SnapshotDiffResult{Diff: []byte{}}, nil
+	}
+
+	diff, err := s.getPrecomputedDiff(ctx, key, fromVersion, toVersion, format)
+	if err != nil {
+		return nil, err
+	}
+	if diff != nil {
+		return &SnapshotDiffResult{Diff: diff}, nil
+	}
+
+	if fromIndex < toIndex {
+		chain, err := s.planDiffChain(ctx, key, versions[fromIndex:toIndex+1], format)
+		if err != nil {
+			return nil, err
+		}
+		if chain != nil {
+			return &SnapshotDiffResult{Chain: chain}, nil
+		}
+	}
+
+	diff, err = s.computeDiffOnDemand(ctx, key, fromVersion, toVersion, format)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotDiffResult{Diff: diff}, nil
+}
+
+// planDiffChain returns the shortest chain of precomputed diffs from the first to the last of versions, greedily
+// taking the furthest precomputed diff at every step, or nil if the chain is broken.
+func (s *Snapshot) planDiffChain(ctx context.Context, key string, versions []string, format string) ([]string, error) {
+	chain := []string{versions[0]}
+	for current := 0; current < len(versions)-1; {
+		// diffs are only precomputed from the previous SnapshotPrecomputedDiffs versions
+		candidates := versions[current+1 : lo.Min([]int{current + s.precomputedDiffs, len(versions) - 1})+1]
+
+		pipe := s.Redis.Pipeline()
+		exists := make([]*redis.IntCmd, len(candidates))
+		for i, candidate := range candidates {
+			exists[i] = pipe.Exists(ctx, snapshotDiffRedisKey(key, versions[current], candidate, format))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+
+		next := -1
+		for i := len(candidates) - 1; i >= 0; i-- {
+			if exists[i].Val() > 0 {
+				next = current + 1 + i
+				break
+			}
+		}
+		if next == -1 {
+			return nil, nil
+		}
+		chain = append(chain, versions[next])
+		current = next
+	}
+	return chain, nil
+}
+
+func (s *Snapshot) getPrecomputedDiff(ctx context.Context, key, fromVersion, toVersion, format string) ([]byte, error) {
+	diff, err := s.Redis.Get(ctx, snapshotDiffRedisKey(key, fromVersion, toVersion, format)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// computeDiffOnDemand computes the diff in format from the snapshot of key at fromVersion to the one at toVersion
+// and caches it for SnapshotDiffTTL, as clients are likely to ask for the same diff again.
+func (s *Snapshot) computeDiffOnDemand(ctx context.Context, key, fromVersion, toVersion, format string) ([]byte, error) {
+	redisKey := snapshotDiffRedisKey(key, fromVersion, toVersion, format)
+	diff, err, _ := s.diffs.Do(redisKey, func() (any, error) {
+		from, err := s.GetSnapshotByVersion(ctx, key, fromVersion)
+		if err != nil {
+			return nil, err
+		}
+		to, err := s.GetSnapshotByVersion(ctx, key, toVersion)
+		if err != nil {
+			return nil, err
+		}
+		diff, err := computeDiff([]byte(from.Content), []byte(to.Content), format)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to compute %s diff from version %s to %s", format, fromVersion, toVersion)
+		}
+
+		if err := s.Redis.Set(ctx, redisKey, diff, s.diffTTL).Err(); err != nil {
+			// the diff is computed again next time
+			log.Warn().
+				Err(err).
+				Str("evt.name", "snapshot.diff.cache_failed").
+				Str("key", key).
+				Str("from", fromVersion).
+				Str("to", toVersion).
+				Msg("failed to cache diff computed on demand")
+		}
+		return diff, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diff.([]byte), nil
+}
+
+// precomputeDiffs computes the diffs in every format from the previous SnapshotPrecomputedDiffs versions of key
+// to its latest snapshot, and stores them in Redis.
+func (s *Snapshot) precomputeDiffs(ctx context.Context, key string) error {
+	if s.precomputedDiffs <= 0 {
+		return nil
+	}
+	snapshots, err := s.SnapshotRepo.GetLatestSnapshotsByKey(ctx, key, s.precomputedDiffs+1)
+	if err != nil {
+		return err
+	}
+	latest := snapshots[0]
+
+	pipe := s.Redis.Pipeline()
+	for _, previous := range snapshots[1:] {
+		if previous.Version == latest.Version {
+			continue
+		}
+		for _, format := range []string{SnapshotDiffFormatBsdiff, SnapshotDiffFormatJSONPatch, SnapshotDiffFormatMergePatch} {
+			diff, err := computeDiff([]byte(previous.Content), []byte(latest.Content), format)
+			if err != nil {
+				return errors.Wrapf(err, "failed to compute %s diff from version %s", format, previous.Version)
+			}
+			pipe.Set(ctx, snapshotDiffRedisKey(key, previous.Version, latest.Version, format), diff, s.diffTTL)
+		}
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func computeDiff(from, to []byte, format string) ([]byte, error) {
+	switch format {
+	case SnapshotDiffFormatBsdiff:
+		return bsdiff.Bytes(from, to)
+	case SnapshotDiffFormatJSONPatch:
+		return jsondiff.CreatePatch(from, to)
+	case SnapshotDiffFormatMergePatch:
+		return jsondiff.CreateMergePatch(from, to)
+	default:
+		return nil, errors.Errorf("unknown diff format %q", format)
+	}
+}
 
-	return bsdiff.Bytes(fromBytes, toBytes)
+func snapshotDiffRedisKey(key, fromVersion, toVersion, format string) string {
+	return SnapshotDiffRedisPrefix + key + ":" + fromVersion + "..." + toVersion + ":" + format
 }
 
 func (s *Snapshot) CalculateVersion(content string) string {
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model"
+	"exusiai.dev/backend-next/internal/pkg/pgerr"
+	"exusiai.dev/backend-next/internal/repo"
+)
+
+const SnapshotManifestAlgorithm = "Ed25519"
+
+var ErrSnapshotSigningUnavailable = pgerr.New(http.StatusServiceUnavailable, "SIGNING_UNAVAILABLE", "snapshot manifest signing is not configured")
+
+// SignedSnapshotManifest is a snapshot manifest along with its signature. Payload is the exact JSON encoding of
+// Manifest being signed, so that clients can verify the signature without re-encoding the manifest.
+type SignedSnapshotManifest struct {
+	Manifest  *model.SnapshotManifest `json:"manifest"`
+	Payload   string                  `json:"payload"`
+	Signature string                  `json:"signature"`
+	Algorithm string                  `json:"algorithm"`
+	KeyID     string                  `json:"keyId"`
+}
+
+// SnapshotPublicKey is the public key to verify snapshot manifests with.
+type SnapshotPublicKey struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"keyId"`
+	// PublicKey is the base64-encoded raw 32-byte public key.
+	PublicKey string `json:"publicKey"`
+	// PEM is the PEM-encoded PKIX public key.
+	PEM string `json:"pem"`
+}
+
+// SnapshotManifest signs manifests of the latest snapshots of realms with the configured Ed25519 key.
+type SnapshotManifest struct {
+	SnapshotRepo *repo.Snapshot
+
+	privateKey ed25519.PrivateKey
+	keyID      string
+}
+
+func NewSnapshotManifest(snapshotRepo *repo.Snapshot, conf *appconfig.Config) (*SnapshotManifest, error) {
+	s := &SnapshotManifest{
+		SnapshotRepo: snapshotRepo,
+	}
+	if conf.SnapshotSigningKey == "" {
+		return s, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(conf.SnapshotSigningKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode snapshot signing key")
+	}
+	switch len(key) {
+	case ed25519.SeedSize:
+		s.privateKey = ed25519.NewKeyFromSeed(key)
+	case ed25519.PrivateKeySize:
+		// a private key is its seed followed by its public key; a mismatching public key would have every
+		// signature fail verification
+		s.privateKey = ed25519.NewKeyFromSeed(key[:ed25519.SeedSize])
+		if !s.privateKey.Equal(ed25519.PrivateKey(key)) {
+			return nil, errors.New("invalid snapshot signing key: public key does not match its seed")
+		}
+	default:
+		return nil, errors.Errorf("invalid snapshot signing key: expected %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(key))
+	}
+
+	// the key ID is the first 8 bytes of the SHA-256 hash of the public key, to tell keys apart across rotations
+	publicKeyHash := sha256.Sum256(s.privateKey.Public().(ed25519.PublicKey))
+	s.keyID = hex.EncodeToString(publicKeyHash[:8])
+	return s, nil
+}
+
+// GetSignedManifest returns the signed manifest of the latest snapshot of key.
+func (s *SnapshotManifest) GetSignedManifest(ctx context.Context, key string) (*SignedSnapshotManifest, error) {
+	if s.privateKey == nil {
+		return nil, ErrSnapshotSigningUnavailable
+	}
+
+	snapshot, err := s.SnapshotRepo.GetLatestSnapshotByKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	contentHash := sha256.Sum256([]byte(snapshot.Content))
+	manifest := &model.SnapshotManifest{
+		Key:     snapshot.Key,
+		Version: snapshot.Version,
+		SHA256:  hex.EncodeToString(contentHash[:]),
+		Size:    len(snapshot.Content),
+	}
+	if snapshot.CreatedAt != nil {
+		manifest.CreatedAt = snapshot.CreatedAt.UTC().Truncate(time.Millisecond)
+	}
+
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedSnapshotManifest{
+		Manifest:  manifest,
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, payload)),
+		Algorithm: SnapshotManifestAlgorithm,
+		KeyID:     s.keyID,
+	}, nil
+}
+
+// GetPublicKey returns the public key to verify manifests with.
+func (s *SnapshotManifest) GetPublicKey() (*SnapshotPublicKey, error) {
+	if s.privateKey == nil {
+		return nil, ErrSnapshotSigningUnavailable
+	}
+
+	publicKey := s.privateKey.Public().(ed25519.PublicKey)
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotPublicKey{
+		Algorithm: SnapshotManifestAlgorithm,
+		KeyID:     s.keyID,
+		PublicKey: base64.StdEncoding.EncodeToString(publicKey),
+		PEM:       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}, nil
+}
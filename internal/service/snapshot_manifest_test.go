@@ -0,0 +1,43 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+)
+
+func TestNewSnapshotManifestSigningKey(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	otherPublicKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public().(ed25519.PublicKey)
+
+	tests := []struct {
+		name    string
+		key     []byte
+		wantErr bool
+	}{
+		{"seed", seed, false},
+		{"private key", privateKey, false},
+		{"private key with mismatching public key", append(append([]byte{}, seed...), otherPublicKey...), true},
+		{"invalid length", seed[:16], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSnapshotManifest(nil, &appconfig.Config{ConfigSpec: appconfig.ConfigSpec{SnapshotSigningKey: base64.StdEncoding.EncodeToString(tt.key)}})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, privateKey.Equal(s.privateKey))
+		})
+	}
+}
@@ -2,23 +2,35 @@ package service
 
 import (
 	"context"
+	"math"
 	"time"
 
 	"exusiai.dev/gommon/constant"
 	"github.com/ahmetb/go-linq/v3"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
 	"gopkg.in/guregu/null.v3"
 
+	"exusiai.dev/backend-next/internal/app/appconfig"
 	"exusiai.dev/backend-next/internal/model"
 	"exusiai.dev/backend-next/internal/model/cache"
 	modelv2 "exusiai.dev/backend-next/internal/model/v2"
+	modelv3 "exusiai.dev/backend-next/internal/model/v3"
 	"exusiai.dev/backend-next/internal/pkg/async"
 	"exusiai.dev/backend-next/internal/pkg/gameday"
 	"exusiai.dev/backend-next/internal/util"
 )
 
+const (
+	// TrendHourlyIntervalNum is the number of hourly intervals stored from the start of a stage, i.e. its first 3 days.
+	TrendHourlyIntervalNum = 72
+	// TrendWeeklyIntervalNum is the maximum number of weekly intervals stored for a stage.
+	TrendWeeklyIntervalNum = 52
+)
+
 type Trend struct {
+	TrendGranularities          appconfig.TrendGranularityMap
 	TimeRangeService            *TimeRange
 	DropReportService           *DropReport
 	DropInfoService             *DropInfo
@@ -30,6 +42,7 @@ type Trend struct {
 }
 
 func NewTrend(
+	conf *appconfig.Config,
 	timeRangeService *TimeRange,
 	dropReportService *DropReport,
 	dropInfoService *DropInfo,
@@ -40,6 +53,7 @@ func NewTrend(
 	itemService *Item,
 ) *Trend {
 	return &Trend{
+		TrendGranularities:          conf.TrendGranularities,
 		TimeRangeService:            timeRangeService,
 		DropReportService:           dropReportService,
 		DropInfoService:             dropInfoService,
@@ -95,12 +109,38 @@ func (s *Trend) QueryTrend(
 	return s.convertTrendElementsToTrendQueryResult(trendElements)
 }
 
+// Cache: shimTrendResults#server|granularity|sourceCategory:{server}|{granularity}|{sourceCategory}, 24hrs, records last modified time
+func (s *Trend) GetShimTrendResultsByGranularity(ctx context.Context, server string, granularity string, sourceCategory string) (*modelv3.TrendQueryResult, error) {
+	valueFunc := func() (*modelv3.TrendQueryResult, error) {
+		trendElements, err := s.TrendElementService.GetElementsByGranularity(ctx, server, sourceCategory, granularity)
+		if err != nil {
+			return nil, err
+		}
+		return s.convertTrendElementsToGranularTrendResult(ctx, granularity, trendElements)
+	}
+
+	var results modelv3.TrendQueryResult
+	key := server + constant.CacheSep + granularity + constant.CacheSep + sourceCategory
+	calculated, err := cache.ShimTrendResults.MutexGetSet(key, &results, valueFunc, 24*time.Hour)
+	if err != nil {
+		return nil, err
+	} else if calculated {
+		cache.LastModifiedTime.Set("[shimTrendResults#server|granularity|sourceCategory:"+key+"]", time.Now(), 0)
+	}
+	return &results, nil
+}
+
 func (s *Trend) RefreshTrendElements(ctx context.Context, server string, sourceCategories []string) error {
 	maxAccumulableTimeRanges, err := s.TimeRangeService.GetMaxAccumulableTimeRangesByServer(ctx, server)
 	if err != nil {
 		return err
 	}
+	stagesMapById, err := s.StageService.GetStagesMapById(ctx)
+	if err != nil {
+		return err
+	}
 
+	now := time.Now()
 	calcq := make([]map[string]any, 0)
 	for stageId, maxAccumulableTimeRangesForOneStage := range maxAccumulableTimeRanges {
 		itemIdsMapByTimeRange := make(map[string][]int)
@@ -125,40 +165,24 @@ func (s *Trend) RefreshTrendElements(ctx context.Context, server string, sourceC
 
 			itemIdsMapByTimeRange[combinedTimeRangeKey] = append(itemIdsMapByTimeRange[combinedTimeRangeKey], itemId)
 		}
+		granularities := s.getGranularitiesForStage(server, stagesMapById[stageId])
 		for rangeStr, itemIds := range itemIdsMapByTimeRange {
 			timeRange := model.TimeRangeFromString(rangeStr)
-			startTime := *timeRange.StartTime
-			endTime := *timeRange.EndTime
-
-			if endTime.After(time.Now()) {
-				endTime = time.Now()
-			}
-
-			startTime = gameday.StartTime(server, startTime)
-			if !gameday.IsStartTime(server, endTime) {
-				endTime = gameday.EndTime(server, endTime)
-			} else {
-				loc := constant.LocMap[server]
-				endTime = endTime.In(loc)
-			}
-
-			diff := int(endTime.Sub(startTime).Hours())
-			intervalNum := diff / 24
-			if diff%24 != 0 { // shouldn't happen actually
-				intervalNum++
-			}
+			for _, granularity := range granularities {
+				startTime, intervalLength, intervalNum := trendWindow(server, granularity, *timeRange.StartTime, *timeRange.EndTime, now)
+				if intervalNum <= 0 {
+					continue
+				}
 
-			if intervalNum > constant.DefaultIntervalNum {
-				intervalNum = constant.DefaultIntervalNum
-				startTime = endTime.Add(time.Hour * time.Duration((-1)*24*intervalNum))
+				calcq = append(calcq, map[string]any{
+					"stageId":        stageId,
+					"itemIds":        itemIds,
+					"startTime":      startTime,
+					"intervalLength": intervalLength,
+					"intervalNum":    intervalNum,
+					"granularity":    granularity,
+				})
 			}
-
-			calcq = append(calcq, map[string]any{
-				"stageId":     stageId,
-				"itemIds":     itemIds,
-				"startTime":   startTime,
-				"intervalNum": intervalNum,
-			})
 		}
 	}
 
@@ -166,14 +190,19 @@ func (s *Trend) RefreshTrendElements(ctx context.Context, server string, sourceC
 		stageId := m["stageId"].(int)
 		itemIds := m["itemIds"].([]int)
 		startTime := m["startTime"].(time.Time)
+		intervalLength := m["intervalLength"].(time.Duration)
 		intervalNum := m["intervalNum"].(int)
+		granularity := m["granularity"].(string)
 
 		currentBatch := make([]*model.TrendElement, 0)
 		for _, sourceCategory := range sourceCategories {
-			results, err := s.calcTrend(ctx, server, &startTime, time.Hour*24, intervalNum, []int{stageId}, itemIds, null.NewInt(0, false), sourceCategory)
+			results, err := s.calcTrend(ctx, server, &startTime, intervalLength, intervalNum, []int{stageId}, itemIds, null.NewInt(0, false), sourceCategory)
 			if err != nil {
 				return nil, err
 			}
+			for _, result := range results {
+				result.Granularity = granularity
+			}
 			currentBatch = append(currentBatch, results...)
 		}
 		return currentBatch, nil
@@ -185,9 +214,82 @@ func (s *Trend) RefreshTrendElements(ctx context.Context, server string, sourceC
 	if err := s.TrendElementService.BatchSaveElements(ctx, elements, server); err != nil {
 		return err
 	}
+	if err := s.deleteTrendResultsCache(server, sourceCategories); err != nil {
+		return err
+	}
 	return cache.ShimSavedTrendResults.Delete(server)
 }
 
+// BackfillTrendElements calculates trend elements of the granularity for all stages within the historical time window
+// [startTime, endTime), e.g. for time ranges that predate the trend worker. The elements are saved as backfilled elements,
+// replacing the ones previously backfilled within the window, and are kept when the trend worker refreshes its elements.
+func (s *Trend) BackfillTrendElements(ctx context.Context, server string, granularity string, startTime, endTime time.Time, sourceCategories []string) error {
+	intervalLength := trendIntervalLength(granularity)
+	startTime = alignTrendStartTime(server, granularity, startTime)
+	if !startTime.Before(endTime) {
+		return errors.New("start time must be before end time")
+	}
+
+	elements := make([]*model.TrendElement, 0)
+	for chunkStartTime := startTime; chunkStartTime.Before(endTime); chunkStartTime = chunkStartTime.Add(intervalLength * constant.MaxIntervalNum) {
+		intervalNum := int(math.Ceil(float64(endTime.Sub(chunkStartTime)) / float64(intervalLength)))
+		if intervalNum > constant.MaxIntervalNum {
+			intervalNum = constant.MaxIntervalNum
+		}
+		for _, sourceCategory := range sourceCategories {
+			chunkStartTime := chunkStartTime
+			results, err := s.calcTrend(ctx, server, &chunkStartTime, intervalLength, intervalNum, nil, nil, null.NewInt(0, false), sourceCategory)
+			if err != nil {
+				return errors.Wrap(err, "failed to backfill trend elements")
+			}
+			for _, result := range results {
+				result.Granularity = granularity
+				result.Backfilled = true
+			}
+			elements = append(elements, results...)
+		}
+		log.Info().
+			Str("evt.name", "trend.backfill.progress").
+			Str("server", server).
+			Str("granularity", granularity).
+			Time("chunkStartTime", chunkStartTime).
+			Int("elements", len(elements)).
+			Msg("backfilled a chunk of trend elements")
+	}
+
+	if err := s.TrendElementService.BatchSaveBackfilledElements(ctx, elements, server, granularity, sourceCategories, startTime, endTime); err != nil {
+		return err
+	}
+	return s.deleteTrendResultsCache(server, sourceCategories)
+}
+
+func (s *Trend) deleteTrendResultsCache(server string, sourceCategories []string) error {
+	for _, granularity := range []string{model.TrendGranularityHour, model.TrendGranularityDay, model.TrendGranularityWeek} {
+		for _, sourceCategory := range sourceCategories {
+			if err := cache.ShimTrendResults.Delete(server + constant.CacheSep + granularity + constant.CacheSep + sourceCategory); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getGranularitiesForStage returns the granularities to calculate for a stage. Daily trends are always included as
+// the v2 API depends on them.
+func (s *Trend) getGranularitiesForStage(server string, stage *model.Stage) []string {
+	stageType := ""
+	if stage != nil {
+		stageType = stage.StageType
+	}
+	granularities := []string{model.TrendGranularityDay}
+	for _, granularity := range s.TrendGranularities.Resolve(server, stageType) {
+		if !lo.Contains(granularities, granularity) {
+			granularities = append(granularities, granularity)
+		}
+	}
+	return granularities
+}
+
 func (s *Trend) getSavedTrendResults(ctx context.Context, server string, sourceCategory string) (*model.TrendQueryResult, error) {
 	trendElements, err := s.TrendElementService.GetElementsByServerAndSourceCategory(ctx, server, sourceCategory)
 	if err != nil {
@@ -397,6 +499,85 @@ func (s *Trend) convertTrendElementsToTrendQueryResult(trendElements []*model.Tr
 	return trendQueryResult, nil
 }
 
+// convertTrendElementsToGranularTrendResult places elements by their start time, instead of by their group id, so that
+// elements calculated in different batches (e.g. backfilled ones) can be combined. For elements of the same interval,
+// the ones calculated by the trend worker take precedence over backfilled ones.
+func (s *Trend) convertTrendElementsToGranularTrendResult(ctx context.Context, granularity string, trendElements []*model.TrendElement) (*modelv3.TrendQueryResult, error) {
+	itemsMapById, err := s.ItemService.GetItemsMapById(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stagesMapById, err := s.StageService.GetStagesMapById(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type elementKey struct {
+		stageId   int
+		itemId    int
+		startTime int64
+	}
+	picked := make(map[elementKey]*model.TrendElement, len(trendElements))
+	stageStartTimes := make(map[int]time.Time)
+	stageEndTimes := make(map[int]time.Time)
+	for _, el := range trendElements {
+		key := elementKey{stageId: el.StageID, itemId: el.ItemID, startTime: el.StartTime.UnixMilli()}
+		if existing, ok := picked[key]; ok && !existing.Backfilled {
+			continue
+		}
+		picked[key] = el
+		if startTime, ok := stageStartTimes[el.StageID]; !ok || el.StartTime.Before(startTime) {
+			stageStartTimes[el.StageID] = *el.StartTime
+		}
+		if endTime, ok := stageEndTimes[el.StageID]; !ok || el.StartTime.After(endTime) {
+			stageEndTimes[el.StageID] = *el.StartTime
+		}
+	}
+
+	intervalLength := trendIntervalLength(granularity)
+	// intervals are rounded to tolerate daylight saving time shifts
+	intervalIndex := func(from, to time.Time) int {
+		return int(math.Round(float64(to.Sub(from)) / float64(intervalLength)))
+	}
+
+	results := &modelv3.TrendQueryResult{
+		Granularity: granularity,
+		Trend:       make(map[string]*modelv2.StageTrend),
+	}
+	for key, el := range picked {
+		stage, ok := stagesMapById[key.stageId]
+		if !ok {
+			continue
+		}
+		item, ok := itemsMapById[key.itemId]
+		if !ok {
+			continue
+		}
+		stageStartTime := stageStartTimes[key.stageId]
+		stageTrend, ok := results.Trend[stage.ArkStageID]
+		if !ok {
+			stageTrend = &modelv2.StageTrend{
+				Results:   make(map[string]*modelv2.OneItemTrend),
+				StartTime: stageStartTime.UnixMilli(),
+			}
+			results.Trend[stage.ArkStageID] = stageTrend
+		}
+		itemTrend, ok := stageTrend.Results[item.ArkItemID]
+		if !ok {
+			intervalNum := intervalIndex(stageStartTime, stageEndTimes[key.stageId]) + 1
+			itemTrend = &modelv2.OneItemTrend{
+				Quantity: make([]int, intervalNum),
+				Times:    make([]int, intervalNum),
+			}
+			stageTrend.Results[item.ArkItemID] = itemTrend
+		}
+		idx := intervalIndex(stageStartTime, *el.StartTime)
+		itemTrend.Quantity[idx] = el.Quantity
+		itemTrend.Times[idx] = el.Times
+	}
+	return results, nil
+}
+
 func (s *Trend) applyShimForCustomizedTrendQuery(ctx context.Context, queryResult *model.TrendQueryResult, startTime *time.Time) (*modelv2.TrendQueryResult, error) {
 	itemsMapById, err := s.ItemService.GetItemsMapById(ctx)
 	if err != nil {
@@ -511,3 +692,73 @@ func (s *Trend) applyShimForSavedTrendQuery(ctx context.Context, server string,
 	}
 	return results, nil
 }
+
+func trendIntervalLength(granularity string) time.Duration {
+	switch granularity {
+	case model.TrendGranularityHour:
+		return time.Hour
+	case model.TrendGranularityWeek:
+		return time.Hour * 24 * 7
+	default:
+		return time.Hour * 24
+	}
+}
+
+// alignTrendStartTime aligns t to the start of the interval of granularity it falls in: an hour, a game day,
+// or a game week starting on Monday.
+func alignTrendStartTime(server string, granularity string, t time.Time) time.Time {
+	switch granularity {
+	case model.TrendGranularityHour:
+		return t.Truncate(time.Hour)
+	case model.TrendGranularityWeek:
+		t = gameday.StartTime(server, t)
+		return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	default:
+		return gameday.StartTime(server, t)
+	}
+}
+
+// trendWindow returns the start time, interval length and interval number of the trend of granularity to calculate
+// for a max-accumulable time range. Hourly trends cover the first days of the time range, while daily and weekly
+// trends cover its latest days and weeks.
+func trendWindow(server string, granularity string, startTime, endTime time.Time, now time.Time) (time.Time, time.Duration, int) {
+	if endTime.After(now) {
+		endTime = now
+	}
+	intervalLength := trendIntervalLength(granularity)
+
+	if granularity == model.TrendGranularityHour {
+		startTime = alignTrendStartTime(server, granularity, startTime)
+		intervalNum := int(math.Ceil(float64(endTime.Sub(startTime)) / float64(intervalLength)))
+		if intervalNum > TrendHourlyIntervalNum {
+			intervalNum = TrendHourlyIntervalNum
+		}
+		return startTime, intervalLength, intervalNum
+	}
+
+	startTime = alignTrendStartTime(server, granularity, startTime)
+	if !gameday.IsStartTime(server, endTime) {
+		endTime = gameday.EndTime(server, endTime)
+	} else {
+		loc := constant.LocMap[server]
+		endTime = endTime.In(loc)
+	}
+
+	diff := int(endTime.Sub(startTime).Hours())
+	intervalNum := diff / 24
+	if diff%24 != 0 { // shouldn't happen actually
+		intervalNum++
+	}
+
+	maxIntervalNum := constant.DefaultIntervalNum
+	if granularity == model.TrendGranularityWeek {
+		intervalNum = int(math.Ceil(float64(intervalNum) / 7))
+		maxIntervalNum = TrendWeeklyIntervalNum
+	}
+
+	if intervalNum > maxIntervalNum {
+		startTime = startTime.Add(intervalLength * time.Duration(intervalNum-maxIntervalNum))
+		intervalNum = maxIntervalNum
+	}
+	return startTime, intervalLength, intervalNum
+}
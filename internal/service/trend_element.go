@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"exusiai.dev/backend-next/internal/model"
 	"exusiai.dev/backend-next/internal/repo"
@@ -28,3 +29,13 @@ func (s *TrendElement) DeleteByServer(ctx context.Context, server string) error
 func (s *TrendElement) GetElementsByServerAndSourceCategory(ctx context.Context, server string, sourceCategory string) ([]*model.TrendElement, error) {
 	return s.TrendElementRepo.GetElementsByServerAndSourceCategory(ctx, server, sourceCategory)
 }
+
+func (s *TrendElement) BatchSaveBackfilledElements(
+	ctx context.Context, elements []*model.TrendElement, server string, granularity string, sourceCategories []string, startTime, endTime time.Time,
+) error {
+	return s.TrendElementRepo.BatchSaveBackfilledElements(ctx, elements, server, granularity, sourceCategories, startTime, endTime)
+}
+
+func (s *TrendElement) GetElementsByGranularity(ctx context.Context, server string, sourceCategory string, granularity string) ([]*model.TrendElement, error) {
+	return s.TrendElementRepo.GetElementsByGranularity(ctx, server, sourceCategory, granularity)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+	"gopkg.in/guregu/null.v3"
+
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model/types"
+)
+
+// WarmUp preloads the expensive result caches of the configured servers and source categories at startup,
+// so that the first requests to a new instance do not pay for the calculation. The instance is reported
+// as not ready in /health until the warm-up has finished or timed out.
+type WarmUp struct {
+	DropMatrixService    *DropMatrix
+	PatternMatrixService *PatternMatrix
+	TrendService         *Trend
+	SiteStatsService     *SiteStats
+
+	servers          []string
+	sourceCategories []string
+	timeout          time.Duration
+	ready            atomic.Bool
+}
+
+func NewWarmUp(conf *appconfig.Config, lc fx.Lifecycle, dropMatrixService *DropMatrix, patternMatrixService *PatternMatrix, trendService *Trend, siteStatsService *SiteStats) *WarmUp {
+	s := &WarmUp{
+		DropMatrixService:    dropMatrixService,
+		PatternMatrixService: patternMatrixService,
+		TrendService:         trendService,
+		SiteStatsService:     siteStatsService,
+		servers:              conf.CacheWarmUpServers,
+		sourceCategories:     conf.CacheWarmUpSourceCategories,
+		timeout:              conf.CacheWarmUpTimeout,
+	}
+
+	if !conf.CacheWarmUpEnabled {
+		s.ready.Store(true)
+		return s
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go s.run()
+			return nil
+		},
+	})
+
+	return s
+}
+
+// Ready returns whether the warm-up has finished or timed out.
+func (s *WarmUp) Ready() bool {
+	return s.ready.Load()
+}
+
+func (s *WarmUp) run() {
+	defer s.ready.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	start := time.Now()
+	log.Info().
+		Str("evt.name", "cache.warmup.started").
+		Strs("servers", s.servers).
+		Strs("sourceCategories", s.sourceCategories).
+		Msg("warming up result caches")
+
+	failed := 0
+	for _, server := range s.servers {
+		for _, step := range s.steps(server) {
+			if ctx.Err() != nil {
+				log.Warn().
+					Str("evt.name", "cache.warmup.timeout").
+					Dur("duration", time.Since(start)).
+					Msg("cache warm-up timed out. reporting ready with caches partially warmed up")
+				return
+			}
+			if err := step.fn(ctx); err != nil {
+				failed++
+				log.Warn().
+					Str("evt.name", "cache.warmup.failed").
+					Str("server", server).
+					Str("step", step.name).
+					Err(err).
+					Msg("failed to warm up cache")
+			}
+		}
+	}
+
+	log.Info().
+		Str("evt.name", "cache.warmup.finished").
+		Dur("duration", time.Since(start)).
+		Int("failed", failed).
+		Msg("result caches warmed up")
+}
+
+type warmUpStep struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func (s *WarmUp) steps(server string) []warmUpStep {
+	steps := make([]warmUpStep, 0)
+	for _, sourceCategory := range s.sourceCategories {
+		sourceCategory := sourceCategory
+		steps = append(steps,
+			warmUpStep{name: "dropMatrix|" + sourceCategory, fn: func(ctx context.Context) error {
+				for _, showClosedZones := range []bool{false, true} {
+					if _, err := s.DropMatrixService.GetShimMaxAccumulableDropMatrixResults(ctx, server, showClosedZones, "", "", null.NewInt(0, false), sourceCategory); err != nil {
+						return err
+					}
+				}
+				return nil
+			}},
+			warmUpStep{name: "patternMatrix|" + sourceCategory, fn: func(ctx context.Context) error {
+				for _, accumulable := range []bool{false, true} {
+					if _, err := s.PatternMatrixService.GetShimPatternMatrixResults(ctx, server, &types.PatternMatrixQuery{Accumulable: accumulable}, null.NewInt(0, false), sourceCategory); err != nil {
+						return err
+					}
+				}
+				return nil
+			}},
+		)
+	}
+	steps = append(steps,
+		warmUpStep{name: "trend", fn: func(ctx context.Context) error {
+			_, err := s.TrendService.GetShimSavedTrendResults(ctx, server)
+			return err
+		}},
+		warmUpStep{name: "siteStats", fn: func(ctx context.Context) error {
+			_, err := s.SiteStatsService.GetShimSiteStats(ctx, server)
+			return err
+		}},
+	)
+	return steps
+}
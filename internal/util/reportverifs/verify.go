@@ -6,6 +6,7 @@ import (
 
 	"go.opentelemetry.io/otel"
 
+	"exusiai.dev/backend-next/internal/model"
 	"exusiai.dev/backend-next/internal/model/types"
 	"exusiai.dev/backend-next/internal/pkg/observability"
 )
@@ -17,35 +18,63 @@ type Verifier interface {
 	Verify(ctx context.Context, report *types.ReportTaskSingleReport, reportTask *types.ReportTask) *Rejection
 }
 
-type ReportVerifiers []Verifier
+// ExpensiveVerifier is implemented by the verifiers that are expensive to run, which the reports of trusted
+// accounts skip.
+type ExpensiveVerifier interface {
+	Verifier
+	Expensive() bool
+}
+
+type ReportVerifiers struct {
+	UserVerifier *UserVerifier
+
+	// verifiers run against every report of a task, after UserVerifier has verified the account of the task once
+	verifiers []Verifier
+}
 
 func NewReportVerifier(userVerifier *UserVerifier, dropVerifier *DropVerifier, md5Verifier *MD5Verifier, rejectRuleVerifier *RejectRuleVerifier) *ReportVerifiers {
 	return &ReportVerifiers{
-		userVerifier,
-		md5Verifier,
-		dropVerifier,
-		rejectRuleVerifier,
+		UserVerifier: userVerifier,
+		verifiers: []Verifier{
+			md5Verifier,
+			dropVerifier,
+			rejectRuleVerifier,
+		},
 	}
 }
 
-func (verifiers ReportVerifiers) Verify(ctx context.Context, reportTask *types.ReportTask) (violations Violations) {
+func (v *ReportVerifiers) Verify(ctx context.Context, reportTask *types.ReportTask) (violations Violations) {
 	violations = map[int]*Violation{}
 
-	for reportIndex, report := range reportTask.Reports {
-		for _, pipe := range verifiers {
-			start := time.Now()
+	var account *model.Account
+	userRejection := v.run(ctx, v.UserVerifier.Name(), func(ctx context.Context) (rejection *Rejection) {
+		account, rejection = v.UserVerifier.Account(ctx, reportTask.AccountID)
+		return rejection
+	})
+	if userRejection != nil {
+		for reportIndex := range reportTask.Reports {
+			violations[reportIndex] = &Violation{
+				Name:      v.UserVerifier.Name(),
+				Rejection: *userRejection,
+			}
+		}
 
-			name := pipe.Name()
+		return violations
+	}
 
-			ctx, span := tracer.
-				Start(ctx, "reportverifs.verifier."+name)
+	trusted := v.UserVerifier.Trusted(account)
 
-			rejection := pipe.Verify(ctx, report, reportTask)
-			span.End()
+	for reportIndex, report := range reportTask.Reports {
+		for _, pipe := range v.verifiers {
+			if expensive, ok := pipe.(ExpensiveVerifier); ok && trusted && expensive.Expensive() {
+				continue
+			}
+
+			name := pipe.Name()
 
-			observability.ReportVerifyDuration.
-				WithLabelValues(name).
-				Observe(time.Since(start).Seconds())
+			rejection := v.run(ctx, name, func(ctx context.Context) *Rejection {
+				return pipe.Verify(ctx, report, reportTask)
+			})
 
 			if rejection != nil {
 				violations[reportIndex] = &Violation{
@@ -60,3 +89,19 @@ func (verifiers ReportVerifiers) Verify(ctx context.Context, reportTask *types.R
 
 	return violations
 }
+
+func (v *ReportVerifiers) run(ctx context.Context, name string, verify func(ctx context.Context) *Rejection) *Rejection {
+	start := time.Now()
+
+	ctx, span := tracer.
+		Start(ctx, "reportverifs.verifier."+name)
+
+	rejection := verify(ctx)
+	span.End()
+
+	observability.ReportVerifyDuration.
+		WithLabelValues(name).
+		Observe(time.Since(start).Seconds())
+
+	return rejection
+}
@@ -29,6 +29,11 @@ func (u *MD5Verifier) Name() string {
 	return "md5"
 }
 
+// Expensive is true as the md5 of every report is looked up in the database.
+func (u *MD5Verifier) Expensive() bool {
+	return true
+}
+
 func (u *MD5Verifier) Verify(ctx context.Context, report *types.ReportTaskSingleReport, reportTask *types.ReportTask) *Rejection {
 	if report.Metadata != nil && report.Metadata.MD5 != "" && u.DropReportExtraRepo.IsDropReportExtraMD5Exist(ctx, report.Metadata.MD5) {
 		return &Rejection{
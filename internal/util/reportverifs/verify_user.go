@@ -2,29 +2,37 @@ package reportverifs
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/pkg/errors"
 
+	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model"
 	"exusiai.dev/backend-next/internal/model/types"
 	"exusiai.dev/backend-next/internal/repo"
 	"exusiai.dev/gommon/constant"
 )
 
 var (
-	ErrAccountIDEmpty  = errors.New("account id is empty")
-	ErrAccountNotFound = errors.New("account not found with given id")
+	ErrAccountIDEmpty      = errors.New("account id is empty")
+	ErrAccountNotFound     = errors.New("account not found with given id")
+	ErrAccountBanned       = errors.New("account is banned")
+	ErrAccountShadowbanned = errors.New("account is shadowbanned")
 )
 
 type UserVerifier struct {
 	AccountRepo *repo.Account
+
+	trustedScore int
 }
 
 // ensure UserVerifier conforms to Verifier
 var _ Verifier = (*UserVerifier)(nil)
 
-func NewUserVerifier(accountRepo *repo.Account) *UserVerifier {
+func NewUserVerifier(accountRepo *repo.Account, conf *appconfig.Config) *UserVerifier {
 	return &UserVerifier{
-		AccountRepo: accountRepo,
+		AccountRepo:  accountRepo,
+		trustedScore: conf.AccountTrustedScore,
 	}
 }
 
@@ -33,18 +41,45 @@ func (u *UserVerifier) Name() string {
 }
 
 func (u *UserVerifier) Verify(ctx context.Context, report *types.ReportTaskSingleReport, reportTask *types.ReportTask) *Rejection {
-	id := reportTask.AccountID
+	_, rejection := u.Account(ctx, reportTask.AccountID)
+	return rejection
+}
+
+// Account loads the account and maps its status to a rejection, so that ReportVerifiers loads it once per task
+// instead of once per report.
+func (u *UserVerifier) Account(ctx context.Context, id int) (*model.Account, *Rejection) {
 	if id == 0 {
-		return &Rejection{
+		return nil, &Rejection{
 			Reliability: constant.ViolationReliabilityUser,
 			Message:     ErrAccountIDEmpty.Error(),
 		}
 	}
-	if !u.AccountRepo.IsAccountExistWithId(ctx, id) {
-		return &Rejection{
+	account, err := u.AccountRepo.GetAccountById(ctx, strconv.Itoa(id))
+	if err != nil {
+		return nil, &Rejection{
 			Reliability: constant.ViolationReliabilityUser,
 			Message:     ErrAccountNotFound.Error(),
 		}
 	}
-	return nil
+	switch account.Status {
+	case model.AccountStatusBanned:
+		return account, &Rejection{
+			Reliability: ViolationReliabilityBanned,
+			Message:     ErrAccountBanned.Error(),
+		}
+	case model.AccountStatusShadowbanned:
+		return account, &Rejection{
+			Reliability: ViolationReliabilityShadowbanned,
+			Message:     ErrAccountShadowbanned.Error(),
+		}
+	}
+	return account, nil
+}
+
+// Trusted returns whether the account is active and scores at least the trusted score.
+func (u *UserVerifier) Trusted(account *model.Account) bool {
+	if account == nil {
+		return false
+	}
+	return (account.Status == "" || account.Status == model.AccountStatusActive) && account.TrustScore >= u.trustedScore
 }
@@ -1,6 +1,23 @@
 package reportverifs
 
-import "bytes"
+import (
+	"bytes"
+
+	"exusiai.dev/gommon/constant"
+)
+
+// the reliabilities below extend the ones of exusiai.dev/gommon/constant and are only defined here.
+const (
+	// ViolationReliabilityShadowbanned is the reliability of the reports of shadowbanned accounts, which are kept
+	// out of the global stats while still showing in the personal ones of their accounts.
+	ViolationReliabilityShadowbanned = 1 << 10
+	// ViolationReliabilityBanned is the reliability of the reports of banned accounts that have been submitted
+	// before their accounts are banned.
+	ViolationReliabilityBanned = 1 << 11
+)
+
+// fails to compile once exusiai.dev/gommon/constant defines a reliability that reaches the ones above
+var _ = [ViolationReliabilityShadowbanned - constant.ViolationReliabilityMD5 - 1]struct{}{}
 
 type Violations map[int]*Violation
 
@@ -0,0 +1,25 @@
+package reportverifs
+
+import (
+	"testing"
+
+	"exusiai.dev/gommon/constant"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViolationReliabilitiesDoNotCollide(t *testing.T) {
+	gommon := []int{
+		constant.ViolationReliabilityUser,
+		constant.ViolationReliabilityRejectRuleUnexpected,
+		constant.ViolationReliabilityDrop,
+		constant.ViolationReliabilityMD5,
+	}
+
+	for _, reliability := range []int{ViolationReliabilityShadowbanned, ViolationReliabilityBanned} {
+		assert.NotContains(t, gommon, reliability)
+		// reject rules may reject reports with any reliability within their range
+		assert.False(t, reliability >= constant.ViolationReliabilityRejectRuleRangeLeast &&
+			reliability < constant.ViolationReliabilityRejectRuleRangeMost, "reliability %d is within the reject rule range", reliability)
+	}
+	assert.NotEqual(t, ViolationReliabilityShadowbanned, ViolationReliabilityBanned)
+}
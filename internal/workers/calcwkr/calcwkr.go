@@ -11,8 +11,10 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"go.uber.org/fx"
+	"gopkg.in/guregu/null.v3"
 
 	"exusiai.dev/backend-next/internal/app/appconfig"
+	"exusiai.dev/backend-next/internal/model/types"
 	"exusiai.dev/backend-next/internal/service"
 )
 
@@ -22,6 +24,10 @@ type WorkerDeps struct {
 	PatternMatrixService *service.PatternMatrix
 	TrendService         *service.Trend
 	SiteStatsService     *service.SiteStats
+	BiasDetectionService *service.BiasDetection
+	SnapshotService      *service.Snapshot
+	LiveHouseService     *service.LiveHouse
+	CacheInvalidation    *service.CacheInvalidation
 	RedSync              *redsync.Redsync
 }
 
@@ -41,6 +47,12 @@ type Worker struct {
 	// timeout describes the timeout for the worker
 	timeout time.Duration
 
+	// biasDetection describes whether to detect biases in-between automated and manual reports after every stats batch
+	biasDetection bool
+
+	// snapshot describes whether to snapshot the rendered payloads of incremental realms after every batch
+	snapshot bool
+
 	// heartbeatURL allows the worker to ping a specified URL on succeed, to ensure worker is alive.
 	// The key is the name of the worker, and the value is the URL.
 	// Possible keys are: "stats", "trends"
@@ -90,6 +102,8 @@ func Start(conf *appconfig.Config, deps WorkerDeps) {
 			interval:      conf.WorkerInterval,
 			trendInterval: conf.WorkerTrendInterval,
 			timeout:       conf.WorkerTimeout,
+			biasDetection: conf.WorkerBiasDetectionEnabled,
+			snapshot:      conf.WorkerSnapshotEnabled,
 			heartbeatURL:  conf.WorkerHeartbeatURL,
 			syncMutex:     deps.RedSync.NewMutex("mutex:calcwkr", redsync.WithExpiry(30*time.Second), redsync.WithTries(2)),
 			WorkerDeps:    deps,
@@ -129,12 +143,21 @@ func (w *Worker) doMainCalc(sourceCategories []string) {
 	w.task(context.Background(), WorkerCalcTypeStatsCalc, func(ctx context.Context, server string) error {
 		var err error
 
-		// DropMatrixService
+		// DropMatrixService: the matrix is refreshed up to a watermark, so that LiveHouse can tell the reports
+		// created afterwards apart from the ones accounted for in the matrix of the generation
+		watermark := time.Now().Truncate(time.Second)
+		generation := w.LiveHouseService.Generation(watermark)
 		if err = w.microtask(ctx, WorkerCalcTypeStatsCalc, "dropMatrix", server, func() error {
-			return w.DropMatrixService.RefreshAllDropMatrixElements(ctx, server, sourceCategories)
+			return w.DropMatrixService.RefreshAllDropMatrixElementsUntil(ctx, server, sourceCategories, watermark)
 		}); err != nil {
 			return err
 		}
+		w.invalidate(ctx, server, "shimMaxAccumulableDropMatrixResults#server|showClosedZoned|sourceCategory")
+
+		// LiveHouseService: a failed push only delays the live matrix to the next generation
+		_ = w.microtask(ctx, WorkerCalcTypeStatsCalc, "liveHouse", server, func() error {
+			return w.LiveHouseService.PushMatrix(ctx, server, generation)
+		})
 		time.Sleep(w.sep)
 
 		// PatternMatrixService
@@ -143,6 +166,7 @@ func (w *Worker) doMainCalc(sourceCategories []string) {
 		}); err != nil {
 			return err
 		}
+		w.invalidate(ctx, server, "shimLatestPatternMatrixResults#server|sourceCategory", "shimMaxAccumulablePatternMatrixResults#server|sourceCategory")
 		time.Sleep(w.sep)
 
 		// SiteStatsService
@@ -152,6 +176,28 @@ func (w *Worker) doMainCalc(sourceCategories []string) {
 		}); err != nil {
 			return err
 		}
+		w.invalidate(ctx, server, "shimSiteStats#server")
+
+		// SnapshotService
+		if w.snapshot {
+			time.Sleep(w.sep)
+			if err = w.microtask(ctx, WorkerCalcTypeStatsCalc, "snapshot", server, func() error {
+				return w.SnapshotService.SnapshotRealms(ctx, server, []string{service.SnapshotRealmMatrix, service.SnapshotRealmPattern})
+			}); err != nil {
+				return err
+			}
+		}
+
+		// BiasDetectionService
+		if w.biasDetection {
+			time.Sleep(w.sep)
+			if err = w.microtask(ctx, WorkerCalcTypeStatsCalc, "biasDetection", server, func() error {
+				_, err := w.BiasDetectionService.RefreshBiasReport(ctx, server)
+				return err
+			}); err != nil {
+				return err
+			}
+		}
 
 		return nil
 	})
@@ -167,6 +213,17 @@ func (w *Worker) doTrendCalc(sourceCategories []string) {
 		}); err != nil {
 			return err
 		}
+		w.invalidate(ctx, server, "shimSavedTrendResults#server", "shimTrendResults#server|granularity|sourceCategory")
+
+		// SnapshotService
+		if w.snapshot {
+			time.Sleep(w.sep)
+			if err = w.microtask(ctx, WorkerCalcTypeTrendsCalc, "snapshot", server, func() error {
+				return w.SnapshotService.SnapshotRealms(ctx, server, []string{service.SnapshotRealmTrend})
+			}); err != nil {
+				return err
+			}
+		}
 
 		return nil
 	})
@@ -286,6 +343,33 @@ func (w *Worker) microtask(ctx context.Context, typ WorkerCalcType, service, ser
 	return nil
 }
 
+// invalidate flushes the caches of server refreshed by the worker on every instance, and purges the responses
+// served from them from the CDN once every instance has acknowledged, so that the CDN cannot be refilled with
+// the stale results of an instance yet to flush. Failures are logged only, as the caches would expire eventually.
+func (w *Worker) invalidate(ctx context.Context, server string, names ...string) {
+	pairs := make([]types.PurgeCachePair, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, types.PurgeCachePair{Name: name, Key: null.StringFrom(server)})
+	}
+
+	result, err := w.CacheInvalidation.Invalidate(ctx, pairs)
+	if err != nil {
+		log.Ctx(ctx).Warn().
+			Err(err).
+			Str("evt.name", "worker.calcwkr.cache_invalidation.failed").
+			Str("server", server).
+			Msg("failed to invalidate caches after refreshing")
+		return
+	}
+	if result.PublishError != "" || len(result.Errors) > 0 || result.Acknowledged < result.Receivers {
+		log.Ctx(ctx).Warn().
+			Str("evt.name", "worker.calcwkr.cache_invalidation.incomplete").
+			Str("server", server).
+			Interface("result", result).
+			Msg("not every instance has acknowledged the cache invalidation after refreshing")
+	}
+}
+
 func (w *Worker) heartbeat(typ WorkerCalcType) {
 	url := typ.URL(w)
 	if url == "" {
@@ -226,6 +226,10 @@ func (w *Worker) process(ctx context.Context, reportTask *types.ReportTask) erro
 		}
 	}()
 
+	// reports pushed to LiveHouse once the transaction is committed
+	var live []*types.ReportTaskSingleReport
+	var liveStageIds []uint32
+
 	// calculate drop pattern hash for each report
 	for idx, report := range reportTask.Reports {
 		report.Drops = reportutil.MergeDropsByItemID(report.Drops)
@@ -287,9 +291,8 @@ func (w *Worker) process(ctx context.Context, reportTask *types.ReportTask) erro
 		}
 
 		if reliability == 0 {
-			if err := w.LiveHouseService.PushReport(report, uint32(stage.StageID), reportTask.Server); err != nil {
-				L.Warn().Err(err).Msg("failed to push report to LiveHouse")
-			}
+			live = append(live, report)
+			liveStageIds = append(liveStageIds, uint32(stage.StageID))
 		}
 	}
 
@@ -298,5 +301,11 @@ func (w *Worker) process(ctx context.Context, reportTask *types.ReportTask) erro
 		return errors.Wrap(err, "failed to commit transaction")
 	}
 
+	for i, report := range live {
+		if err := w.LiveHouseService.PushReport(report, liveStageIds[i], reportTask.Server, taskCreatedAt); err != nil {
+			L.Warn().Err(err).Msg("failed to push report to LiveHouse")
+		}
+	}
+
 	return nil
 }